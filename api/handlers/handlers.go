@@ -1,9 +1,8 @@
 package handlers
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
-	"math"
 	"net/http"
 	"realty/api/middleware"
 	"realty/application"
@@ -15,6 +14,7 @@ import (
 	"realty/models"
 	"realty/moderation"
 	"realty/parsing_input"
+	"realty/ratelimit"
 	"realty/render"
 	"realty/utils"
 	"realty/validator"
@@ -23,6 +23,12 @@ import (
 	"time"
 )
 
+// размер списка похожих объявлений
+const (
+	defaultSimilarLimit = 6
+	maxSimilarLimit     = 20
+)
+
 func TextError(recovered any, rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) {
 	writer.WriteHeader(http.StatusInternalServerError)
 	_, _ = writer.Write(utils.UnsafeStringToBytes("Internal error, requestId=" + strconv.FormatInt(rd.RequestId, 10)))
@@ -39,23 +45,50 @@ func JsonOK(rd *chain.RequestData, writer http.ResponseWriter, request *http.Req
 func GetMetrics(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
 	//todo надо еще добавить метрики из пакета metrics или pprof задействовать
 	m := dto.Metrics{
-		InstanceStartTime:        application.GetInstanceStartTime().Format("2006/01/02 15:04:05"),
-		InstanceCurrentTime:      time.Now().Format("2006/01/02 15:04:05"),
-		IsGracefullyStopped:      application.IsGracefullyStopped(),
-		GracefullyStopTime:       application.GetGracefullyStopTime(),
-		UnSavedChangesQueueCount: cache.GetToSaveCount(),
-		DbErrorCount:             application.GetDbErrorsCount(),
-		RecoveredPanicsCount:     application.GetRecoveredPanicsCount(),
-		Hits:                     application.GetHitsMap(),
+		InstanceStartTime:          application.GetInstanceStartTime().Format("2006/01/02 15:04:05"),
+		InstanceCurrentTime:        time.Now().Format("2006/01/02 15:04:05"),
+		IsGracefullyStopped:        application.IsGracefullyStopped(),
+		GracefullyStopTime:         application.GetGracefullyStopTime(),
+		CurrencyRatesReloadTime:    currency.GetLastReloadTime(),
+		UnSavedChangesQueueCount:   cache.GetToSaveCount(),
+		DbErrorCount:               application.GetDbErrorsCount(),
+		RecoveredPanicsCount:       application.GetRecoveredPanicsCount(),
+		LockedAccountsCount:        ratelimit.LoginFailuresByAccount.LockedCount(),
+		LockedIpsCount:             ratelimit.LoginFailuresByIp.LockedCount(),
+		LockedRegistrationIpsCount: ratelimit.RegistrationFailuresByIp.LockedCount(),
+		Hits:                       application.GetHitsMap(),
 	}
 	return render.Json(writer, http.StatusOK, &m)
 }
 
+// ClearLoginLockout key - email или IP
+func ClearLoginLockout(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	key := request.PathValue("key")
+	if strings.Contains(key, "@") {
+		key = utils.NormalizeEmail(key)
+	}
+	if !ratelimit.ClearLoginLockout(key) {
+		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "блокировка не найдена"})
+	}
+	return render.Json(writer, http.StatusOK, render.ResultOK)
+}
+
+func ReloadCurrencyRates(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	if err := currency.Reload(); err != nil {
+		return render.Json(writer, http.StatusInternalServerError, &dto.Err{ErrMessage: err.Error(), RequestId: rd.RequestId})
+	}
+	return render.Json(writer, http.StatusOK, render.ResultOK)
+}
+
 func GenerateId(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
 	return render.Json(writer, http.StatusOK, &dto.GenerateIdResponse{Id: utils.GenerateId()})
 }
 
 func LogoutMe(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	//завершаем сессию на сервере, чтобы скопированный токен перестал действовать
+	if rd.Session != nil {
+		cache.DeleteSession(rd.RequestId, rd.Session)
+	}
 	http.SetCookie(writer, &http.Cookie{
 		SameSite: http.SameSiteStrictMode,
 		Name:     "auth_token",
@@ -74,11 +107,62 @@ func LogoutAll(rd *chain.RequestData, writer http.ResponseWriter, request *http.
 	return render.Json(writer, http.StatusOK, render.ResultOK)
 }
 
+func GetSessionList(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	var currentSessionId int64
+	if rd.Session != nil {
+		currentSessionId = rd.Session.Session.Id
+	}
+	return render.Json(writer, http.StatusOK, &dto.GetSessionListResponse{List: cache.FindUserSessions(rd.User.CurrentUser.Id, currentSessionId)})
+}
+
+func DeleteSession(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	cache.DeleteSession(rd.RequestId, rd.TargetSession)
+	return render.Json(writer, http.StatusOK, render.ResultOK)
+}
+
+func GetApiKeyList(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	return render.Json(writer, http.StatusOK, &dto.GetApiKeyListResponse{List: cache.FindUserApiKeys(rd.User.CurrentUser.Id)})
+}
+
+func CreateApiKey(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	requestDto := &dto.CreateApiKeyRequest{}
+	if err := parsing_input.ParseRawJson(request, requestDto); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
+	}
+	if err := validator.ValidateCreateApiKeyRequest(requestDto); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
+	}
+	if result := middleware.CheckConnectionAndTimeout(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	var scopes uint8
+	for _, scope := range requestDto.Scopes {
+		scopes |= models.ApiScopes[scope]
+	}
+	apiKey, key, err := cache.CreateApiKey(rd.RequestId, rd.User.CurrentUser.Id, requestDto.Name, scopes)
+	if errors.Is(err, cache.ErrApiKeysLimit) {
+		return render.Json(writer, http.StatusConflict, &dto.Err{ErrMessage: fmt.Sprintf("нельзя создать больше %d API ключей", cache.MaxApiKeysPerUser)})
+	}
+	if err != nil {
+		return render.Json(writer, http.StatusInternalServerError, &dto.Err{ErrMessage: err.Error()})
+	}
+	return render.Json(writer, http.StatusOK, &dto.CreateApiKeyResponse{Id: apiKey.ApiKey.Id, Key: key, RequestId: rd.RequestId})
+}
+
+func DeleteApiKey(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	cache.DeleteApiKey(rd.RequestId, rd.TargetApiKey)
+	return render.Json(writer, http.StatusOK, render.ResultOK)
+}
+
 func Registration(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
 	requestDto := &dto.RegisterRequest{}
 	if err := parsing_input.ParseRawJson(request, requestDto); err != nil {
 		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
 	}
+	requestDto.Email = utils.NormalizeEmail(requestDto.Email)
 	if err := validator.ValidateRegisterRequest(requestDto); err != nil {
 		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
 	}
@@ -88,9 +172,92 @@ func Registration(rd *chain.RequestData, writer http.ResponseWriter, request *ht
 	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
 		return result
 	}
-	cache.CreateUser(rd.RequestId, requestDto)
+	if err := cache.CreateUser(rd.RequestId, requestDto); err != nil {
+		switch {
+		case errors.Is(err, cache.ErrEmailExists):
+			return render.Json(writer, http.StatusConflict, &dto.Err{ErrMessage: "пользователь с таким email уже зарегистрирован"})
+		case errors.Is(err, cache.ErrInviteRequired):
+			return render.Json(writer, http.StatusForbidden, &dto.Err{ErrMessage: "регистрация только по приглашению"})
+		case errors.Is(err, cache.ErrInviteNotFound):
+			return render.Json(writer, http.StatusForbidden, &dto.Err{ErrMessage: "приглашение не найдено"})
+		case errors.Is(err, cache.ErrInviteExpired):
+			return render.Json(writer, http.StatusForbidden, &dto.Err{ErrMessage: "срок действия приглашения истек"})
+		case errors.Is(err, cache.ErrInviteUsed):
+			return render.Json(writer, http.StatusForbidden, &dto.Err{ErrMessage: "приглашение уже использовано"})
+		}
+		return render.Json(writer, http.StatusInternalServerError, &dto.Err{ErrMessage: err.Error(), RequestId: rd.RequestId})
+	}
+	return render.Json(writer, http.StatusOK, render.ResultOK)
+
+}
+
+func VerifyEmail(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	token := request.URL.Query().Get("token")
+	if err := validator.ValidateVerifyToken(token); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
+	}
+	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	if err := cache.VerifyEmail(rd.RequestId, token); err != nil {
+		if errors.Is(err, cache.ErrVerifyTokenExpired) {
+			return render.Json(writer, http.StatusGone, &dto.Err{ErrMessage: "срок действия ссылки истек"})
+		}
+		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "ссылка недействительна"})
+	}
+	return render.Json(writer, http.StatusOK, render.ResultOK)
+}
+
+func ResendVerificationEmail(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	if err := cache.ResendVerificationEmail(rd.RequestId, rd.User); err != nil {
+		return render.Json(writer, http.StatusConflict, &dto.Err{ErrMessage: "email уже подтвержден"})
+	}
 	return render.Json(writer, http.StatusOK, render.ResultOK)
+}
 
+// ForgotPassword отвечает одинаково, есть такой email или нет, чтобы по ответу нельзя было проверить регистрацию
+func ForgotPassword(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	requestDto := &dto.ForgotPasswordRequest{}
+	if err := parsing_input.ParseRawJson(request, requestDto); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
+	}
+	requestDto.Email = utils.NormalizeEmail(requestDto.Email)
+	if err := validator.ValidateForgotPasswordRequest(requestDto); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
+	}
+	if !ratelimit.PasswordResetByEmail.Allow(requestDto.Email) {
+		return render.Json(writer, http.StatusTooManyRequests, &dto.Err{ErrMessage: "слишком много запросов, попробуйте позже"})
+	}
+	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	if userCache := cache.FindUserCacheByLogin(requestDto.Email); userCache != nil {
+		cache.RequestPasswordReset(rd.RequestId, userCache)
+	}
+	return render.Json(writer, http.StatusOK, render.ResultOK)
+}
+
+func ResetPassword(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	requestDto := &dto.ResetPasswordRequest{}
+	if err := parsing_input.ParseRawJson(request, requestDto); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
+	}
+	if err := validator.ValidateResetPasswordRequest(requestDto); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
+	}
+	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	if err := cache.ResetPassword(rd.RequestId, requestDto.Token, requestDto.NewPassword); err != nil {
+		if errors.Is(err, cache.ErrResetTokenExpired) {
+			return render.Json(writer, http.StatusGone, &dto.Err{ErrMessage: "срок действия ссылки истек"})
+		}
+		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "ссылка недействительна"})
+	}
+	return render.Json(writer, http.StatusOK, render.ResultOK)
 }
 
 func UpdatePassword(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
@@ -101,7 +268,7 @@ func UpdatePassword(rd *chain.RequestData, writer http.ResponseWriter, request *
 	if err := validator.ValidateUpdatePasswordRequest(requestDto); err != nil {
 		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
 	}
-	if !bytes.Equal(rd.User.CurrentUser.PasswordHash, utils.GeneratePasswordHash(requestDto.OldPassword)) {
+	if ok, _ := utils.CheckPassword(requestDto.OldPassword, rd.User.CurrentUser.PasswordHash); !ok {
 		return render.Json(writer, http.StatusUnauthorized, &dto.Err{ErrMessage: "неверный пароль"})
 	}
 	if result := middleware.CheckConnectionAndTimeout(rd, writer, request); result != chain.Next() {
@@ -150,15 +317,91 @@ func CreateAdv(rd *chain.RequestData, writer http.ResponseWriter, request *http.
 	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
 		return result
 	}
-	advId := cache.CreateAdv(rd.RequestId, &rd.User.CurrentUser, requestDto)
+	duplicateOf, errMessage := checkDuplicate(rd.User.CurrentUser.Id, &models.Adv{
+		Price:        requestDto.Price,
+		Currency:     requestDto.Currency,
+		Latitude:     requestDto.Latitude,
+		Longitude:    requestDto.Longitude,
+		Title:        requestDto.Title,
+		Description:  requestDto.Description,
+		Address:      requestDto.Address,
+		DealType:     requestDto.DealType,
+		PropertyType: requestDto.PropertyType,
+		Rooms:        requestDto.Rooms,
+		Floor:        requestDto.Floor,
+	})
+	if errMessage != "" {
+		return render.Json(writer, http.StatusConflict, &dto.Err{ErrMessage: errMessage})
+	}
+	advId := cache.CreateAdv(rd.RequestId, &rd.User.CurrentUser, requestDto, duplicateOf)
 	return render.Json(writer, http.StatusOK, &dto.CreateAdvResponse{RequestId: rd.RequestId, AdvId: advId})
 }
 
+// checkDuplicate повтор своего же объявления отклоняется, повтор чужого публикуется со ссылкой на оригинал.
+// Возвращает id оригинала или текст ошибки для ответа 409
+func checkDuplicate(userId int64, adv *models.Adv) (int64, string) {
+	original := cache.FindDuplicateAdv(adv)
+	if original == nil {
+		return 0, ""
+	}
+	if original.CurrentAdv.UserId == userId {
+		return 0, fmt.Sprintf("такое объявление уже размещено: %d", original.CurrentAdv.Id)
+	}
+	return original.CurrentAdv.Id, ""
+}
+
+func GetDuplicates(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	clusters := cache.FindDuplicateClusters()
+	return render.Json(writer, http.StatusOK, &dto.GetDuplicatesResponse{Count: len(clusters), Clusters: clusters})
+}
+
+func CreateInvite(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	requestDto := &dto.CreateInviteRequest{}
+	if err := parsing_input.ParseRawJson(request, requestDto); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
+	}
+	if err := validator.ValidateCreateInviteRequest(requestDto); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
+	}
+	if result := middleware.CheckConnectionAndTimeout(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	inviteId := cache.CreateInvite(rd.RequestId, rd.User.CurrentUser.Id, requestDto)
+	return render.Json(writer, http.StatusOK, &dto.CreateInviteResponse{InviteId: inviteId, RequestId: rd.RequestId})
+}
+
+func GetInviteList(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	list := cache.FindInvites()
+	return render.Json(writer, http.StatusOK, &dto.GetInviteListResponse{Count: len(list), List: list})
+}
+
+func RevokeInvite(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	invite := cache.FindInviteCacheById(request.PathValue("inviteId"))
+	if invite == nil {
+		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "приглашение не найдено"})
+	}
+	if result := middleware.CheckConnectionAndTimeout(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	cache.RevokeInvite(rd.RequestId, invite)
+	return render.Json(writer, http.StatusOK, render.ResultOK)
+}
+
 func GetAdv(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
 	adv := rd.Adv.CurrentAdv
 	if !adv.Approved {
 		return render.Json(writer, http.StatusLocked, &dto.Err{ErrMessage: "объявление на проверке"})
 	}
+	displayCurrency := getDisplayCurrency(request, request.URL.Query().Get("displayCurrency"))
+	if err := validator.ValidateDisplayCurrency(displayCurrency); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: "displayCurrency: " + err.Error()})
+	}
 	if !adv.SeVisible {
 		//todo
 	}
@@ -186,7 +429,19 @@ func GetAdv(rd *chain.RequestData, writer http.ResponseWriter, request *http.Req
 		Watches:      rd.Adv.Watches.Watches.Count,
 		SeVisible:    adv.SeVisible,
 		UserComment:  adv.UserComment,
-	}
+		DealType:     adv.DealType,
+		RentPeriod:   adv.RentPeriod,
+		PropertyType: adv.PropertyType,
+		Rooms:        adv.Rooms,
+		Floor:        adv.Floor,
+		Floors:       adv.Floors,
+		YearBuilt:    adv.YearBuilt,
+		TotalArea:    adv.TotalArea,
+		LivingArea:   adv.LivingArea,
+		DuplicateOf:  adv.DuplicateOf,
+		SimilarUrl:   fmt.Sprintf("/adv/%d/similar", adv.Id),
+	}
+	setDisplayPrice(response, displayCurrency)
 	if result := middleware.CheckConnectionAndTimeout(rd, writer, request); result != chain.Next() {
 		return result
 	}
@@ -194,65 +449,144 @@ func GetAdv(rd *chain.RequestData, writer http.ResponseWriter, request *http.Req
 	return render.Json(writer, http.StatusOK, response)
 }
 
+func GetSimilarAdvs(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	if !rd.Adv.CurrentAdv.Approved {
+		return render.Json(writer, http.StatusLocked, &dto.Err{ErrMessage: "объявление на проверке"})
+	}
+	query := request.URL.Query()
+	displayCurrency := getDisplayCurrency(request, query.Get("displayCurrency"))
+	if err := validator.ValidateDisplayCurrency(displayCurrency); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: "displayCurrency: " + err.Error()})
+	}
+	limit := defaultSimilarLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: "неверный limit"})
+		}
+		limit = min(parsed, maxSimilarLimit)
+	}
+	if result := middleware.CheckConnectionAndTimeout(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	advs := cache.FindSimilarAdvs(rd.Adv, limit)
+	for _, adv := range advs {
+		setDisplayPrice(adv, displayCurrency)
+	}
+	return render.Json(writer, http.StatusOK, &dto.GetAdvListResponse{List: advs, Count: len(advs)})
+}
+
 func GetAdvList(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
-	var (
-		minDollarPrice int64
-		maxDollarPrice int64   = math.MaxInt64
-		minLongitude   float64 = -180
-		maxLongitude   float64 = 180
-		minLatitude    float64 = -180
-		maxLatitude    float64 = 180
-		offset         int
-		limit          int = 20
-	)
 	requestDto := &dto.GetAdvListRequest{}
 	if err := parsing_input.Parse(request, requestDto); err != nil {
 		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
 	}
+	requestDto.DisplayCurrency = getDisplayCurrency(request, requestDto.DisplayCurrency)
 	if err := validator.ValidateGetAdvListRequest(requestDto); err != nil {
 		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
 	}
-	if !currency.IsValidCurrency(requestDto.Currency) {
-		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: "неверный currency"})
+	filter, errMessage := newAdvsFilter(requestDto)
+	if errMessage != "" {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: errMessage})
 	}
-	if requestDto.MinPrice > 0 {
-		minDollarPrice = currency.CalcDollarPrice(requestDto.Currency, requestDto.MinPrice)
+	cursor, offset, limit, err := getPageParams(requestDto.Page, requestDto.Limit, requestDto.Cursor, requestDto.Sort, requestDto.FirstNew)
+	if err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: "неверный cursor"})
 	}
-	if requestDto.MaxPrice > 0 {
-		maxDollarPrice = currency.CalcDollarPrice(requestDto.Currency, requestDto.MaxPrice)
+	if result := middleware.CheckConnectionAndTimeout(rd, writer, request); result != chain.Next() {
+		return result
 	}
-	if requestDto.MinLongitude != 0 {
-		minLongitude = requestDto.MinLongitude
+	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
+		return result
 	}
-	if requestDto.MaxLongitude != 0 {
-		maxLongitude = requestDto.MaxLongitude
+	advs, count, next, facets := cache.FindAdvs(filter, cursor, offset, limit)
+	for _, adv := range advs {
+		setDisplayPrice(adv, requestDto.DisplayCurrency)
 	}
-	if requestDto.MinLatitude != 0 {
-		minLatitude = requestDto.MinLatitude
+	return render.Json(writer, http.StatusOK, &dto.GetAdvListResponse{List: advs, Count: count, NextCursor: next.Encode(), Facets: facets})
+}
+
+func GetAdvClusters(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	requestDto := &dto.GetAdvClustersRequest{}
+	if err := parsing_input.Parse(request, requestDto); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
 	}
-	if requestDto.MaxLatitude != 0 {
-		maxLatitude = requestDto.MaxLatitude
+	if err := validator.ValidateGetAdvClustersRequest(requestDto, cache.MaxClusterZoom); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
 	}
-	offset = (requestDto.Page - 1) * limit
+	filter, errMessage := newAdvsFilter(&requestDto.GetAdvListRequest)
+	if errMessage != "" {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: errMessage})
+	}
+	//видимая область задается только через bbox, края могут быть нулевыми
+	filter.MinLongitude, filter.MinLatitude = requestDto.Bbox[0], requestDto.Bbox[1]
+	filter.MaxLongitude, filter.MaxLatitude = requestDto.Bbox[2], requestDto.Bbox[3]
 	if result := middleware.CheckConnectionAndTimeout(rd, writer, request); result != chain.Next() {
 		return result
 	}
 	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
 		return result
 	}
-	advs, count := cache.FindAdvs(
-		minDollarPrice,
-		maxDollarPrice,
-		minLongitude,
-		maxLongitude,
-		minLatitude,
-		maxLatitude,
-		requestDto.CountryCode,
-		requestDto.Location,
-		offset,
-		limit,
-		requestDto.FirstNew)
-	return render.Json(writer, http.StatusOK, &dto.GetAdvListResponse{List: advs, Count: count})
+	clusters, count := cache.FindAdvClusters(filter, requestDto.Zoom)
+	return render.Json(writer, http.StatusOK, &dto.GetAdvClustersResponse{Count: count, Clusters: clusters})
+}
+
+// newAdvsFilter проверяет то, что не проверяет валидатор, и строит фильтр.
+// Возвращает текст ошибки для ответа 400
+func newAdvsFilter(requestDto *dto.GetAdvListRequest) (*cache.AdvsFilter, string) {
+	if !currency.IsValidCurrency(requestDto.Currency) {
+		return nil, "неверный currency"
+	}
+	if (requestDto.MinPrice > 0 || requestDto.MaxPrice > 0) && !currency.HasRate(requestDto.Currency) {
+		return nil, "нет курса для валюты " + requestDto.Currency
+	}
+	filter, err := cache.NewAdvsFilter(requestDto)
+	if err != nil {
+		return nil, "area: " + err.Error()
+	}
+	return filter, ""
+}
+
+// getPageParams размер страницы берется из запроса, но не больше config.GetMaxPageSize().
+// Если передан cursor, то page не используется
+func getPageParams(page int, requestLimit int, token string, sort string, firstNew bool) (*cache.AdvsCursor, int, int, error) {
+	limit := 20
+	if requestLimit > 0 {
+		limit = min(requestLimit, config.GetMaxPageSize())
+	}
+	if token == "" {
+		return nil, (page - 1) * limit, limit, nil
+	}
+	cursor, err := cache.DecodeAdvsCursor(token, sort, firstNew)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return cursor, 0, limit, nil
+}
+
+// getDisplayCurrency валюта из запроса, если не задана - из cookie
+func getDisplayCurrency(request *http.Request, fromRequest string) string {
+	if fromRequest != "" {
+		return fromRequest
+	}
+	cookie, err := request.Cookie("display_currency")
+	if err != nil || !currency.HasRate(cookie.Value) {
+		return ""
+	}
+	return cookie.Value
+}
+
+func setDisplayPrice(item *dto.GetAdvResponseItem, displayCurrency string) {
+	if displayCurrency == "" {
+		return
+	}
+	if strings.EqualFold(item.Currency, displayCurrency) {
+		item.DisplayPrice = item.Price
+	} else {
+		item.DisplayPrice = currency.CalcPrice(displayCurrency, item.DollarPrice)
+	}
+	item.DisplayCurrency = strings.ToUpper(displayCurrency)
+	item.DisplayPriceFormatted = currency.Format(displayCurrency, item.DisplayPrice)
 }
 
 func GetUsersAdv(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
@@ -260,11 +594,6 @@ func GetUsersAdv(rd *chain.RequestData, writer http.ResponseWriter, request *htt
 }
 
 func GetUsersAdvList(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
-	var (
-		offset   int
-		limit    int = 20
-		firstNew bool
-	)
 	requestDto := &dto.GetUserAdvListRequest{}
 	if err := parsing_input.Parse(request, requestDto); err != nil {
 		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
@@ -272,15 +601,87 @@ func GetUsersAdvList(rd *chain.RequestData, writer http.ResponseWriter, request
 	if err := validator.ValidateGetUserAdvListRequest(requestDto); err != nil {
 		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
 	}
-	offset = (int(requestDto.Page) - 1) * limit
+	cursor, offset, limit, err := getPageParams(requestDto.Page, requestDto.Limit, requestDto.Cursor, requestDto.Sort, requestDto.FirstNew)
+	if err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: "неверный cursor"})
+	}
 	if result := middleware.CheckConnectionAndTimeout(rd, writer, request); result != chain.Next() {
 		return result
 	}
 	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
 		return result
 	}
-	advs, count := cache.FindUsersAdvs(rd.User.CurrentUser.Id, offset, limit, firstNew)
-	return render.Json(writer, http.StatusOK, &dto.GetAdvListResponse{List: advs, Count: count})
+	advs, count, next := cache.FindUsersAdvs(rd.User.CurrentUser.Id, requestDto.Sort, cursor, offset, limit, requestDto.FirstNew)
+	return render.Json(writer, http.StatusOK, &dto.GetAdvListResponse{List: advs, Count: count, NextCursor: next.Encode()})
+}
+
+func GetSavedSearchList(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	searches := cache.FindUserSavedSearches(rd.User.CurrentUser.Id)
+	response := &dto.GetSavedSearchListResponse{List: make([]*dto.SavedSearchResponseItem, 0, len(searches))}
+	for _, search := range searches {
+		response.List = append(response.List, search.ResponseItem())
+	}
+	return render.Json(writer, http.StatusOK, response)
+}
+
+func CreateSavedSearch(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	requestDto, errMessage := parseSavedSearchRequest(request)
+	if errMessage != "" {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: errMessage})
+	}
+	if result := middleware.CheckConnectionAndTimeout(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	searchId, err := cache.CreateSavedSearch(rd.RequestId, rd.User.CurrentUser.Id, requestDto.Name, requestDto.Filter)
+	if err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: "filter: " + err.Error()})
+	}
+	return render.Json(writer, http.StatusOK, &dto.CreateSavedSearchResponse{RequestId: rd.RequestId, SearchId: searchId})
+}
+
+func UpdateSavedSearch(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	requestDto, errMessage := parseSavedSearchRequest(request)
+	if errMessage != "" {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: errMessage})
+	}
+	if result := middleware.CheckConnectionAndTimeout(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
+		return result
+	}
+	if err := cache.UpdateSavedSearch(rd.RequestId, rd.Search, requestDto.Name, requestDto.Filter); err != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: "filter: " + err.Error()})
+	}
+	return render.Json(writer, http.StatusOK, render.ResultOK)
+}
+
+func DeleteSavedSearch(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	cache.DeleteSavedSearch(rd.RequestId, rd.Search)
+	return render.Json(writer, http.StatusOK, render.ResultOK)
+}
+
+// parseSavedSearchRequest разбирает и проверяет тело запроса, поля страницы из фильтра отбрасываются.
+// Возвращает текст ошибки для ответа 400
+func parseSavedSearchRequest(request *http.Request) (*dto.SavedSearchRequest, string) {
+	requestDto := &dto.SavedSearchRequest{}
+	if err := parsing_input.ParseRawJson(request, requestDto); err != nil {
+		return nil, err.Error()
+	}
+	if err := validator.ValidateSavedSearchRequest(requestDto); err != nil {
+		return nil, err.Error()
+	}
+	requestDto.Filter.Page = 0
+	requestDto.Filter.Limit = 0
+	requestDto.Filter.Cursor = ""
+	requestDto.Filter.Facets = false
+	if _, errMessage := newAdvsFilter(requestDto.Filter); errMessage != "" {
+		return nil, "filter: " + errMessage
+	}
+	return requestDto, ""
 }
 
 func UpdateAdv(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
@@ -300,7 +701,24 @@ func UpdateAdv(rd *chain.RequestData, writer http.ResponseWriter, request *http.
 	if result := middleware.CheckGracefullyStop(rd, writer, request); result != chain.Next() {
 		return result
 	}
-	cache.UpdateAdv(rd.RequestId, rd.Adv, requestDto)
+	duplicateOf, errMessage := checkDuplicate(rd.User.CurrentUser.Id, &models.Adv{
+		Id:           rd.Adv.CurrentAdv.Id,
+		Price:        requestDto.Price,
+		Currency:     requestDto.Currency,
+		Latitude:     requestDto.Latitude,
+		Longitude:    requestDto.Longitude,
+		Title:        requestDto.Title,
+		Description:  requestDto.Description,
+		Address:      requestDto.Address,
+		DealType:     requestDto.DealType,
+		PropertyType: requestDto.PropertyType,
+		Rooms:        requestDto.Rooms,
+		Floor:        requestDto.Floor,
+	})
+	if errMessage != "" {
+		return render.Json(writer, http.StatusConflict, &dto.Err{ErrMessage: errMessage})
+	}
+	cache.UpdateAdv(rd.RequestId, rd.Adv, requestDto, duplicateOf)
 	return render.Json(writer, http.StatusOK, render.ResultOK)
 }
 
@@ -3,6 +3,9 @@ package middleware
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"realty/application"
 	"realty/auth_token"
@@ -11,55 +14,122 @@ import (
 	"realty/config"
 	"realty/dto"
 	"realty/parsing_input"
+	"realty/ratelimit"
 	"realty/render"
 	"realty/utils"
 	"realty/validator"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
 func Auth(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	userCache, session, status, errMessage := authenticate(rd.RequestId, request)
+	if errMessage != "" {
+		return render.Json(writer, status, &dto.Err{ErrMessage: errMessage})
+	}
+	rd.User = userCache
+	rd.Session = session
+	return chain.Next()
+}
+
+// OptionalAuth как Auth, но с недействительной cookie запрос идет дальше без пользователя
+func OptionalAuth(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	if userCache, session, _, errMessage := authenticate(rd.RequestId, request); errMessage == "" {
+		rd.User = userCache
+		rd.Session = session
+	}
+	return chain.Next()
+}
+
+// AuthScope как Auth, но также принимает API ключ в заголовке Authorization: Bearer.
+// Ключ должен иметь область scope, cookie дает доступ ко всем областям
+func AuthScope(scope uint8) chain.HandlerFunction {
+	return func(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+		header := request.Header.Get("Authorization")
+		if header == "" {
+			return Auth(rd, writer, request)
+		}
+		token, found := strings.CutPrefix(header, "Bearer ")
+		if !found {
+			return render.Json(writer, http.StatusUnauthorized, &dto.Err{ErrMessage: "неверный формат заголовка авторизации"})
+		}
+		apiKey := cache.FindApiKeyByToken(token)
+		if apiKey == nil {
+			return render.Json(writer, http.StatusUnauthorized, &dto.Err{ErrMessage: "неверный API ключ"})
+		}
+		if apiKey.ApiKey.Scopes&scope == 0 {
+			return render.Json(writer, http.StatusForbidden, &dto.Err{ErrMessage: "недостаточно прав у API ключа"})
+		}
+		userCache := cache.FindUserCacheById(apiKey.ApiKey.UserId)
+		if userCache == nil || userCache.Deleted {
+			return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "пользователь не найден"})
+		}
+		if !userCache.CurrentUser.Enabled {
+			return render.Json(writer, http.StatusForbidden, &dto.Err{ErrMessage: "пользователь заблокирован"})
+		}
+		cache.TouchApiKey(apiKey, remoteIp(request))
+		rd.User = userCache
+		rd.ApiKey = apiKey
+		return chain.Next()
+	}
+}
+
+// authenticate проверяет cookie auth_token, при ошибке возвращает http статус и сообщение
+func authenticate(requestId int64, request *http.Request) (*cache.UserCache, *cache.SessionCache, int, string) {
 	cookie, err := request.Cookie("auth_token")
 	if err != nil {
-		return render.Json(writer, http.StatusUnauthorized, &dto.Err{ErrMessage: "ошибка авторизации 1"})
+		return nil, nil, http.StatusUnauthorized, "ошибка авторизации 1"
 	}
 	if cookie.Value == "" {
-		return render.Json(writer, http.StatusUnauthorized, &dto.Err{ErrMessage: "ошибка авторизации 2 "})
+		return nil, nil, http.StatusUnauthorized, "ошибка авторизации 2 "
 	}
 	tokenBytes, err := base64.StdEncoding.DecodeString(cookie.Value)
 	if err != nil {
-		return render.Json(writer, http.StatusUnauthorized, &dto.Err{ErrMessage: "неверный формат токена авторизации"})
+		return nil, nil, http.StatusUnauthorized, "неверный формат токена авторизации"
 	}
-	if len(tokenBytes) != 36 {
-		return render.Json(writer, http.StatusUnauthorized, &dto.Err{ErrMessage: "неверная длина токена авторизации"})
+	token, err := auth_token.Parse(tokenBytes)
+	if err != nil {
+		return nil, nil, http.StatusUnauthorized, "неверный формат токена авторизации"
 	}
-	tokenBytesArr := [36]byte(tokenBytes)
-	tokenBytesArr = auth_token.UnShuffle(tokenBytesArr)
-	userId, expireTime := auth_token.UnpackToken(tokenBytesArr)
+	userId, expireTime := token.UserId, token.Expires
 	if time.Now().UnixNano() > expireTime {
-		return render.Json(writer, http.StatusUnauthorized, &dto.Err{ErrMessage: "ошибка авторизации 3"})
+		return nil, nil, http.StatusUnauthorized, "ошибка авторизации 3"
 	}
 	if time.Now().Add(time.Hour*24*30).UnixNano() < expireTime {
-		return render.Json(writer, http.StatusUnauthorized, &dto.Err{ErrMessage: "ошибка авторизации 4"})
+		return nil, nil, http.StatusUnauthorized, "ошибка авторизации 4"
 	}
 	if !validator.IsValidUnixNanoId(userId) {
-		return render.Json(writer, http.StatusUnauthorized, &dto.Err{ErrMessage: "ошибка авторизации 5"})
+		return nil, nil, http.StatusUnauthorized, "ошибка авторизации 5"
 	}
 	userCache := cache.FindUserCacheById(userId)
 	if userCache == nil {
-		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "пользователь не найден"})
+		return nil, nil, http.StatusNotFound, "пользователь не найден"
 	}
 	if userCache.Deleted {
-		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "пользователь удален"})
+		return nil, nil, http.StatusNotFound, "пользователь удален"
 	}
 	if !userCache.CurrentUser.Enabled {
-		return render.Json(writer, http.StatusForbidden, &dto.Err{ErrMessage: "пользователь заблокирован"})
+		return nil, nil, http.StatusForbidden, "пользователь заблокирован"
 	}
-	if !auth_token.IsValidToken(tokenBytesArr, userCache.CurrentUser.SessionSecret) {
-		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: "неверный токен"})
+	if !token.IsValid(userCache.CurrentUser.SessionSecret) {
+		return nil, nil, http.StatusBadRequest, "неверный токен"
 	}
-	rd.User = userCache
-	return chain.Next()
+	//токен старой версии без сессии один раз привязывается к новой сессии, SetAuthCookie выдаст токен уже с ней
+	if token.SessionId == 0 {
+		session := cache.BindLegacySession(requestId, userId, token.Hash(), expireTime, remoteIp(request), userAgent(request))
+		if session == nil {
+			return nil, nil, http.StatusUnauthorized, "сессия завершена"
+		}
+		return userCache, session, 0, ""
+	}
+	session := cache.FindSessionCacheById(token.SessionId)
+	if session == nil || session.Session.UserId != userId {
+		return nil, nil, http.StatusUnauthorized, "сессия завершена"
+	}
+	cache.TouchSession(session, remoteIp(request), userAgent(request))
+	return userCache, session, 0, ""
 }
 
 func Login(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
@@ -67,6 +137,7 @@ func Login(rd *chain.RequestData, writer http.ResponseWriter, request *http.Requ
 	if err := parsing_input.ParseRawJson(request, requestDto); err != nil {
 		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
 	}
+	requestDto.Email = utils.NormalizeEmail(requestDto.Email)
 	if err := validator.ValidateLoginRequest(requestDto); err != nil {
 		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: err.Error()})
 	}
@@ -74,10 +145,16 @@ func Login(rd *chain.RequestData, writer http.ResponseWriter, request *http.Requ
 	if userCache == nil {
 		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "пользователь не найден"})
 	}
-	if !bytes.Equal(utils.GeneratePasswordHash(requestDto.Password), userCache.CurrentUser.PasswordHash) {
+	ok, needsRehash := utils.CheckPassword(requestDto.Password, userCache.CurrentUser.PasswordHash)
+	if !ok {
 		return render.Json(writer, http.StatusUnauthorized, &dto.Err{ErrMessage: "неверный пароль"})
 	}
+	//старые хеши переводим в текущий формат, пока знаем пароль
+	if needsRehash {
+		cache.RehashPassword(rd.RequestId, userCache, requestDto.Password)
+	}
 	rd.User = userCache
+	rd.Session = cache.CreateSession(rd.RequestId, userCache.CurrentUser.Id, requestDto.Device, remoteIp(request), userAgent(request))
 	return chain.Next()
 }
 
@@ -88,6 +165,13 @@ func CheckIsAdmin(rd *chain.RequestData, writer http.ResponseWriter, request *ht
 	return chain.Next()
 }
 
+func CheckEmailVerified(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	if !rd.User.CurrentUser.EmailVerified {
+		return render.Json(writer, http.StatusForbidden, &dto.Err{ErrMessage: "email не подтвержден"})
+	}
+	return chain.Next()
+}
+
 func CheckGracefullyStop(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
 	if application.IsGracefullyStopped() {
 		return render.Json(writer, http.StatusServiceUnavailable, &dto.Err{ErrMessage: "сервис временно недоступен"})
@@ -96,10 +180,13 @@ func CheckGracefullyStop(rd *chain.RequestData, writer http.ResponseWriter, requ
 }
 
 func SetAuthCookie(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	//интеграции с API ключом cookie не нужна
+	if rd.ApiKey != nil {
+		return chain.Next()
+	}
 	cookieDuration := time.Hour * 24 * 3
-	newTokenBytes := auth_token.CreateToken(rd.User.CurrentUser.Id, time.Now().Add(cookieDuration).UnixNano(), rd.User.CurrentUser.SessionSecret)
-	newTokenBytes = auth_token.Shuffle(newTokenBytes)
-	newTokenStr := base64.StdEncoding.EncodeToString(newTokenBytes[:])
+	newTokenBytes := auth_token.NewToken(rd.User.CurrentUser.Id, rd.Session.Session.Id, time.Now().Add(cookieDuration).UnixNano(), rd.User.CurrentUser.SessionSecret)
+	newTokenStr := base64.StdEncoding.EncodeToString(newTokenBytes)
 	http.SetCookie(writer, &http.Cookie{
 		SameSite: http.SameSiteStrictMode,
 		Name:     "auth_token",
@@ -137,6 +224,66 @@ func CheckAdvOwner(rd *chain.RequestData, writer http.ResponseWriter, request *h
 	return chain.Next()
 }
 
+func FindSavedSearch(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	searchIdStr := request.PathValue("searchId")
+	searchId, errConv := strconv.ParseInt(searchIdStr, 10, 64)
+	if errConv != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: errConv.Error()})
+	}
+	if !validator.IsValidUnixNanoId(searchId) {
+		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "поиск не найден"})
+	}
+	searchCache := cache.FindSavedSearchCacheById(searchId)
+	if searchCache == nil {
+		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "поиск не найден"})
+	}
+	rd.Search = searchCache
+	return chain.Next()
+}
+
+func CheckSavedSearchOwner(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	if rd.Search.SavedSearch.UserId != rd.User.CurrentUser.Id {
+		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "поиск не принадлежит текущему пользователю"})
+	}
+	return chain.Next()
+}
+
+func FindSession(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	sessionIdStr := request.PathValue("sessionId")
+	sessionId, errConv := strconv.ParseInt(sessionIdStr, 10, 64)
+	if errConv != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: errConv.Error()})
+	}
+	if !validator.IsValidUnixNanoId(sessionId) {
+		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "сессия не найдена"})
+	}
+	sessionCache := cache.FindSessionCacheById(sessionId)
+	//чужую сессию не отличаем от несуществующей
+	if sessionCache == nil || sessionCache.Session.UserId != rd.User.CurrentUser.Id {
+		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "сессия не найдена"})
+	}
+	rd.TargetSession = sessionCache
+	return chain.Next()
+}
+
+func FindApiKey(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+	keyIdStr := request.PathValue("keyId")
+	keyId, errConv := strconv.ParseInt(keyIdStr, 10, 64)
+	if errConv != nil {
+		return render.Json(writer, http.StatusBadRequest, &dto.Err{ErrMessage: errConv.Error()})
+	}
+	if !validator.IsValidUnixNanoId(keyId) {
+		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "API ключ не найден"})
+	}
+	apiKeyCache := cache.FindApiKeyCacheById(keyId)
+	//чужой ключ не отличаем от несуществующего
+	if apiKeyCache == nil || apiKeyCache.ApiKey.UserId != rd.User.CurrentUser.Id {
+		return render.Json(writer, http.StatusNotFound, &dto.Err{ErrMessage: "API ключ не найден"})
+	}
+	rd.TargetApiKey = apiKeyCache
+	return chain.Next()
+}
+
 func StopIfUnsavedMoreThan(count int64) chain.HandlerFunction {
 	return func(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
 		if cache.GetToSaveCount() >= count {
@@ -146,6 +293,95 @@ func StopIfUnsavedMoreThan(count int64) chain.HandlerFunction {
 	}
 }
 
+// RateLimitByIp адрес берется из RemoteAddr, X-Forwarded-For клиент может подделать
+func RateLimitByIp(limiter *ratelimit.Limiter) chain.HandlerFunction {
+	return func(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+		if !limiter.Allow(remoteIp(request)) {
+			return render.Json(writer, http.StatusTooManyRequests, &dto.Err{ErrMessage: "слишком много запросов, попробуйте позже"})
+		}
+		return chain.Next()
+	}
+}
+
+// AccountFunc ключ аккаунта для BruteForceGuard, пустая строка - считать попытки только по IP
+type AccountFunc func(rd *chain.RequestData, request *http.Request) string
+
+// BruteForceGuard защищает next от перебора. До вызова next проверяет блокировку IP в ipFailures и аккаунта,
+// после считает ответ next с кодом из failStatuses неудачной попыткой, а успешный ответ сбрасывает счетчик аккаунта.
+// Счетчик IP успехом не сбрасывается, иначе перебор можно чередовать со входом в свой аккаунт
+func BruteForceGuard(ipFailures *ratelimit.Lockout, account AccountFunc, next chain.HandlerFunction, failStatuses ...int) chain.HandlerFunction {
+	return func(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
+		ip := remoteIp(request)
+		accountKey := ""
+		if account != nil {
+			accountKey = account(rd, request)
+		}
+		retryAfter := ipFailures.RetryAfter(ip)
+		if accountKey != "" {
+			retryAfter = max(retryAfter, ratelimit.LoginFailuresByAccount.RetryAfter(accountKey))
+		}
+		if retryAfter > 0 {
+			writer.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Round(time.Second)/time.Second)))
+			return render.Json(writer, http.StatusTooManyRequests, &dto.Err{ErrMessage: "слишком много неудачных попыток, попробуйте позже"})
+		}
+		result := next(rd, writer, request)
+		if slices.Contains(failStatuses, result.StatusCode) {
+			ipFailures.Fail(ip)
+			if accountKey != "" {
+				ratelimit.LoginFailuresByAccount.Fail(accountKey)
+			}
+		} else if accountKey != "" && (result == chain.Next() || result.StatusCode == http.StatusOK) {
+			ratelimit.LoginFailuresByAccount.Success(accountKey)
+		}
+		return result
+	}
+}
+
+// maxPeekBodySize больше для определения аккаунта не читаем, запрос входа намного меньше
+const maxPeekBodySize = 1 << 16
+
+// LoginAccount email из тела запроса, тело после чтения восстанавливается для следующих обработчиков
+func LoginAccount(rd *chain.RequestData, request *http.Request) string {
+	if request.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(request.Body, maxPeekBodySize))
+	request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), request.Body))
+	if err != nil {
+		return ""
+	}
+	requestDto := &dto.LoginRequest{}
+	if err = json.Unmarshal(body, requestDto); err != nil {
+		return ""
+	}
+	return utils.NormalizeEmail(requestDto.Email)
+}
+
+// CurrentUserAccount email авторизованного пользователя, BruteForceGuard должен стоять после Auth
+func CurrentUserAccount(rd *chain.RequestData, request *http.Request) string {
+	return utils.NormalizeEmail(rd.User.CurrentUser.Email)
+}
+
+// remoteIp адрес из RemoteAddr без порта
+func remoteIp(request *http.Request) string {
+	ip, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return ip
+}
+
+// maxUserAgentLength длиннее User-Agent в сессии не храним
+const maxUserAgentLength = 300
+
+func userAgent(request *http.Request) string {
+	ua := request.UserAgent()
+	if len(ua) > maxUserAgentLength {
+		return ua[:maxUserAgentLength]
+	}
+	return ua
+}
+
 func CheckConnectionAndTimeout(rd *chain.RequestData, writer http.ResponseWriter, request *http.Request) chain.Result {
 	if err := request.Context().Err(); err != nil {
 		return chain.Result{WriteErr: err}
@@ -6,6 +6,8 @@ import (
 	"encoding/binary"
 )
 
+// CreateToken старый формат: 36 байт, SHA-1 от SessionSecret и полезной нагрузки, перемешивается Shuffle.
+// Новые токены выдаются через NewToken, старые принимаются Parse до AUTH_TOKEN_LEGACY_UNTIL
 func CreateToken(userId int64, nanoseconds int64, sessionSecret [24]byte) [36]byte {
 	userIdBytes, expireTimeBytes := make([]byte, 8), make([]byte, 8)
 	binary.LittleEndian.PutUint64(userIdBytes, uint64(userId))
@@ -0,0 +1,149 @@
+package auth_token
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"maps"
+	"realty/config"
+	"sync/atomic"
+	"time"
+)
+
+// формат токена версии 3: версия, id ключа, userId, id сессии, время истечения, HMAC-SHA256.
+// Версия 2 без id сессии принимается, пока не истечет
+const (
+	TokenVersion      = 3
+	TokenLength       = 2 + 8 + 8 + 8 + sha256.Size
+	tokenV2Length     = 2 + 8 + 8 + sha256.Size
+	LegacyTokenLength = 36
+)
+
+var (
+	ErrTokenFormat  = errors.New("invalid token format")
+	ErrTokenVersion = errors.New("unknown token version")
+	ErrTokenKey     = errors.New("unknown token key")
+	ErrTokenLegacy  = errors.New("legacy tokens are not accepted")
+)
+
+type keySet struct {
+	keys      map[uint8][]byte
+	currentId uint8
+}
+
+var serverKeys atomic.Pointer[keySet]
+
+// Token разобранный токен авторизации, подпись проверяется IsValid после поиска пользователя
+type Token struct {
+	UserId    int64
+	SessionId int64 //0 у токенов старых версий, выданных до появления сессий
+	Expires   int64 //UnixNano
+	Legacy    bool
+	keyId     uint8
+	raw       []byte
+}
+
+func Initialize() {
+	keys := config.GetAuthTokenKeys()
+	if len(keys) == 0 {
+		//без ключей в конфиге после перезапуска все пользователи разлогинятся
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+		slog.Warn("auth_token", "msg", "AUTH_TOKEN_KEYS is not set, using random key")
+		SetKeys(map[uint8][]byte{0: key}, 0)
+		return
+	}
+	SetKeys(keys, config.GetAuthTokenKeyId())
+}
+
+// SetKeys заменяет набор ключей: currentId подписывает новые токены, остальные только проверяют старые
+func SetKeys(keys map[uint8][]byte, currentId uint8) {
+	if keys[currentId] == nil {
+		panic("auth_token: current key is missing")
+	}
+	serverKeys.Store(&keySet{keys: maps.Clone(keys), currentId: currentId})
+}
+
+func NewToken(userId int64, sessionId int64, nanoseconds int64, sessionSecret [24]byte) []byte {
+	set := serverKeys.Load()
+	token := make([]byte, TokenLength)
+	token[0] = TokenVersion
+	token[1] = set.currentId
+	binary.LittleEndian.PutUint64(token[2:10], uint64(userId))
+	binary.LittleEndian.PutUint64(token[10:18], uint64(sessionId))
+	binary.LittleEndian.PutUint64(token[18:26], uint64(nanoseconds))
+	copy(token[26:], tokenMac(set.keys[set.currentId], token[:26], sessionSecret))
+	return token
+}
+
+// Parse разбирает токен любой поддерживаемой версии, подпись не проверяет
+func Parse(tokenBytes []byte) (*Token, error) {
+	switch len(tokenBytes) {
+	case LegacyTokenLength:
+		if time.Now().After(config.GetAuthTokenLegacyUntil()) {
+			return nil, ErrTokenLegacy
+		}
+		legacy := UnShuffle([LegacyTokenLength]byte(tokenBytes))
+		userId, nanoseconds := UnpackToken(legacy)
+		return &Token{UserId: userId, Expires: nanoseconds, Legacy: true, raw: legacy[:]}, nil
+	case TokenLength:
+		if tokenBytes[0] != TokenVersion {
+			return nil, ErrTokenVersion
+		}
+		if serverKeys.Load().keys[tokenBytes[1]] == nil {
+			return nil, ErrTokenKey
+		}
+		return &Token{
+			UserId:    int64(binary.LittleEndian.Uint64(tokenBytes[2:10])),
+			SessionId: int64(binary.LittleEndian.Uint64(tokenBytes[10:18])),
+			Expires:   int64(binary.LittleEndian.Uint64(tokenBytes[18:26])),
+			keyId:     tokenBytes[1],
+			raw:       tokenBytes,
+		}, nil
+	case tokenV2Length:
+		if tokenBytes[0] != 2 {
+			return nil, ErrTokenVersion
+		}
+		if serverKeys.Load().keys[tokenBytes[1]] == nil {
+			return nil, ErrTokenKey
+		}
+		return &Token{
+			UserId:  int64(binary.LittleEndian.Uint64(tokenBytes[2:10])),
+			Expires: int64(binary.LittleEndian.Uint64(tokenBytes[10:18])),
+			keyId:   tokenBytes[1],
+			raw:     tokenBytes,
+		}, nil
+	default:
+		return nil, ErrTokenFormat
+	}
+}
+
+// IsValid проверяет подпись, смена SessionSecret (выход со всех устройств) делает токен недействительным
+func (token *Token) IsValid(sessionSecret [24]byte) bool {
+	if token.Legacy {
+		return IsValidToken([LegacyTokenLength]byte(token.raw), sessionSecret)
+	}
+	key := serverKeys.Load().keys[token.keyId]
+	if key == nil {
+		return false
+	}
+	macStart := len(token.raw) - sha256.Size
+	return hmac.Equal(token.raw[macStart:], tokenMac(key, token.raw[:macStart], sessionSecret))
+}
+
+// Hash sha256 от токена, по нему токен без сессии один раз привязывается к новой сессии
+func (token *Token) Hash() [sha256.Size]byte {
+	return sha256.Sum256(token.raw)
+}
+
+func tokenMac(key []byte, payload []byte, sessionSecret [24]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	mac.Write(sessionSecret[:])
+	return mac.Sum(nil)
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"realty/dto"
+	"realty/models"
+)
+
+// AdvAttributesFilter условия по характеристикам объекта, 0 - без ограничения.
+// Объявления, в которых характеристика не указана, под ограничение по ней не подходят
+type AdvAttributesFilter struct {
+	DealType      int8
+	RentPeriod    int8
+	PropertyType  int8
+	MinRooms      int8
+	MaxRooms      int8
+	MinFloor      int16
+	MaxFloor      int16
+	MinFloors     int16
+	MaxFloors     int16
+	MinYearBuilt  int16
+	MaxYearBuilt  int16
+	MinTotalArea  float64
+	MaxTotalArea  float64
+	MinLivingArea float64
+	MaxLivingArea float64
+}
+
+func newAdvAttributesFilter(request *dto.GetAdvListRequest) AdvAttributesFilter {
+	return AdvAttributesFilter{
+		DealType:      request.DealType,
+		RentPeriod:    request.RentPeriod,
+		PropertyType:  request.PropertyType,
+		MinRooms:      request.MinRooms,
+		MaxRooms:      request.MaxRooms,
+		MinFloor:      request.MinFloor,
+		MaxFloor:      request.MaxFloor,
+		MinFloors:     request.MinFloors,
+		MaxFloors:     request.MaxFloors,
+		MinYearBuilt:  request.MinYearBuilt,
+		MaxYearBuilt:  request.MaxYearBuilt,
+		MinTotalArea:  request.MinTotalArea,
+		MaxTotalArea:  request.MaxTotalArea,
+		MinLivingArea: request.MinLivingArea,
+		MaxLivingArea: request.MaxLivingArea,
+	}
+}
+
+func (f *AdvAttributesFilter) match(adv *models.Adv) bool {
+	return (f.DealType == 0 || adv.DealType == f.DealType) &&
+		(f.RentPeriod == 0 || adv.RentPeriod == f.RentPeriod) &&
+		(f.PropertyType == 0 || adv.PropertyType == f.PropertyType) &&
+		inAttributeRange(adv.Rooms, f.MinRooms, f.MaxRooms) &&
+		inAttributeRange(adv.Floor, f.MinFloor, f.MaxFloor) &&
+		inAttributeRange(adv.Floors, f.MinFloors, f.MaxFloors) &&
+		inAttributeRange(adv.YearBuilt, f.MinYearBuilt, f.MaxYearBuilt) &&
+		inAttributeRange(adv.TotalArea, f.MinTotalArea, f.MaxTotalArea) &&
+		inAttributeRange(adv.LivingArea, f.MinLivingArea, f.MaxLivingArea)
+}
+
+func inAttributeRange[T int8 | int16 | float64](value, minValue, maxValue T) bool {
+	if minValue == 0 && maxValue == 0 {
+		return true
+	}
+	return value != 0 && (minValue == 0 || value >= minValue) && (maxValue == 0 || value <= maxValue)
+}
+
+// setAdvAttributes копирует характеристики объекта в ответ
+func setAdvAttributes(response *dto.GetAdvResponseItem, adv *models.Adv) {
+	response.DealType = adv.DealType
+	response.RentPeriod = adv.RentPeriod
+	response.PropertyType = adv.PropertyType
+	response.Rooms = adv.Rooms
+	response.Floor = adv.Floor
+	response.Floors = adv.Floors
+	response.YearBuilt = adv.YearBuilt
+	response.TotalArea = adv.TotalArea
+	response.LivingArea = adv.LivingArea
+}
@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"realty/models"
+	"testing"
+)
+
+func TestAdvAttributesFilterMatch(t *testing.T) {
+	adv := &models.Adv{DealType: models.DealTypeRent, RentPeriod: models.RentPeriodMonthly,
+		PropertyType: models.PropertyTypeFlat, Rooms: 2, Floor: -1, Floors: 5, TotalArea: 50.5}
+	cases := []struct {
+		filter AdvAttributesFilter
+		ok     bool
+	}{
+		{AdvAttributesFilter{}, true},
+		{AdvAttributesFilter{DealType: models.DealTypeRent, RentPeriod: models.RentPeriodMonthly}, true},
+		{AdvAttributesFilter{RentPeriod: models.RentPeriodDaily}, false},
+		{AdvAttributesFilter{PropertyType: models.PropertyTypeHouse}, false},
+		{AdvAttributesFilter{MinRooms: 2, MaxRooms: 2}, true},
+		{AdvAttributesFilter{MaxRooms: 1}, false},
+		{AdvAttributesFilter{MinFloor: -2, MaxFloor: -1}, true},
+		{AdvAttributesFilter{MinFloor: 1}, false},
+		{AdvAttributesFilter{MinTotalArea: 50, MaxTotalArea: 50.5}, true},
+		{AdvAttributesFilter{MaxTotalArea: 50}, false},
+		//не указанная характеристика не подходит под ограничение по ней
+		{AdvAttributesFilter{MaxLivingArea: 100}, false},
+		{AdvAttributesFilter{MinYearBuilt: 1900}, false},
+	}
+	for _, c := range cases {
+		if ok := c.filter.match(adv); ok != c.ok {
+			t.Errorf("%+v: got %v want %v", c.filter, ok, c.ok)
+		}
+	}
+}
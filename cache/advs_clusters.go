@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"cmp"
+	"math"
+	"realty/dto"
+	"slices"
+)
+
+// clusterCellsPerTile число ячеек сетки кластеров по ширине тайла карты
+const clusterCellsPerTile = 4
+
+// MaxClusterZoom на большем масштабе ячейки становятся меньше метра и кластеры не нужны
+const MaxClusterZoom = 22
+
+type advCluster struct {
+	latitudeSum    float64
+	longitudeSum   float64
+	count          int
+	minDollarPrice int64
+	maxDollarPrice int64
+	advId          int64
+}
+
+// ClusterCellSize размер ячейки сетки в градусах для масштаба карты zoom
+func ClusterCellSize(zoom int) float64 {
+	return 360 / (clusterCellsPerTile * math.Exp2(float64(zoom)))
+}
+
+// FindAdvClusters группирует подходящие под фильтр объявления по ячейкам сетки масштаба zoom.
+// Возвращает кластеры по убыванию количества объявлений и общее количество объявлений
+func FindAdvClusters(filter *AdvsFilter, zoom int) ([]*dto.AdvCluster, int) {
+	cellSize := ClusterCellSize(zoom)
+	clusters := make(map[geoCellKey]*advCluster)
+	var count int
+	advsRWMutex.RLock()
+	list, _ := findCandidates(filter)
+	for _, adv := range list {
+		if adv.ToDelete || adv.Deleted {
+			continue
+		}
+		if _, ok := filter.match(&adv.CurrentAdv); !ok {
+			continue
+		}
+		count++
+		key := geoCellKey{
+			lat: int32(math.Floor((adv.CurrentAdv.Latitude + 90) / cellSize)),
+			lon: int32(math.Floor((adv.CurrentAdv.Longitude + 180) / cellSize)),
+		}
+		cluster, ok := clusters[key]
+		if !ok {
+			cluster = &advCluster{minDollarPrice: math.MaxInt64, maxDollarPrice: math.MinInt64}
+			clusters[key] = cluster
+		}
+		cluster.count++
+		cluster.latitudeSum += adv.CurrentAdv.Latitude
+		cluster.longitudeSum += adv.CurrentAdv.Longitude
+		cluster.minDollarPrice = min(cluster.minDollarPrice, adv.CurrentAdv.DollarPrice)
+		cluster.maxDollarPrice = max(cluster.maxDollarPrice, adv.CurrentAdv.DollarPrice)
+		cluster.advId = adv.CurrentAdv.Id
+	}
+	advsRWMutex.RUnlock()
+
+	result := make([]*dto.AdvCluster, 0, len(clusters))
+	for _, cluster := range clusters {
+		item := &dto.AdvCluster{
+			Latitude:       cluster.latitudeSum / float64(cluster.count),
+			Longitude:      cluster.longitudeSum / float64(cluster.count),
+			Count:          cluster.count,
+			MinDollarPrice: cluster.minDollarPrice,
+			MaxDollarPrice: cluster.maxDollarPrice,
+		}
+		if cluster.count == 1 {
+			item.AdvId = cluster.advId
+		}
+		result = append(result, item)
+	}
+	slices.SortFunc(result, func(a, b *dto.AdvCluster) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Latitude, b.Latitude); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Longitude, b.Longitude)
+	})
+	return result, count
+}
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"testing"
+)
+
+func TestFindAdvClusters(t *testing.T) {
+	fillAdvs(1000)
+	filter := &AdvsFilter{MaxDollarPrice: 800_000, MinLongitude: 25, MaxLongitude: 55,
+		MinLatitude: 35, MaxLatitude: 65}
+	for _, zoom := range []int{0, 3, 8, 14} {
+		clusters, count := FindAdvClusters(filter, zoom)
+		expected := linearBbox(35, 65, 25, 55)
+		var matched int
+		for _, id := range expected {
+			if adv := advs[id-advs[0].CurrentAdv.Id]; adv.CurrentAdv.DollarPrice <= 800_000 {
+				matched++
+			}
+		}
+		if count != matched {
+			t.Fatalf("zoom %d: count %d, linear %d", zoom, count, matched)
+		}
+		var total int
+		for i, cluster := range clusters {
+			total += cluster.Count
+			if cluster.MinDollarPrice > cluster.MaxDollarPrice || cluster.MaxDollarPrice > 800_000 {
+				t.Errorf("zoom %d: wrong prices %+v", zoom, cluster)
+			}
+			if (cluster.Count == 1) != (cluster.AdvId != 0) {
+				t.Errorf("zoom %d: advId must be set only for single advert %+v", zoom, cluster)
+			}
+			if i > 0 && clusters[i-1].Count < cluster.Count {
+				t.Errorf("zoom %d: clusters are not sorted by count", zoom)
+			}
+			if cluster.Latitude < 35 || cluster.Latitude > 65 || cluster.Longitude < 25 || cluster.Longitude > 55 {
+				t.Errorf("zoom %d: centroid %+v is out of bbox", zoom, cluster)
+			}
+		}
+		if total != count {
+			t.Errorf("zoom %d: clusters sum %d, count %d", zoom, total, count)
+		}
+		if zoom == 0 && len(clusters) > 4 {
+			t.Errorf("zoom 0: too many clusters %d", len(clusters))
+		}
+		if zoom == 14 && len(clusters) < count/2 {
+			t.Errorf("zoom 14: too few clusters %d for %d adverts", len(clusters), count)
+		}
+	}
+}
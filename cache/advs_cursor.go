@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// AdvsCursor позиция последнего отданного объявления для постраничного вывода без offset.
+// Клиенту передается непрозрачной строкой, см. Encode и DecodeAdvsCursor
+type AdvsCursor struct {
+	Sort     string  `json:"s,omitempty"`
+	FirstNew bool    `json:"f,omitempty"`
+	Id       int64   `json:"i"`
+	Int      int64   `json:"n,omitempty"` //значение для sort по price, updated, watches
+	Float    float64 `json:"x,omitempty"` //значение для sort по distance, relevance
+}
+
+func newAdvsCursor(sort string, firstNew bool, found foundAdv) *AdvsCursor {
+	cursor := &AdvsCursor{Sort: sort, FirstNew: firstNew, Id: found.id}
+	switch strings.TrimPrefix(sort, "-") {
+	case "price":
+		cursor.Int = found.dollarPrice
+	case "updated":
+		cursor.Int = found.updated
+	case "watches":
+		cursor.Int = found.watches
+	case "distance":
+		cursor.Float = found.distance
+	default:
+		cursor.Float = found.relevance
+	}
+	return cursor
+}
+
+func (c *AdvsCursor) foundAdv() foundAdv {
+	found := foundAdv{id: c.Id}
+	switch strings.TrimPrefix(c.Sort, "-") {
+	case "price":
+		found.dollarPrice = c.Int
+	case "updated":
+		found.updated = c.Int
+	case "watches":
+		found.watches = c.Int
+	case "distance":
+		found.distance = c.Float
+	default:
+		found.relevance = c.Float
+	}
+	return found
+}
+
+// after проверяет, идет ли объявление с id после курсора при сортировке только по id
+func (c *AdvsCursor) after(id int64) bool {
+	if c.FirstNew {
+		return id < c.Id
+	}
+	return id > c.Id
+}
+
+// Encode для nil возвращает пустую строку - следующей страницы нет
+func (c *AdvsCursor) Encode() string {
+	if c == nil {
+		return ""
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeAdvsCursor курсор действителен только для тех же sort и firstNew, с которыми он получен
+func DecodeAdvsCursor(token string, sort string, firstNew bool) (*AdvsCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	cursor := &AdvsCursor{}
+	if err = json.Unmarshal(data, cursor); err != nil || cursor.Id <= 0 {
+		return nil, errors.New("invalid cursor")
+	}
+	if cursor.Sort != sort || cursor.FirstNew != firstNew {
+		return nil, errors.New("cursor does not match sort and firstNew")
+	}
+	return cursor, nil
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"cmp"
+	"realty/currency"
+	"realty/dto"
+	"realty/fulltext"
+	"realty/geo"
+	"realty/models"
+	"slices"
+	"strings"
+)
+
+// параметры поиска повторов: объявления должны быть почти в одной точке, с близкой ценой и похожим текстом.
+// При совпадении адреса достаточно меньшей похожести текста
+const (
+	duplicateRadiusKm              = 0.15
+	duplicatePriceTolerance        = 0.05
+	duplicateTextSimilarity        = 0.7
+	duplicateSameAddressSimilarity = 0.4
+	duplicateShingleSize           = 3
+)
+
+// FindDuplicateAdv ищет среди активных объявлений оригинал, повтором которого является adv.
+// Объявление с adv.Id не проверяется, DollarPrice считается по текущему курсу
+func FindDuplicateAdv(adv *models.Adv) *AdvCache {
+	dollarPrice := currency.CalcDollarPrice(adv.Currency, adv.Price)
+	shingles := advShingles(adv)
+	address := normalizeAddress(adv.Address)
+	var found *AdvCache
+	advsRWMutex.RLock()
+	minLatitude, maxLatitude, minLongitude, maxLongitude := geo.RadiusBox(adv.Latitude, adv.Longitude, duplicateRadiusKm)
+	candidates, _ := advsGeoIndex.query(minLatitude, maxLatitude, minLongitude, maxLongitude, len(advs))
+	for _, candidate := range candidates {
+		other := &candidate.CurrentAdv
+		if other.Id == adv.Id || candidate.ToDelete || candidate.Deleted || !sameProperty(adv, other) ||
+			geo.Distance(adv.Latitude, adv.Longitude, other.Latitude, other.Longitude) > duplicateRadiusKm ||
+			priceSimilarity(dollarPrice, other.DollarPrice) < 1-duplicatePriceTolerance {
+			continue
+		}
+		threshold := duplicateTextSimilarity
+		if address != "" && address == normalizeAddress(other.Address) {
+			threshold = duplicateSameAddressSimilarity
+		}
+		if fulltext.Jaccard(shingles, advShingles(other)) < threshold {
+			continue
+		}
+		//кандидаты отсортированы по id, первый найденный - самый старый
+		found = candidate
+		break
+	}
+	advsRWMutex.RUnlock()
+	if found == nil || found.CurrentAdv.DuplicateOf == 0 {
+		return found
+	}
+	if original := FindAdvCacheById(found.CurrentAdv.DuplicateOf); original != nil {
+		return original
+	}
+	return found
+}
+
+// sameProperty характеристики, указанные в обоих объявлениях, должны совпадать
+func sameProperty(a, b *models.Adv) bool {
+	return comparableAdvs(a, b) &&
+		(a.Rooms == 0 || b.Rooms == 0 || a.Rooms == b.Rooms) &&
+		(a.Floor == 0 || b.Floor == 0 || a.Floor == b.Floor)
+}
+
+func advShingles(adv *models.Adv) map[uint64]struct{} {
+	return fulltext.Shingles(fulltext.Tokenize(adv.Title+" "+adv.Description), duplicateShingleSize)
+}
+
+// normalizeAddress адрес без регистра, знаков препинания и окончаний
+func normalizeAddress(address string) string {
+	return strings.Join(fulltext.Tokenize(address), " ")
+}
+
+// FindDuplicateClusters группы повторов: оригинал и ссылающиеся на него активные объявления,
+// по убыванию числа повторов
+func FindDuplicateClusters() []*dto.DuplicateCluster {
+	advsRWMutex.RLock()
+	defer advsRWMutex.RUnlock()
+	groups := make(map[int64][]*AdvCache)
+	for _, adv := range advs {
+		if adv.CurrentAdv.DuplicateOf != 0 && !adv.ToDelete && !adv.Deleted {
+			groups[adv.CurrentAdv.DuplicateOf] = append(groups[adv.CurrentAdv.DuplicateOf], adv)
+		}
+	}
+	result := make([]*dto.DuplicateCluster, 0, len(groups))
+	for originalId, duplicates := range groups {
+		i, ok := slices.BinarySearchFunc(advs, originalId, func(adv *AdvCache, id int64) int {
+			return cmp.Compare(adv.CurrentAdv.Id, id)
+		})
+		if !ok || advs[i].ToDelete || advs[i].Deleted {
+			continue
+		}
+		cluster := &dto.DuplicateCluster{
+			Original:   newAdvResponseItem(advs[i]),
+			Duplicates: make([]*dto.GetAdvResponseItem, 0, len(duplicates)),
+		}
+		for _, duplicate := range duplicates {
+			cluster.Duplicates = append(cluster.Duplicates, newAdvResponseItem(duplicate))
+		}
+		result = append(result, cluster)
+	}
+	slices.SortFunc(result, func(a, b *dto.DuplicateCluster) int {
+		if c := cmp.Compare(len(b.Duplicates), len(a.Duplicates)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Original.Id, b.Original.Id)
+	})
+	return result
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"realty/models"
+	"testing"
+)
+
+func TestFindDuplicateAdv(t *testing.T) {
+	newAdv := func(id int64, description, address string, latitude float64, dollarPrice int64) *AdvCache {
+		adv := newTextAdv(id, "Квартира у парка", description, "Москва", address)
+		adv.CurrentAdv.User = benchUser
+		adv.CurrentAdv.Approved = true
+		adv.CurrentAdv.Latitude = latitude
+		adv.CurrentAdv.Longitude = 37.62
+		adv.CurrentAdv.Currency = "usd"
+		adv.CurrentAdv.Price = dollarPrice
+		adv.CurrentAdv.DollarPrice = dollarPrice
+		adv.Watches = &WatchesCache{}
+		return adv
+	}
+	description := "Светлая двухкомнатная квартира с ремонтом, окна во двор, рядом метро и парк"
+	original := newAdv(1, description, "ул. Ленина, д. 5", 55.75, 200_000)
+	repost := newAdv(2, description+"!", "Ленина ул, 5", 55.7501, 201_000)
+	repost.CurrentAdv.DuplicateOf = 1
+	other := newAdv(3, "Уютная студия", "ул. Ленина, д. 5", 55.75, 200_000)
+
+	advs = nil
+	advsGeoIndex = newGeoIndex()
+	for _, adv := range []*AdvCache{original, repost, other} {
+		advs = append(advs, adv)
+		advsGeoIndex.add(adv)
+	}
+
+	probe := repost.CurrentAdv
+	probe.Id = 0
+	cases := []struct {
+		name   string
+		change func(adv *models.Adv)
+		want   *AdvCache
+	}{
+		{"same text", func(adv *models.Adv) {}, original},
+		{"reworded, same address", func(adv *models.Adv) {
+			adv.Description = "Светлая двухкомнатная квартира с ремонтом, окна во двор. Метро в 5 минутах"
+		}, original},
+		{"reworded, other address", func(adv *models.Adv) {
+			adv.Description = "Светлая двухкомнатная квартира с ремонтом, окна во двор. Метро в 5 минутах"
+			adv.Address = "ул. Ленина, д. 7"
+		}, nil},
+		{"other price", func(adv *models.Adv) { adv.Price = 250_000 }, nil},
+		{"far away", func(adv *models.Adv) { adv.Latitude = 55.76 }, nil},
+		{"other rooms", func(adv *models.Adv) {
+			adv.Rooms = 3
+			original.CurrentAdv.Rooms = 2
+			repost.CurrentAdv.Rooms = 2
+		}, nil},
+	}
+	for _, c := range cases {
+		adv := probe
+		c.change(&adv)
+		if found := FindDuplicateAdv(&adv); found != c.want {
+			t.Errorf("%s: got %v want %v", c.name, found, c.want)
+		}
+	}
+	original.CurrentAdv.Rooms = 0
+	repost.CurrentAdv.Rooms = 0
+
+	//повтор повтора ссылается на оригинал
+	original.Deleted = true
+	if found := FindDuplicateAdv(&probe); found != repost {
+		t.Errorf("deleted original: got %v want repost", found)
+	}
+	original.Deleted = false
+
+	clusters := FindDuplicateClusters()
+	if len(clusters) != 1 || clusters[0].Original.Id != 1 || len(clusters[0].Duplicates) != 1 || clusters[0].Duplicates[0].Id != 2 {
+		t.Errorf("wrong clusters: %v", clusters)
+	}
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"cmp"
+	"realty/dto"
+	"realty/models"
+	"slices"
+)
+
+// priceBuckets нижние границы интервалов цены в долларах
+var priceBuckets = []int64{0, 1_000, 10_000, 50_000, 100_000, 250_000, 500_000, 1_000_000}
+
+// facetsCounter считает фасеты в том же проходе, что и FindAdvs
+type facetsCounter struct {
+	countries  map[string]int
+	cities     map[string]int
+	currencies map[string]int
+	prices     []int
+}
+
+func newFacetsCounter() *facetsCounter {
+	return &facetsCounter{
+		countries:  make(map[string]int),
+		cities:     make(map[string]int),
+		currencies: make(map[string]int),
+		prices:     make([]int, len(priceBuckets)),
+	}
+}
+
+// add для nil ничего не делает, чтобы не проверять в цикле, нужны ли фасеты
+func (f *facetsCounter) add(adv *models.Adv) {
+	if f == nil {
+		return
+	}
+	if adv.Country != "" {
+		f.countries[adv.Country]++
+	}
+	if adv.City != "" {
+		f.cities[adv.City]++
+	}
+	if adv.Currency != "" {
+		f.currencies[adv.Currency]++
+	}
+	i, found := slices.BinarySearch(priceBuckets, adv.DollarPrice)
+	if !found {
+		i--
+	}
+	f.prices[max(i, 0)]++
+}
+
+func (f *facetsCounter) result() *dto.AdvFacets {
+	if f == nil {
+		return nil
+	}
+	facets := &dto.AdvFacets{
+		Countries:  facetCounts(f.countries),
+		Cities:     facetCounts(f.cities),
+		Currencies: facetCounts(f.currencies),
+		Prices:     make([]*dto.PriceBucketCount, 0, len(priceBuckets)),
+	}
+	for i, count := range f.prices {
+		if count == 0 {
+			continue
+		}
+		bucket := &dto.PriceBucketCount{MinDollarPrice: priceBuckets[i], Count: count}
+		if i+1 < len(priceBuckets) {
+			bucket.MaxDollarPrice = priceBuckets[i+1]
+		}
+		facets.Prices = append(facets.Prices, bucket)
+	}
+	return facets
+}
+
+func facetCounts(counts map[string]int) []*dto.FacetCount {
+	result := make([]*dto.FacetCount, 0, len(counts))
+	for value, count := range counts {
+		result = append(result, &dto.FacetCount{Value: value, Count: count})
+	}
+	slices.SortFunc(result, func(a, b *dto.FacetCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return cmp.Compare(a.Value, b.Value)
+	})
+	return result
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindAdvsFacets(t *testing.T) {
+	fillAdvs(500)
+	countries := []string{"RU", "TR", "AZ", "KZ"}
+	cities := []string{"Москва", "Стамбул", "Баку"}
+	currencies := []string{"rub", "try", "usd"}
+	for i, adv := range advs {
+		adv.CurrentAdv.Country = countries[i%len(countries)]
+		adv.CurrentAdv.City = cities[i%len(cities)]
+		adv.CurrentAdv.Currency = currencies[i%len(currencies)]
+	}
+	filter := &AdvsFilter{MaxDollarPrice: 500_000, MinLongitude: -180, MaxLongitude: 180,
+		MinLatitude: -90, MaxLatitude: 90, Facets: true}
+	_, count, _, facets := FindAdvs(filter, nil, 0, 10)
+
+	expected := newFacetsCounter()
+	for _, adv := range advs {
+		if _, ok := filter.match(&adv.CurrentAdv); ok {
+			expected.add(&adv.CurrentAdv)
+		}
+	}
+	if !reflect.DeepEqual(facets, expected.result()) {
+		t.Fatalf("facets differ from linear count")
+	}
+	var total int
+	for _, bucket := range facets.Prices {
+		total += bucket.Count
+		if bucket.MinDollarPrice > 500_000 {
+			t.Errorf("bucket %v is out of the price filter", bucket)
+		}
+	}
+	if total != count {
+		t.Errorf("price buckets sum %d, count %d", total, count)
+	}
+	for i := 1; i < len(facets.Countries); i++ {
+		if facets.Countries[i-1].Count < facets.Countries[i].Count {
+			t.Errorf("countries are not sorted by count: %v", facets.Countries)
+		}
+	}
+
+	filter.Sort = "-price"
+	if _, _, _, sorted := FindAdvs(filter, nil, 0, 10); !reflect.DeepEqual(facets, sorted) {
+		t.Errorf("facets depend on sort")
+	}
+	filter.Facets = false
+	if _, _, _, facets = FindAdvs(filter, nil, 0, 10); facets != nil {
+		t.Errorf("facets are not requested")
+	}
+}
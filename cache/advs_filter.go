@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"math"
+	"realty/currency"
+	"realty/dto"
+	"realty/geo"
+	"realty/models"
+	"strings"
+)
+
+// AdvsFilter условия поиска объявлений для FindAdvs
+type AdvsFilter struct {
+	MinDollarPrice int64
+	MaxDollarPrice int64
+	//если MinLongitude > MaxLongitude, прямоугольник пересекает 180-й меридиан
+	MinLongitude float64
+	MaxLongitude float64
+	MinLatitude  float64
+	MaxLatitude  float64
+	//поиск по радиусу, используется если RadiusKm > 0
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+	//область на карте, nil - без ограничения
+	Area        *geo.Area
+	CountryCode string
+	Location    string
+	//полнотекстовый запрос, результаты сортируются по релевантности, если Sort не задан
+	Query    string
+	Sort     string
+	FirstNew bool
+	//считать ли фасеты по всем подходящим объявлениям
+	Facets     bool
+	Attributes AdvAttributesFilter
+}
+
+// NewAdvsFilter фильтр из провалидированного запроса списка объявлений.
+// Цены переводятся в доллары по текущему курсу, ошибка возвращается только для неверной области
+func NewAdvsFilter(request *dto.GetAdvListRequest) (*AdvsFilter, error) {
+	filter := &AdvsFilter{
+		MaxDollarPrice: math.MaxInt64,
+		MinLongitude:   -180,
+		MaxLongitude:   180,
+		MinLatitude:    -180,
+		MaxLatitude:    180,
+		Latitude:       request.Latitude,
+		Longitude:      request.Longitude,
+		RadiusKm:       request.RadiusKm,
+		CountryCode:    request.CountryCode,
+		Location:       request.Location,
+		Query:          request.Query,
+		Sort:           request.Sort,
+		FirstNew:       request.FirstNew,
+		Facets:         request.Facets,
+		Attributes:     newAdvAttributesFilter(request),
+	}
+	filter.setDollarPrices(request)
+	if request.MinLongitude != 0 {
+		filter.MinLongitude = request.MinLongitude
+	}
+	if request.MaxLongitude != 0 {
+		filter.MaxLongitude = request.MaxLongitude
+	}
+	if request.MinLatitude != 0 {
+		filter.MinLatitude = request.MinLatitude
+	}
+	if request.MaxLatitude != 0 {
+		filter.MaxLatitude = request.MaxLatitude
+	}
+	if request.Area != nil {
+		area, err := geo.ParseArea(request.Area.Type, request.Area.Coordinates)
+		if err != nil {
+			return nil, err
+		}
+		filter.Area = area
+	}
+	return filter, nil
+}
+
+func (f *AdvsFilter) setDollarPrices(request *dto.GetAdvListRequest) {
+	if request.MinPrice > 0 {
+		f.MinDollarPrice = currency.CalcDollarPrice(request.Currency, request.MinPrice)
+	}
+	if request.MaxPrice > 0 {
+		f.MaxDollarPrice = currency.CalcDollarPrice(request.Currency, request.MaxPrice)
+	}
+}
+
+func (f *AdvsFilter) isRadiusSearch() bool {
+	return f.RadiusKm > 0
+}
+
+// bbox прямоугольник для выборки кандидатов из пространственного индекса
+func (f *AdvsFilter) bbox() (minLatitude, maxLatitude, minLongitude, maxLongitude float64) {
+	if f.Area != nil {
+		return f.Area.BoundingBox()
+	}
+	if f.isRadiusSearch() {
+		return geo.RadiusBox(f.Latitude, f.Longitude, f.RadiusKm)
+	}
+	return f.MinLatitude, f.MaxLatitude, f.MinLongitude, f.MaxLongitude
+}
+
+// match проверяет объявление на соответствие фильтру,
+// для поиска по радиусу также возвращает расстояние до центра в км
+func (f *AdvsFilter) match(adv *models.Adv) (float64, bool) {
+	if !(adv.Approved && adv.DollarPrice >= f.MinDollarPrice && adv.DollarPrice <= f.MaxDollarPrice &&
+		geo.InLongitudeRange(adv.Longitude, f.MinLongitude, f.MaxLongitude) &&
+		adv.Latitude > f.MinLatitude && adv.Latitude < f.MaxLatitude &&
+		(f.CountryCode == "" || adv.Country == f.CountryCode) &&
+		(f.Location == "" || strings.Contains(adv.Address, f.Location)) &&
+		(f.Area == nil || f.Area.Contains(adv.Latitude, adv.Longitude)) &&
+		f.Attributes.match(adv)) {
+		return 0, false
+	}
+	if !f.isRadiusSearch() {
+		return 0, true
+	}
+	distance := geo.Distance(f.Latitude, f.Longitude, adv.Latitude, adv.Longitude)
+	return distance, distance <= f.RadiusKm
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"cmp"
+	"math"
+	"realty/dto"
+	"realty/geo"
+	"realty/models"
+	"slices"
+	"strings"
+)
+
+// similarRadiusKm похожие объявления ищутся только в этом радиусе, дальше объекты уже не сравнимы
+const similarRadiusKm = 50
+
+// веса составляющих похожести, в сумме 1
+const (
+	similarWeightDistance = 0.35
+	similarWeightPrice    = 0.3
+	similarWeightText     = 0.2
+	similarWeightCity     = 0.15
+)
+
+// FindSimilarAdvs возвращает до limit одобренных объявлений, похожих на adv,
+// по убыванию похожести от 0 до 1
+func FindSimilarAdvs(adv *AdvCache, limit int) []*dto.GetAdvResponseItem {
+	type similarAdv struct {
+		adv        *AdvCache
+		distance   float64
+		similarity float64
+	}
+	advsRWMutex.RLock()
+	defer advsRWMutex.RUnlock()
+	origin := &adv.CurrentAdv
+	minLatitude, maxLatitude, minLongitude, maxLongitude := geo.RadiusBox(origin.Latitude, origin.Longitude, similarRadiusKm)
+	candidates, _ := advsGeoIndex.query(minLatitude, maxLatitude, minLongitude, maxLongitude, len(advs))
+	originDoc := advsTextIndex.docs[adv]
+	found := make([]similarAdv, 0)
+	for _, candidate := range candidates {
+		if candidate == adv || candidate.ToDelete || candidate.Deleted || !candidate.CurrentAdv.Approved ||
+			!comparableAdvs(origin, &candidate.CurrentAdv) {
+			continue
+		}
+		distance := geo.Distance(origin.Latitude, origin.Longitude, candidate.CurrentAdv.Latitude, candidate.CurrentAdv.Longitude)
+		if distance > similarRadiusKm {
+			continue
+		}
+		similarity := similarWeightDistance*(1-distance/similarRadiusKm) +
+			similarWeightPrice*priceSimilarity(origin.DollarPrice, candidate.CurrentAdv.DollarPrice) +
+			similarWeightText*textSimilarity(originDoc, advsTextIndex.docs[candidate])
+		if origin.City != "" && strings.EqualFold(origin.City, candidate.CurrentAdv.City) {
+			similarity += similarWeightCity
+		}
+		found = append(found, similarAdv{adv: candidate, distance: distance, similarity: similarity})
+	}
+	slices.SortFunc(found, func(a, b similarAdv) int {
+		if c := cmp.Compare(b.similarity, a.similarity); c != 0 {
+			return c
+		}
+		return compareAdvIds(a.adv, b.adv)
+	})
+	result := make([]*dto.GetAdvResponseItem, 0, min(limit, len(found)))
+	for _, item := range found[:min(limit, len(found))] {
+		response := newAdvResponseItem(item.adv)
+		response.Distance = item.distance
+		response.Similarity = math.Round(item.similarity*1000) / 1000
+		result = append(result, response)
+	}
+	return result
+}
+
+// comparableAdvs аренду не сравниваем с продажей, а квартиры с домами, если тип указан у обоих
+func comparableAdvs(a, b *models.Adv) bool {
+	return (a.DealType == 0 || b.DealType == 0 || a.DealType == b.DealType) &&
+		(a.PropertyType == 0 || b.PropertyType == 0 || a.PropertyType == b.PropertyType)
+}
+
+// priceSimilarity отношение меньшей цены к большей, 0 если цена не известна
+func priceSimilarity(a, b int64) float64 {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	return float64(min(a, b)) / float64(max(a, b))
+}
+
+// textSimilarity косинусная близость взвешенных слов объявлений из текстового индекса
+func textSimilarity(a, b *textDoc) float64 {
+	if a == nil || b == nil || len(a.terms) == 0 || len(b.terms) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for term, weight := range a.terms {
+		dot += weight * b.terms[term]
+		normA += weight * weight
+	}
+	for _, weight := range b.terms {
+		normB += weight * weight
+	}
+	return dot / math.Sqrt(normA*normB)
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"realty/models"
+	"slices"
+	"testing"
+)
+
+func TestFindSimilarAdvs(t *testing.T) {
+	newAdv := func(id int64, title, city string, latitude, longitude float64, dollarPrice int64, dealType int8) *AdvCache {
+		adv := newTextAdv(id, title, "", city, "")
+		adv.CurrentAdv.User = benchUser
+		adv.CurrentAdv.Approved = true
+		adv.CurrentAdv.Latitude = latitude
+		adv.CurrentAdv.Longitude = longitude
+		adv.CurrentAdv.DollarPrice = dollarPrice
+		adv.CurrentAdv.DealType = dealType
+		adv.Watches = &WatchesCache{}
+		return adv
+	}
+	origin := newAdv(1, "Двухкомнатная квартира у парка", "Москва", 55.75, 37.62, 200_000, models.DealTypeSale)
+	twin := newAdv(2, "Квартира у парка", "Москва", 55.76, 37.63, 210_000, models.DealTypeSale)
+	expensive := newAdv(3, "Квартира у парка", "Москва", 55.76, 37.63, 2_000_000, models.DealTypeSale)
+	farther := newAdv(4, "Дом", "Химки", 55.89, 37.43, 200_000, models.DealTypeSale)
+	rent := newAdv(5, "Квартира у парка", "Москва", 55.75, 37.62, 200_000, models.DealTypeRent)
+	tooFar := newAdv(6, "Квартира у парка", "Тверь", 56.86, 35.9, 200_000, models.DealTypeSale)
+	hidden := newAdv(7, "Квартира у парка", "Москва", 55.75, 37.62, 200_000, models.DealTypeSale)
+	hidden.CurrentAdv.Approved = false
+
+	advs = nil
+	advsGeoIndex = newGeoIndex()
+	advsTextIndex = newTextIndex()
+	for _, adv := range []*AdvCache{origin, twin, expensive, farther, rent, tooFar, hidden} {
+		advs = append(advs, adv)
+		advsGeoIndex.add(adv)
+		advsTextIndex.add(adv)
+	}
+
+	result := FindSimilarAdvs(origin, 10)
+	ids := make([]int64, 0, len(result))
+	for _, item := range result {
+		ids = append(ids, item.Id)
+		if item.Similarity <= 0 || item.Similarity > 1 {
+			t.Errorf("similarity %v of %d is out of (0, 1]", item.Similarity, item.Id)
+		}
+	}
+	if !slices.Equal(ids, []int64{2, 3, 4}) {
+		t.Errorf("got %v, want [2 3 4]", ids)
+	}
+	if result = FindSimilarAdvs(origin, 1); len(result) != 1 || result[0].Id != 2 {
+		t.Errorf("limit is not applied: %v", result)
+	}
+	advsTextIndex = newTextIndex()
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"cmp"
+	"realty/dto"
+	"slices"
+	"strings"
+)
+
+// Значения sort: price, updated, watches, distance - по возрастанию,
+// с "-" перед полем (-price) - по убыванию. relevance - только по убыванию.
+// При равенстве порядок определяется по id, как при сортировке без sort с учетом FirstNew
+
+// foundAdv объявление, подошедшее под фильтр, с копиями значений для сортировки.
+// Копии нужны, чтобы значения не менялись во время сортировки и их можно было положить в курсор
+type foundAdv struct {
+	adv         *AdvCache
+	id          int64
+	distance    float64
+	relevance   float64
+	dollarPrice int64
+	updated     int64
+	watches     int64
+}
+
+func newFoundAdv(adv *AdvCache, distance float64, relevance float64) foundAdv {
+	//adv.mu не берем: под advsRWMutex это может привести к взаимной блокировке с UpdateAdv
+	return foundAdv{
+		adv:         adv,
+		id:          adv.CurrentAdv.Id,
+		distance:    distance,
+		relevance:   relevance,
+		dollarPrice: adv.CurrentAdv.DollarPrice,
+		updated:     adv.CurrentAdv.Updated.UnixNano(),
+		watches:     adv.Watches.Watches.Count,
+	}
+}
+
+func compareFoundAdvs(sort string, firstNew bool) func(a, b foundAdv) int {
+	var compare func(a, b foundAdv) int
+	switch strings.TrimPrefix(sort, "-") {
+	case "price":
+		compare = func(a, b foundAdv) int {
+			return cmp.Compare(a.dollarPrice, b.dollarPrice)
+		}
+	case "updated":
+		compare = func(a, b foundAdv) int {
+			return cmp.Compare(a.updated, b.updated)
+		}
+	case "watches":
+		compare = func(a, b foundAdv) int {
+			return cmp.Compare(a.watches, b.watches)
+		}
+	case "distance":
+		compare = func(a, b foundAdv) int {
+			return cmp.Compare(a.distance, b.distance)
+		}
+	default:
+		//relevance, а также сортировка по умолчанию при текстовом запросе
+		compare = func(a, b foundAdv) int {
+			return cmp.Compare(b.relevance, a.relevance)
+		}
+	}
+	desc := strings.HasPrefix(sort, "-")
+	return func(a, b foundAdv) int {
+		var result int
+		if desc {
+			result = compare(b, a)
+		} else {
+			result = compare(a, b)
+		}
+		if result != 0 {
+			return result
+		}
+		if firstNew {
+			return cmp.Compare(b.id, a.id)
+		}
+		return cmp.Compare(a.id, b.id)
+	}
+}
+
+// pageFoundAdvs сортирует объявления и возвращает страницу, начиная с offset или,
+// если задан cursor, сразу после него. Курсор следующей страницы nil, если страница последняя
+func pageFoundAdvs(matches []foundAdv, sort string, firstNew bool, cursor *AdvsCursor, offset int, limit int) ([]*dto.GetAdvResponseItem, *AdvsCursor) {
+	compare := compareFoundAdvs(sort, firstNew)
+	slices.SortFunc(matches, compare)
+	start := offset
+	if cursor != nil {
+		i, found := slices.BinarySearchFunc(matches, cursor.foundAdv(), compare)
+		if found {
+			i++
+		}
+		start = i
+	}
+	if start >= len(matches) {
+		return []*dto.GetAdvResponseItem{}, nil
+	}
+	end := min(start+limit, len(matches))
+	result := make([]*dto.GetAdvResponseItem, 0, end-start)
+	for _, match := range matches[start:end] {
+		response := newAdvResponseItem(match.adv)
+		response.Distance = match.distance
+		response.Relevance = match.relevance
+		result = append(result, response)
+	}
+	var next *AdvsCursor
+	if end < len(matches) {
+		next = newAdvsCursor(sort, firstNew, matches[end-1])
+	}
+	return result, next
+}
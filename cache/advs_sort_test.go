@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"math"
+	"realty/dto"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFindAdvsSort(t *testing.T) {
+	fillAdvs(300)
+	for i, adv := range advs {
+		adv.CurrentAdv.UserId = benchUser.Id
+		//повторяющиеся значения, чтобы проверить порядок при равенстве
+		adv.CurrentAdv.DollarPrice = int64(i % 7)
+		adv.CurrentAdv.Updated = time.Unix(int64(i%5), 0)
+		adv.Watches.Watches.Count = int64(i % 3)
+	}
+	keys := map[string]func(adv *AdvCache) float64{
+		"price":   func(adv *AdvCache) float64 { return float64(adv.CurrentAdv.DollarPrice) },
+		"updated": func(adv *AdvCache) float64 { return float64(adv.CurrentAdv.Updated.Unix()) },
+		"watches": func(adv *AdvCache) float64 { return float64(adv.Watches.Watches.Count) },
+	}
+	ids := make(map[int64]*AdvCache, len(advs))
+	for _, adv := range advs {
+		ids[adv.CurrentAdv.Id] = adv
+	}
+	for field, key := range keys {
+		for _, sort := range []string{field, "-" + field} {
+			for _, firstNew := range []bool{false, true} {
+				filter := &AdvsFilter{MaxDollarPrice: math.MaxInt64, MinLongitude: -180, MaxLongitude: 180,
+					MinLatitude: -90, MaxLatitude: 90, Sort: sort, FirstNew: firstNew}
+				all, count, _, _ := FindAdvs(filter, nil, 0, len(advs))
+				if count != len(advs) || len(all) != len(advs) {
+					t.Fatalf("%s: got %d of %d advs", sort, len(all), count)
+				}
+				for i := 1; i < len(all); i++ {
+					a, b := key(ids[all[i-1].Id]), key(ids[all[i].Id])
+					if sort[0] == '-' {
+						a, b = b, a
+					}
+					if a > b || a == b && (all[i-1].Id < all[i].Id) == firstNew {
+						t.Fatalf("%s firstNew=%v: wrong order at %d", sort, firstNew, i)
+					}
+				}
+				paged := all[:0:0]
+				for offset := 0; offset < len(advs); offset += 7 {
+					page, _, _, _ := FindAdvs(filter, nil, offset, 7)
+					paged = append(paged, page...)
+				}
+				if !slices.EqualFunc(all, paged, equalIds) {
+					t.Fatalf("%s firstNew=%v: pages differ from full list", sort, firstNew)
+				}
+				if paged = findAllByCursor(filter, 7); !slices.EqualFunc(all, paged, equalIds) {
+					t.Fatalf("%s firstNew=%v: cursor pages differ from full list", sort, firstNew)
+				}
+			}
+		}
+	}
+
+	userAdvs, count, next := FindUsersAdvs(benchUser.Id, "-watches", nil, 0, 10, true)
+	if count != len(advs) || len(userAdvs) != 10 {
+		t.Fatalf("FindUsersAdvs: got %d of %d advs", len(userAdvs), count)
+	}
+	for i := 1; i < len(userAdvs); i++ {
+		if userAdvs[i-1].Watches < userAdvs[i].Watches {
+			t.Fatalf("FindUsersAdvs: wrong order at %d", i)
+		}
+	}
+	nextAdvs, _, _ := FindUsersAdvs(benchUser.Id, "-watches", next, 0, 10, true)
+	expected, _, _ := FindUsersAdvs(benchUser.Id, "-watches", nil, 10, 10, true)
+	if !slices.EqualFunc(nextAdvs, expected, equalIds) {
+		t.Fatalf("FindUsersAdvs: cursor page differs from offset page")
+	}
+}
+
+func TestFindAdvsCursor(t *testing.T) {
+	fillAdvs(100)
+	for _, firstNew := range []bool{false, true} {
+		filter := &AdvsFilter{MaxDollarPrice: math.MaxInt64, MinLongitude: -180, MaxLongitude: 180,
+			MinLatitude: -90, MaxLatitude: 90, FirstNew: firstNew}
+		all, _, next, _ := FindAdvs(filter, nil, 0, len(advs))
+		if next != nil {
+			t.Fatalf("firstNew=%v: cursor after the last page", firstNew)
+		}
+		if paged := findAllByCursor(filter, 9); !slices.EqualFunc(all, paged, equalIds) {
+			t.Fatalf("firstNew=%v: cursor pages differ from full list", firstNew)
+		}
+	}
+
+	//объявление, добавленное между запросами, не должно сдвигать следующую страницу
+	filter := &AdvsFilter{MaxDollarPrice: math.MaxInt64, MinLongitude: -180, MaxLongitude: 180,
+		MinLatitude: -90, MaxLatitude: 90, FirstNew: true}
+	_, _, next, _ := FindAdvs(filter, nil, 0, 10)
+	expected, _, _, _ := FindAdvs(filter, nil, 10, 10)
+	last := advs[len(advs)-1]
+	adv := &AdvCache{CurrentAdv: last.CurrentAdv, Watches: &WatchesCache{}}
+	adv.CurrentAdv.Id++
+	advs = append(advs, adv)
+	advsGeoIndex.add(adv)
+	cursor, err := DecodeAdvsCursor(next.Encode(), "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, _, _ := FindAdvs(filter, cursor, 0, 10)
+	if !slices.EqualFunc(second, expected, equalIds) {
+		t.Fatalf("second page changed after adding an adv")
+	}
+	shifted, _, _, _ := FindAdvs(filter, nil, 10, 10)
+	if slices.EqualFunc(shifted, expected, equalIds) {
+		t.Fatalf("offset page must shift after adding an adv")
+	}
+
+	if _, err := DecodeAdvsCursor(next.Encode(), "price", true); err == nil {
+		t.Errorf("cursor accepted for another sort")
+	}
+	if _, err := DecodeAdvsCursor("not a cursor", "", true); err == nil {
+		t.Errorf("invalid cursor accepted")
+	}
+}
+
+func equalIds(a, b *dto.GetAdvResponseItem) bool {
+	return a.Id == b.Id
+}
+
+// findAllByCursor проходит все страницы по курсору
+func findAllByCursor(filter *AdvsFilter, limit int) []*dto.GetAdvResponseItem {
+	result := make([]*dto.GetAdvResponseItem, 0)
+	var cursor *AdvsCursor
+	for {
+		page, _, next, _ := FindAdvs(filter, cursor, 0, limit)
+		result = append(result, page...)
+		if next == nil {
+			return result
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"realty/db"
+	"realty/models"
+	"slices"
+	"sync"
+)
+
+type ApiKeyCache struct {
+	ApiKey   models.ApiKey
+	ToCreate bool
+	ToUpdate bool
+	ToDelete bool
+	Deleted  bool
+	mu       sync.RWMutex
+}
+
+func (apiKey *ApiKeyCache) Save() error {
+	apiKey.mu.Lock()
+	defer apiKey.mu.Unlock()
+	if apiKey.Deleted {
+		return nil
+	}
+	if apiKey.ToDelete {
+		if !apiKey.ToCreate {
+			err := db.DeleteApiKey(apiKey.ApiKey.Id)
+			if err != nil {
+				return err
+			}
+		}
+		apiKey.Deleted = true
+		apiKey.ToDelete = false
+		apiKey.ToCreate = false
+		apiKey.ToUpdate = false
+	}
+	if apiKey.ToCreate {
+		err := db.CreateApiKey(&apiKey.ApiKey)
+		if err != nil {
+			return err
+		}
+		apiKey.ToCreate = false
+		apiKey.ToUpdate = false
+	}
+	if apiKey.ToUpdate {
+		err := db.UpdateApiKey(&apiKey.ApiKey)
+		if err != nil {
+			return err
+		}
+		apiKey.ToUpdate = false
+	}
+	return nil
+}
+
+func (apiKey *ApiKeyCache) isActive() bool {
+	apiKey.mu.RLock()
+	defer apiKey.mu.RUnlock()
+	return !apiKey.ToDelete && !apiKey.Deleted
+}
+
+// apiScopeNames названия областей из битовой маски, по алфавиту
+func apiScopeNames(scopes uint8) []string {
+	names := make([]string, 0, len(models.ApiScopes))
+	for name, scope := range models.ApiScopes {
+		if scopes&scope != 0 {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -1,13 +1,25 @@
 package cache
 
 import (
+	"cmp"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"realty/application"
+	"realty/config"
+	"realty/currency"
 	"realty/db"
 	"realty/dto"
+	"realty/mailer"
 	"realty/models"
+	"realty/notify"
 	"realty/utils"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -23,14 +35,26 @@ type SaveTask struct {
 }
 
 var users []*UserCache
+var usersByEmail map[string]*UserCache       //ключ - нормализованный email, удаленные тоже остаются, email в БД уникален
+var usersByVerifyToken map[string]*UserCache //ключ - токен подтверждения email, устаревшие удаляются в VerifyEmail
+var sessions []*SessionCache
+var apiKeys []*ApiKeyCache
+var invites []*InviteCache
 var advs []*AdvCache
+var advsGeoIndex = newGeoIndex()
+var advsTextIndex = newTextIndex()
 var photos []*PhotoCache
 var watches []*WatchesCache
+var savedSearches []*SavedSearchCache
 
 var usersRWMutex sync.RWMutex
+var sessionsRWMutex sync.RWMutex
+var apiKeysRWMutex sync.RWMutex
+var invitesRWMutex sync.RWMutex
 var advsRWMutex sync.RWMutex
 var photosRWMutex sync.RWMutex
 var watchesRWMutex sync.RWMutex
+var savedSearchesRWMutex sync.RWMutex
 
 var toSave chan SaveTask
 
@@ -39,7 +63,7 @@ func GetToSaveCount() int64 {
 }
 
 func Initialize() {
-	users_, advs_, photos_, watches_, errDb := db.ReadDb()
+	users_, sessions_, apiKeys_, invites_, advs_, photos_, watches_, savedSearches_, errDb := db.ReadDb()
 	if errDb != nil {
 		panic(errDb)
 	}
@@ -66,6 +90,8 @@ func Initialize() {
 	}
 
 	users = make([]*UserCache, len(users_), len(users_)+100)
+	usersByEmail = make(map[string]*UserCache, len(users_)+100)
+	usersByVerifyToken = make(map[string]*UserCache)
 	for i := range len(users_) {
 		user := users_[i]
 		users[i] = &UserCache{
@@ -76,10 +102,33 @@ func Initialize() {
 			Deleted:     false,
 			mu:          sync.RWMutex{},
 		}
+		email := utils.NormalizeEmail(user.Email)
+		if _, ok := usersByEmail[email]; ok {
+			slog.Warn("Initialize", "userId", user.Id, "msg", "duplicate email "+email)
+			continue
+		}
+		usersByEmail[email] = users[i]
+		if user.VerifyToken != "" {
+			usersByVerifyToken[user.VerifyToken] = users[i]
+		}
+	}
+	sessions = make([]*SessionCache, len(sessions_), len(sessions_)+100)
+	for i := range len(sessions_) {
+		sessions[i] = &SessionCache{Session: *sessions_[i]}
+	}
+	apiKeys = make([]*ApiKeyCache, len(apiKeys_), len(apiKeys_)+100)
+	for i := range len(apiKeys_) {
+		apiKeys[i] = &ApiKeyCache{ApiKey: *apiKeys_[i]}
+	}
+	invites = make([]*InviteCache, len(invites_), len(invites_)+100)
+	for i := range len(invites_) {
+		invites[i] = &InviteCache{Invite: *invites_[i]}
+		invites[i].updateUsed()
 	}
 	advs = make([]*AdvCache, len(advs_), len(advs_)+500)
 	for i := range len(advs_) {
 		adv := advs_[i]
+		adv.DollarPrice = currency.CalcDollarPrice(adv.Currency, adv.Price)
 		advs[i] = &AdvCache{
 			CurrentAdv: *adv,
 			OldAdv:     *adv,
@@ -90,8 +139,22 @@ func Initialize() {
 			Deleted:    false,
 			mu:         sync.RWMutex{},
 		}
+		advsGeoIndex.add(advs[i])
+		advsTextIndex.add(advs[i])
 	}
 
+	savedSearches = make([]*SavedSearchCache, 0, len(savedSearches_)+100)
+	for i := range len(savedSearches_) {
+		searchCache, err := newSavedSearchCache(savedSearches_[i])
+		if err != nil {
+			slog.Error("Initialize", "savedSearchId", savedSearches_[i].Id, "msg", err.Error())
+			continue
+		}
+		savedSearches = append(savedSearches, searchCache)
+	}
+
+	currency.SetOnReload(onCurrencyRatesReload)
+
 	//todo надо просмотры и фото в adv добавить
 	toSave = make(chan SaveTask, 1000)
 
@@ -139,6 +202,39 @@ func Initialize() {
 				}
 			}
 			time.Sleep(time.Second)
+			for i := range len(sessions) {
+				if application.IsGracefullyStopped() {
+					return
+				}
+				if err := sessions[i].Save(); err != nil {
+					application.IncDbErrorCounter()
+					slog.Error("saving", "msg", err.Error())
+					time.Sleep(time.Millisecond * 100)
+				}
+			}
+			time.Sleep(time.Second)
+			for i := range len(apiKeys) {
+				if application.IsGracefullyStopped() {
+					return
+				}
+				if err := apiKeys[i].Save(); err != nil {
+					application.IncDbErrorCounter()
+					slog.Error("saving", "msg", err.Error())
+					time.Sleep(time.Millisecond * 100)
+				}
+			}
+			time.Sleep(time.Second)
+			for i := range len(invites) {
+				if application.IsGracefullyStopped() {
+					return
+				}
+				if err := invites[i].Save(); err != nil {
+					application.IncDbErrorCounter()
+					slog.Error("saving", "msg", err.Error())
+					time.Sleep(time.Millisecond * 100)
+				}
+			}
+			time.Sleep(time.Second)
 			for i := range len(photos) {
 				if application.IsGracefullyStopped() {
 					return
@@ -160,6 +256,17 @@ func Initialize() {
 					time.Sleep(time.Millisecond * 100)
 				}
 			}
+			time.Sleep(time.Second)
+			for i := range len(savedSearches) {
+				if application.IsGracefullyStopped() {
+					return
+				}
+				if err := savedSearches[i].Save(); err != nil {
+					application.IncDbErrorCounter()
+					slog.Error("saving", "msg", err.Error())
+					time.Sleep(time.Millisecond * 100)
+				}
+			}
 		}
 	}()
 }
@@ -198,15 +305,11 @@ func FindUserCacheById(id int64) *UserCache {
 func FindUserCacheByLogin(email string) *UserCache {
 	usersRWMutex.RLock()
 	defer usersRWMutex.RUnlock()
-	for i := range len(users) {
-		if users[i].CurrentUser.Email == email {
-			if users[i].ToDelete || users[i].Deleted {
-				return nil
-			}
-			return users[i]
-		}
+	userCache := usersByEmail[utils.NormalizeEmail(email)]
+	if userCache == nil || userCache.ToDelete || userCache.Deleted {
+		return nil
 	}
-	return nil
+	return userCache
 }
 
 func FindAdvById(id int64) *models.Adv {
@@ -287,81 +390,154 @@ func FindWatchesCacheById(advId int64) *WatchesCache {
 	return nil
 }
 
-func FindAdvs(minDollarPrice int64, maxDollarPrice int64, minLongitude float64,
-	maxLongitude float64, minLatitude float64, maxLatitude float64, countryCode string,
-	location string, offset int, limit int, firstNew bool) ([]*dto.GetAdvResponseItem, int) {
-	result := make([]*dto.GetAdvResponseItem, 0, limit)
+// FindAdvs возвращает страницу объявлений, подходящих под фильтр, их общее количество,
+// курсор следующей страницы и фасеты, если они запрошены. Если cursor не nil, offset не используется
+func FindAdvs(filter *AdvsFilter, cursor *AdvsCursor, offset int, limit int) ([]*dto.GetAdvResponseItem, int, *AdvsCursor, *dto.AdvFacets) {
 	advsRWMutex.RLock()
 	defer advsRWMutex.RUnlock()
+	list, scores := findCandidates(filter)
+	var facets *facetsCounter
+	if filter.Facets {
+		facets = newFacetsCounter()
+	}
+	if filter.Sort != "" || scores != nil {
+		return findAdvsSorted(list, filter, scores, facets, cursor, offset, limit)
+	}
+	result := make([]*dto.GetAdvResponseItem, 0, limit)
 	var i, step, count int
-	length := len(advs)
-	if firstNew {
+	var last int64
+	var hasMore bool
+	length := len(list)
+	if filter.FirstNew {
 		i = length - 1
 		step = -1
 	} else {
 		i = 0
 		step = 1
 	}
-	var adv *models.Adv
 	for ; i < length && i >= 0; i += step {
-		if advs[i].ToDelete || advs[i].Deleted {
+		if list[i].ToDelete || list[i].Deleted {
 			continue
 		}
-		adv = &advs[i].CurrentAdv
-		if adv.Approved && adv.DollarPrice >= minDollarPrice && adv.DollarPrice <= maxDollarPrice &&
-			adv.Longitude > minLongitude && adv.Longitude < maxLongitude &&
-			adv.Latitude > minLatitude && adv.Latitude < maxLatitude &&
-			(countryCode == "" || adv.Country == countryCode) &&
-			(location == "" || strings.Contains(adv.Address, location)) {
-			count++
-			if offset > 0 {
-				offset--
-				continue
-			}
-			if limit > 0 {
-				limit--
-			} else {
-				continue
-			}
-			response := &dto.GetAdvResponseItem{
-				Id:           adv.Id,
-				UserEmail:    adv.User.Email,
-				UserName:     adv.User.Name,
-				Created:      time.UnixMicro(adv.Id / 1000),
-				Updated:      adv.Updated,
-				Approved:     adv.Approved,
-				Lang:         adv.Lang,
-				OriginLang:   adv.OriginLang,
-				TranslatedBy: adv.TranslatedBy,
-				Title:        adv.Title,
-				Description:  adv.Description,
-				Photos:       advs[i].GetPhotosFilenames(),
-				Price:        adv.Price,
-				Currency:     adv.Currency,
-				DollarPrice:  adv.DollarPrice,
-				Country:      adv.Country,
-				City:         adv.City,
-				Address:      adv.Address,
-				Latitude:     adv.Latitude,
-				Longitude:    adv.Longitude,
-				Watches:      advs[i].Watches.Watches.Count,
-				SeVisible:    adv.SeVisible,
-			}
-			result = append(result, response)
+		distance, ok := filter.match(&list[i].CurrentAdv)
+		if !ok {
+			continue
+		}
+		count++
+		facets.add(&list[i].CurrentAdv)
+		if cursor != nil && !cursor.after(list[i].CurrentAdv.Id) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
 		}
+		if limit > 0 {
+			limit--
+		} else {
+			hasMore = true
+			continue
+		}
+		response := newAdvResponseItem(list[i])
+		response.Distance = distance
+		result = append(result, response)
+		last = response.Id
 	}
-	return result, count
+	if !hasMore {
+		return result, count, nil, facets.result()
+	}
+	return result, count, &AdvsCursor{FirstNew: filter.FirstNew, Id: last}, facets.result()
 }
 
-func FindUsersAdvs(userId int64, offset, limit int, firstNew bool) ([]*dto.GetAdvResponseItem, int) {
+// findCandidates выбирает объявления для проверки фильтром из текстового или пространственного индекса,
+// для текстового запроса также возвращает релевантность. Вызывается под advsRWMutex
+func findCandidates(filter *AdvsFilter) ([]*AdvCache, map[*AdvCache]float64) {
+	if filter.Query != "" {
+		scores := advsTextIndex.search(filter.Query)
+		list := make([]*AdvCache, 0, len(scores))
+		for adv := range scores {
+			list = append(list, adv)
+		}
+		slices.SortFunc(list, compareAdvIds)
+		return list, scores
+	}
+	minLatitude, maxLatitude, minLongitude, maxLongitude := filter.bbox()
+	if candidates, ok := advsGeoIndex.query(minLatitude, maxLatitude, minLongitude, maxLongitude, len(advs)/2); ok {
+		return candidates, nil
+	}
+	return advs, nil
+}
+
+// findAdvsSorted сортирует подходящие объявления по filter.Sort или,
+// если задан только текстовый запрос, по релевантности
+func findAdvsSorted(list []*AdvCache, filter *AdvsFilter, scores map[*AdvCache]float64, facets *facetsCounter,
+	cursor *AdvsCursor, offset int, limit int) ([]*dto.GetAdvResponseItem, int, *AdvsCursor, *dto.AdvFacets) {
+	matches := make([]foundAdv, 0)
+	for _, adv := range list {
+		if adv.ToDelete || adv.Deleted {
+			continue
+		}
+		if distance, ok := filter.match(&adv.CurrentAdv); ok {
+			matches = append(matches, newFoundAdv(adv, distance, scores[adv]))
+			facets.add(&adv.CurrentAdv)
+		}
+	}
+	result, next := pageFoundAdvs(matches, filter.Sort, filter.FirstNew, cursor, offset, limit)
+	return result, len(matches), next, facets.result()
+}
+
+func newAdvResponseItem(advCache *AdvCache) *dto.GetAdvResponseItem {
+	adv := &advCache.CurrentAdv
+	response := &dto.GetAdvResponseItem{
+		Id:           adv.Id,
+		UserEmail:    adv.User.Email,
+		UserName:     adv.User.Name,
+		Created:      time.UnixMicro(adv.Id / 1000),
+		Updated:      adv.Updated,
+		Approved:     adv.Approved,
+		Lang:         adv.Lang,
+		OriginLang:   adv.OriginLang,
+		TranslatedBy: adv.TranslatedBy,
+		Title:        adv.Title,
+		Description:  adv.Description,
+		Photos:       advCache.GetPhotosFilenames(),
+		Price:        adv.Price,
+		Currency:     adv.Currency,
+		DollarPrice:  adv.DollarPrice,
+		Country:      adv.Country,
+		City:         adv.City,
+		Address:      adv.Address,
+		Latitude:     adv.Latitude,
+		Longitude:    adv.Longitude,
+		Watches:      advCache.Watches.Watches.Count,
+		SeVisible:    adv.SeVisible,
+		DuplicateOf:  adv.DuplicateOf,
+	}
+	setAdvAttributes(response, adv)
+	return response
+}
+
+func FindUsersAdvs(userId int64, sort string, cursor *AdvsCursor, offset, limit int, firstNew bool) ([]*dto.GetAdvResponseItem, int, *AdvsCursor) {
 	advsRWMutex.RLock()
 	defer advsRWMutex.RUnlock()
 	length := len(advs)
 	if offset > length {
-		return []*dto.GetAdvResponseItem{}, 0 //todo ?
+		return []*dto.GetAdvResponseItem{}, 0, nil //todo ?
+	}
+	if sort != "" {
+		matches := make([]foundAdv, 0)
+		for _, adv := range advs {
+			if !adv.ToDelete && !adv.Deleted && adv.CurrentAdv.UserId == userId {
+				matches = append(matches, newFoundAdv(adv, 0, 0))
+			}
+		}
+		result, next := pageFoundAdvs(matches, sort, firstNew, cursor, offset, limit)
+		return result, len(matches), next
 	}
 	result := make([]*dto.GetAdvResponseItem, 0, limit)
 	var i, step, count int
+	var last int64
+	var hasMore bool
 	if firstNew {
 		i = length - 1
 		step = -1
@@ -369,14 +545,15 @@ func FindUsersAdvs(userId int64, offset, limit int, firstNew bool) ([]*dto.GetAd
 		i = 0
 		step = 1
 	}
-	var adv *models.Adv
 	for ; i < length && i >= 0; i += step {
 		if advs[i].ToDelete || advs[i].Deleted {
 			continue
 		}
-		adv = &advs[i].CurrentAdv
-		if adv.UserId == userId {
+		if advs[i].CurrentAdv.UserId == userId {
 			count++
+			if cursor != nil && !cursor.after(advs[i].CurrentAdv.Id) {
+				continue
+			}
 			if offset > 0 {
 				offset--
 				continue
@@ -384,39 +561,21 @@ func FindUsersAdvs(userId int64, offset, limit int, firstNew bool) ([]*dto.GetAd
 			if limit > 0 {
 				limit--
 			} else {
+				hasMore = true
 				continue
 			}
-			response := &dto.GetAdvResponseItem{
-				Id:           adv.Id,
-				UserEmail:    adv.User.Email,
-				UserName:     adv.User.Name,
-				Created:      time.UnixMicro(adv.Id / 1000),
-				Updated:      adv.Updated,
-				Approved:     adv.Approved,
-				Lang:         adv.Lang,
-				OriginLang:   adv.OriginLang,
-				TranslatedBy: adv.TranslatedBy,
-				Title:        adv.Title,
-				Description:  adv.Description,
-				Photos:       advs[i].GetPhotosFilenames(),
-				Price:        adv.Price,
-				Currency:     adv.Currency,
-				DollarPrice:  adv.DollarPrice,
-				Country:      adv.Country,
-				City:         adv.City,
-				Address:      adv.Address,
-				Latitude:     adv.Latitude,
-				Longitude:    adv.Longitude,
-				Watches:      advs[i].Watches.Watches.Count,
-				SeVisible:    adv.SeVisible,
-			}
-			result = append(result, response)
+			result = append(result, newAdvResponseItem(advs[i]))
+			last = advs[i].CurrentAdv.Id
 		}
 	}
-	return result, count
+	if !hasMore {
+		return result, count, nil
+	}
+	return result, count, &AdvsCursor{FirstNew: firstNew, Id: last}
 }
 
-func CreateAdv(requestId int64, user *models.User, request *dto.CreateAdvRequest) int64 {
+// CreateAdv duplicateOf - id оригинала, если объявление признано повтором, иначе 0
+func CreateAdv(requestId int64, user *models.User, request *dto.CreateAdvRequest, duplicateOf int64) int64 {
 	id := utils.GenerateId()
 	newAdv := &models.Adv{
 		Id:           id,
@@ -432,7 +591,7 @@ func CreateAdv(requestId int64, user *models.User, request *dto.CreateAdvRequest
 		Description:  request.Description,
 		Price:        request.Price,
 		Currency:     request.Currency,
-		DollarPrice:  0, //todo
+		DollarPrice:  0, //считается под advsRWMutex, чтобы не разминуться с UpdateDollarPrices
 		Country:      request.Country,
 		City:         request.City,
 		Address:      request.Address,
@@ -442,6 +601,16 @@ func CreateAdv(requestId int64, user *models.User, request *dto.CreateAdvRequest
 		SeVisible:    true,
 		UserComment:  request.UserComment,
 		AdminComment: "",
+		DealType:     request.DealType,
+		RentPeriod:   request.RentPeriod,
+		PropertyType: request.PropertyType,
+		Rooms:        request.Rooms,
+		Floor:        request.Floor,
+		Floors:       request.Floors,
+		YearBuilt:    request.YearBuilt,
+		TotalArea:    request.TotalArea,
+		LivingArea:   request.LivingArea,
+		DuplicateOf:  duplicateOf,
 	}
 	advCache := &AdvCache{
 		CurrentAdv: *newAdv,
@@ -464,7 +633,10 @@ func CreateAdv(requestId int64, user *models.User, request *dto.CreateAdvRequest
 	defer advCache.mu.Unlock()
 
 	advsRWMutex.Lock()
+	advCache.CurrentAdv.DollarPrice = currency.CalcDollarPrice(request.Currency, request.Price)
 	advs = append(advs, advCache)
+	advsGeoIndex.add(advCache)
+	advsTextIndex.add(advCache)
 	advsRWMutex.Unlock()
 
 	watchesRWMutex.Lock()
@@ -472,27 +644,78 @@ func CreateAdv(requestId int64, user *models.User, request *dto.CreateAdvRequest
 	watchesRWMutex.Unlock()
 
 	toSave <- SaveTask{Cache: advCache, RequestId: requestId}
+	if advCache.CurrentAdv.Approved {
+		go notifySavedSearches(advCache, advCache.CurrentAdv, nil)
+	}
 	return id
 }
 
-func UpdateAdv(requestId int64, adv *AdvCache, request *dto.UpdateAdvRequest) {
+func UpdateAdv(requestId int64, adv *AdvCache, request *dto.UpdateAdvRequest, duplicateOf int64) {
 	adv.mu.Lock()
 	defer adv.mu.Unlock()
+	//после изменения уведомляем только поиски, под которые объявление раньше не подходило
+	var matchedBefore map[int64]bool
+	if adv.CurrentAdv.Approved {
+		before := adv.CurrentAdv
+		matchedBefore = matchedSavedSearches(adv, &before)
+	}
+	//FindAdvs фильтрует и сортирует по этим полям под advsRWMutex.RLock, поэтому меняем их под блокировкой на запись
+	advsRWMutex.Lock()
 	adv.CurrentAdv.OriginLang = request.OriginLang
 	adv.CurrentAdv.TranslatedBy = request.TranslatedBy
 	adv.CurrentAdv.TranslatedTo = request.TranslatedTo
-	adv.CurrentAdv.Title = request.Title
-	adv.CurrentAdv.Description = request.Description
 	adv.CurrentAdv.Price = request.Price
 	adv.CurrentAdv.Currency = request.Currency
+	adv.CurrentAdv.DollarPrice = currency.CalcDollarPrice(request.Currency, request.Price)
 	adv.CurrentAdv.Country = request.Country
+	adv.CurrentAdv.Updated = time.Now()
+	adv.CurrentAdv.Title = request.Title
+	adv.CurrentAdv.Description = request.Description
 	adv.CurrentAdv.City = request.City
 	adv.CurrentAdv.Address = request.Address
+	advsTextIndex.add(adv)
+	oldLatitude, oldLongitude := adv.CurrentAdv.Latitude, adv.CurrentAdv.Longitude
 	adv.CurrentAdv.Latitude = request.Latitude
 	adv.CurrentAdv.Longitude = request.Longitude
+	advsGeoIndex.move(adv, oldLatitude, oldLongitude)
 	adv.CurrentAdv.UserComment = request.UserComment
+	adv.CurrentAdv.DealType = request.DealType
+	adv.CurrentAdv.RentPeriod = request.RentPeriod
+	adv.CurrentAdv.PropertyType = request.PropertyType
+	adv.CurrentAdv.Rooms = request.Rooms
+	adv.CurrentAdv.Floor = request.Floor
+	adv.CurrentAdv.Floors = request.Floors
+	adv.CurrentAdv.YearBuilt = request.YearBuilt
+	adv.CurrentAdv.TotalArea = request.TotalArea
+	adv.CurrentAdv.LivingArea = request.LivingArea
+	adv.CurrentAdv.DuplicateOf = duplicateOf
+	advsRWMutex.Unlock()
 	adv.ToUpdate = true
 	toSave <- SaveTask{Cache: adv, RequestId: requestId}
+	if adv.CurrentAdv.Approved {
+		go notifySavedSearches(adv, adv.CurrentAdv, matchedBefore)
+	}
+}
+
+// onCurrencyRatesReload сохраняет новые курсы в историю и пересчитывает цены объявлений
+func onCurrencyRatesReload(date time.Time, rates map[string]float64) {
+	if err := db.SaveCurrencyRates(date, rates); err != nil {
+		application.IncDbErrorCounter()
+		slog.Error("currency", "msg", err.Error())
+	}
+	UpdateDollarPrices()
+}
+
+// UpdateDollarPrices пересчитывает DollarPrice всех объявлений по текущим курсам.
+// FindAdvs и UpdateAdv работают с DollarPrice под advsRWMutex, поэтому цены считаются и записываются под одним Lock.
+// Пересчеты после смены курсов идут по очереди, см. currency.Update
+func UpdateDollarPrices() {
+	advsRWMutex.Lock()
+	defer advsRWMutex.Unlock()
+	for _, adv := range advs {
+		adv.CurrentAdv.DollarPrice = currency.CalcDollarPrice(adv.CurrentAdv.Currency, adv.CurrentAdv.Price)
+	}
+	slog.Debug("UpdateDollarPrices", "count", len(advs))
 }
 
 func IncAdvWatches(watch *WatchesCache) {
@@ -509,14 +732,62 @@ func DeleteAdv(requestId int64, adv *AdvCache) {
 	if !adv.Deleted {
 		adv.ToDelete = true
 	}
+	advsRWMutex.Lock()
+	advsGeoIndex.remove(adv, adv.CurrentAdv.Latitude, adv.CurrentAdv.Longitude)
+	advsTextIndex.remove(adv)
+	advsRWMutex.Unlock()
 	toSave <- SaveTask{Cache: adv, RequestId: requestId}
 }
 
-func CreateUser(requestId int64, request *dto.RegisterRequest) {
+// ErrEmailExists email уже занят, в том числе удаленным пользователем
+var ErrEmailExists = errors.New("email already exists")
+
+// ошибки подтверждения email
+var (
+	ErrVerifyTokenNotFound = errors.New("verify token not found")
+	ErrVerifyTokenExpired  = errors.New("verify token expired")
+	ErrEmailVerified       = errors.New("email already verified")
+)
+
+// VerifyTokenTTL срок действия ссылки для подтверждения email
+const VerifyTokenTTL = time.Hour * 48
+
+// ошибки сброса пароля
+var (
+	ErrResetTokenInvalid = errors.New("reset token invalid")
+	ErrResetTokenExpired = errors.New("reset token expired")
+)
+
+// PasswordResetTTL срок действия одноразового токена для сброса пароля
+const PasswordResetTTL = time.Hour
+
+// ошибки приглашения при регистрации
+var (
+	ErrInviteRequired = errors.New("invite required")
+	ErrInviteNotFound = errors.New("invite not found")
+	ErrInviteExpired  = errors.New("invite expired")
+	ErrInviteUsed     = errors.New("invite used")
+)
+
+func CreateUser(requestId int64, request *dto.RegisterRequest) error {
+	email := utils.NormalizeEmail(request.Email)
 	passwordHash := utils.GeneratePasswordHash(request.Password)
+	usersRWMutex.RLock()
+	_, exists := usersByEmail[email]
+	usersRWMutex.RUnlock()
+	if exists {
+		return ErrEmailExists
+	}
+	//приглашение списываем без usersRWMutex, а если email успели занять, возвращаем использование
+	if request.InviteId != "" {
+		if err := useInvite(requestId, request.InviteId); err != nil {
+			return err
+		}
+	} else if config.IsInviteOnly() {
+		return ErrInviteRequired
+	}
 	newUser := &models.User{
-		Id:            utils.GenerateId(),
-		Email:         request.Email,
+		Email:         email,
 		Name:          request.Name,
 		PasswordHash:  passwordHash,
 		SessionSecret: utils.GenerateSessionsSecret(passwordHash),
@@ -525,16 +796,186 @@ func CreateUser(requestId int64, request *dto.RegisterRequest) {
 		Trusted:       false,
 		Enabled:       true,
 		Description:   "",
+		VerifyToken:   utils.GenerateRandomToken(20),
+		VerifyExpires: time.Now().Add(VerifyTokenTTL),
+	}
+	usersRWMutex.Lock()
+	if _, ok := usersByEmail[email]; ok {
+		usersRWMutex.Unlock()
+		if request.InviteId != "" {
+			releaseInvite(requestId, request.InviteId)
+		}
+		return ErrEmailExists
 	}
+	//id генерируем под блокировкой, чтобы users оставался отсортированным для бинарного поиска
+	newUser.Id = utils.GenerateId()
 	userCache := &UserCache{
 		CurrentUser: *newUser,
 		OldUser:     models.User{},
 		ToCreate:    true,
 	}
+	users = append(users, userCache)
+	usersByEmail[email] = userCache
+	usersByVerifyToken[newUser.VerifyToken] = userCache
+	usersRWMutex.Unlock()
+	toSave <- SaveTask{Cache: userCache, RequestId: requestId}
+	go sendVerificationEmail(newUser.Email, newUser.Name, newUser.VerifyToken)
+	return nil
+}
+
+// ResendVerificationEmail выдает новую ссылку, старая перестает работать
+func ResendVerificationEmail(requestId int64, userCache *UserCache) error {
+	token := utils.GenerateRandomToken(20)
+	userCache.mu.Lock()
+	if userCache.CurrentUser.EmailVerified {
+		userCache.mu.Unlock()
+		return ErrEmailVerified
+	}
+	oldToken := userCache.CurrentUser.VerifyToken
+	userCache.CurrentUser.VerifyToken = token
+	userCache.CurrentUser.VerifyExpires = time.Now().Add(VerifyTokenTTL)
+	userCache.ToUpdate = true
+	email, name := userCache.CurrentUser.Email, userCache.CurrentUser.Name
+	userCache.mu.Unlock()
+	toSave <- SaveTask{Cache: userCache, RequestId: requestId}
+	usersRWMutex.Lock()
+	delete(usersByVerifyToken, oldToken)
+	usersByVerifyToken[token] = userCache
+	usersRWMutex.Unlock()
+	go sendVerificationEmail(email, name, token)
+	return nil
+}
+
+func VerifyEmail(requestId int64, token string) error {
+	usersRWMutex.RLock()
+	userCache := usersByVerifyToken[token]
+	usersRWMutex.RUnlock()
+	if userCache == nil {
+		return ErrVerifyTokenNotFound
+	}
+	err := verifyEmail(requestId, userCache, token)
+	//использованный или устаревший токен больше не нужен в индексе, просроченный оставляем для понятной ошибки
+	if !errors.Is(err, ErrVerifyTokenExpired) {
+		usersRWMutex.Lock()
+		if usersByVerifyToken[token] == userCache {
+			delete(usersByVerifyToken, token)
+		}
+		usersRWMutex.Unlock()
+	}
+	return err
+}
+
+func verifyEmail(requestId int64, userCache *UserCache, token string) error {
 	userCache.mu.Lock()
 	defer userCache.mu.Unlock()
-	users = append(users, userCache)
+	//токен могли перевыпустить или сбросить после того, как он попал в индекс
+	if userCache.CurrentUser.VerifyToken != token || userCache.ToDelete || userCache.Deleted {
+		return ErrVerifyTokenNotFound
+	}
+	if !time.Now().Before(userCache.CurrentUser.VerifyExpires) {
+		return ErrVerifyTokenExpired
+	}
+	userCache.CurrentUser.EmailVerified = true
+	userCache.CurrentUser.VerifyToken = ""
+	userCache.CurrentUser.VerifyExpires = time.Time{}
+	userCache.ToUpdate = true
 	toSave <- SaveTask{Cache: userCache, RequestId: requestId}
+	return nil
+}
+
+// RequestPasswordReset выдает новый токен сброса, предыдущий перестает работать.
+// Токен вида "<userId>.<случайная строка>", в пользователе хранится только sha256 от случайной части
+func RequestPasswordReset(requestId int64, userCache *UserCache) {
+	secret := utils.GenerateRandomToken(20)
+	hash := sha256.Sum256([]byte(secret))
+	userCache.mu.Lock()
+	userCache.CurrentUser.ResetHash = hash[:]
+	userCache.CurrentUser.ResetExpires = time.Now().Add(PasswordResetTTL)
+	userCache.ToUpdate = true
+	toSave <- SaveTask{Cache: userCache, RequestId: requestId}
+	user := userCache.CurrentUser
+	userCache.mu.Unlock()
+	go sendPasswordResetEmail(user.Email, user.Name, strconv.FormatInt(user.Id, 10)+"."+secret)
+}
+
+// ResetPassword меняет пароль по токену и, как UpdatePassword, разлогинивает все устройства.
+// Токен одноразовый, письмо со ссылкой заодно подтверждает email
+func ResetPassword(requestId int64, token string, password string) error {
+	userIdStr, secret, found := strings.Cut(token, ".")
+	if !found {
+		return ErrResetTokenInvalid
+	}
+	userId, err := strconv.ParseInt(userIdStr, 10, 64)
+	if err != nil {
+		return ErrResetTokenInvalid
+	}
+	userCache := FindUserCacheById(userId)
+	if userCache == nil {
+		return ErrResetTokenInvalid
+	}
+	hash := sha256.Sum256([]byte(secret))
+	check := func() error {
+		user := &userCache.CurrentUser
+		if len(user.ResetHash) == 0 || subtle.ConstantTimeCompare(user.ResetHash, hash[:]) != 1 {
+			return ErrResetTokenInvalid
+		}
+		if !time.Now().Before(user.ResetExpires) {
+			return ErrResetTokenExpired
+		}
+		return nil
+	}
+	userCache.mu.RLock()
+	err = check()
+	userCache.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	//хеш пароля считается долго, поэтому без блокировки, а токен перепроверяется после
+	passwordHash := utils.GeneratePasswordHash(password)
+	userCache.mu.Lock()
+	defer userCache.mu.Unlock()
+	if err = check(); err != nil {
+		return err
+	}
+	userCache.CurrentUser.PasswordHash = passwordHash
+	userCache.CurrentUser.SessionSecret = utils.GenerateSessionsSecret(userCache.CurrentUser.SessionSecret[:])
+	userCache.CurrentUser.ResetHash = nil
+	userCache.CurrentUser.ResetExpires = time.Time{}
+	userCache.CurrentUser.EmailVerified = true
+	userCache.CurrentUser.VerifyToken = ""
+	userCache.CurrentUser.VerifyExpires = time.Time{}
+	userCache.ToUpdate = true
+	toSave <- SaveTask{Cache: userCache, RequestId: requestId}
+	//ключи могли выпустить с украденной учетки, поэтому отзываются вместе с сессиями
+	deleteUserSessions(requestId, userCache.CurrentUser.Id)
+	deleteUserApiKeys(requestId, userCache.CurrentUser.Id)
+	return nil
+}
+
+func sendPasswordResetEmail(email string, name string, token string) {
+	link := "https://" + config.GetDomain() + "/password/reset?token=" + token
+	message := &mailer.Message{
+		To:      email,
+		Subject: "Восстановление пароля",
+		Body:    fmt.Sprintf("Здравствуйте, %s!\n\nЧтобы задать новый пароль, перейдите по ссылке:\n%s\n\nСсылка одноразовая и действует %d минут. Если вы не запрашивали восстановление, просто проигнорируйте письмо.\n", name, link, int(PasswordResetTTL.Minutes())),
+		Created: time.Now(),
+	}
+	if err := mailer.Send(message); err != nil {
+		slog.Error("sendPasswordResetEmail", "email", email, "msg", err.Error())
+	}
+}
+
+func sendVerificationEmail(email string, name string, token string) {
+	link := "https://" + config.GetDomain() + "/verify-email?token=" + token
+	message := &mailer.Message{
+		To:      email,
+		Subject: "Подтверждение email",
+		Body:    fmt.Sprintf("Здравствуйте, %s!\n\nЧтобы подтвердить адрес, перейдите по ссылке:\n%s\n\nСсылка действует %d часов.\n", name, link, int(VerifyTokenTTL.Hours())),
+		Created: time.Now(),
+	}
+	if err := mailer.Send(message); err != nil {
+		slog.Error("sendVerificationEmail", "email", email, "msg", err.Error())
+	}
 }
 
 func UpdateUser(requestId int64, userCache *UserCache, request *dto.UpdateUserRequest) {
@@ -547,20 +988,36 @@ func UpdateUser(requestId int64, userCache *UserCache, request *dto.UpdateUserRe
 }
 
 func UpdatePassword(requestId int64, userCache *UserCache, request *dto.UpdatePasswordRequest) {
+	passwordHash := utils.GeneratePasswordHash(request.NewPassword)
 	userCache.mu.Lock()
 	defer userCache.mu.Unlock()
-	userCache.CurrentUser.PasswordHash = utils.GeneratePasswordHash(request.NewPassword)
+	userCache.CurrentUser.PasswordHash = passwordHash
 	userCache.CurrentUser.SessionSecret = utils.GenerateSessionsSecret(userCache.CurrentUser.SessionSecret[:])
 	userCache.ToUpdate = true
 	toSave <- SaveTask{Cache: userCache, RequestId: requestId}
+	deleteUserSessions(requestId, userCache.CurrentUser.Id)
+	deleteUserApiKeys(requestId, userCache.CurrentUser.Id)
+}
+
+// RehashPassword пересчитывает хеш пароля в текущем формате, сессии пользователя остаются действительными
+func RehashPassword(requestId int64, userCache *UserCache, password string) {
+	passwordHash := utils.GeneratePasswordHash(password)
+	userCache.mu.Lock()
+	defer userCache.mu.Unlock()
+	userCache.CurrentUser.PasswordHash = passwordHash
+	userCache.ToUpdate = true
+	toSave <- SaveTask{Cache: userCache, RequestId: requestId}
 }
 
+// UpdateSessionSecret выход со всех устройств: отзываются все сессии и API ключи пользователя
 func UpdateSessionSecret(requestId int64, userCache *UserCache) {
 	userCache.mu.Lock()
 	defer userCache.mu.Unlock()
 	userCache.CurrentUser.SessionSecret = utils.GenerateSessionsSecret(userCache.CurrentUser.SessionSecret[:])
 	userCache.ToUpdate = true
 	toSave <- SaveTask{Cache: userCache, RequestId: requestId}
+	deleteUserSessions(requestId, userCache.CurrentUser.Id)
+	deleteUserApiKeys(requestId, userCache.CurrentUser.Id)
 }
 
 func DeleteUser(requestId int64, userCache *UserCache) {
@@ -570,6 +1027,8 @@ func DeleteUser(requestId int64, userCache *UserCache) {
 		userCache.ToDelete = true
 	}
 	toSave <- SaveTask{Cache: userCache, RequestId: requestId}
+	deleteUserSessions(requestId, userCache.CurrentUser.Id)
+	deleteUserApiKeys(requestId, userCache.CurrentUser.Id)
 }
 
 func CreatePhoto(requestId int64, adv *AdvCache, photo *models.Photo) {
@@ -611,3 +1070,568 @@ func GetPhotosByAdvId(advId int64) []*PhotoCache {
 	photosRWMutex.RUnlock()
 	return result
 }
+
+// matchedSavedSearches id сохраненных поисков чужих пользователей, под которые подходит объявление
+func matchedSavedSearches(advCache *AdvCache, adv *models.Adv) map[int64]bool {
+	savedSearchesRWMutex.RLock()
+	list := savedSearches
+	savedSearchesRWMutex.RUnlock()
+	matched := make(map[int64]bool)
+	for _, search := range list {
+		if search.SavedSearch.UserId != adv.UserId && search.match(advCache, adv) {
+			matched[search.SavedSearch.Id] = true
+		}
+	}
+	return matched
+}
+
+// notifySavedSearches уведомляет владельцев сохраненных поисков, под которые подходит объявление,
+// кроме уже уведомленных из notified. Вызывается при создании и изменении одобренного объявления,
+// после появления модерации нужно вызывать и при одобрении
+func notifySavedSearches(advCache *AdvCache, adv models.Adv, notified map[int64]bool) {
+	savedSearchesRWMutex.RLock()
+	list := savedSearches
+	savedSearchesRWMutex.RUnlock()
+	for _, search := range list {
+		if search.SavedSearch.UserId == adv.UserId || notified[search.SavedSearch.Id] || !search.match(advCache, &adv) {
+			continue
+		}
+		user := FindUserById(search.SavedSearch.UserId)
+		if user == nil {
+			continue
+		}
+		notification := &notify.Notification{
+			UserId:     user.Id,
+			UserEmail:  user.Email,
+			SearchId:   search.SavedSearch.Id,
+			SearchName: search.SavedSearch.Name,
+			AdvId:      adv.Id,
+			AdvTitle:   adv.Title,
+			Created:    time.Now(),
+		}
+		if err := notify.Send(notification); err != nil {
+			slog.Error("notifySavedSearches", "searchId", search.SavedSearch.Id, "advId", adv.Id, "msg", err.Error())
+		}
+	}
+}
+
+func FindSavedSearchCacheById(id int64) *SavedSearchCache {
+	savedSearchesRWMutex.RLock()
+	defer savedSearchesRWMutex.RUnlock()
+	low := 0
+	high := len(savedSearches) - 1
+
+	for low <= high {
+		mid := (low + high) / 2
+		if savedSearches[mid].SavedSearch.Id == id {
+			if savedSearches[mid].ToDelete || savedSearches[mid].Deleted {
+				return nil
+			}
+			return savedSearches[mid]
+		} else if savedSearches[mid].SavedSearch.Id < id {
+			low = mid + 1
+		} else {
+			high = mid - 1
+		}
+	}
+
+	return nil
+}
+
+func FindUserSavedSearches(userId int64) []*SavedSearchCache {
+	result := make([]*SavedSearchCache, 0, 10)
+	savedSearchesRWMutex.RLock()
+	for _, search := range savedSearches {
+		if search.SavedSearch.UserId == userId && !search.ToDelete && !search.Deleted {
+			result = append(result, search)
+		}
+	}
+	savedSearchesRWMutex.RUnlock()
+	return result
+}
+
+// CreateSavedSearch сохраняет провалидированный фильтр пользователя, ошибка возвращается только для неверной области
+func CreateSavedSearch(requestId int64, userId int64, name string, request *dto.GetAdvListRequest) (int64, error) {
+	filter, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+	searchCache, err := newSavedSearchCache(&models.SavedSearch{
+		Id:      utils.GenerateId(),
+		UserId:  userId,
+		Updated: time.Now(),
+		Name:    name,
+		Filter:  string(filter),
+	})
+	if err != nil {
+		return 0, err
+	}
+	searchCache.ToCreate = true
+
+	savedSearchesRWMutex.Lock()
+	savedSearches = append(savedSearches, searchCache)
+	savedSearchesRWMutex.Unlock()
+
+	toSave <- SaveTask{Cache: searchCache, RequestId: requestId}
+	return searchCache.SavedSearch.Id, nil
+}
+
+func UpdateSavedSearch(requestId int64, search *SavedSearchCache, name string, request *dto.GetAdvListRequest) error {
+	filter, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	advsFilter, err := NewAdvsFilter(request)
+	if err != nil {
+		return err
+	}
+	search.mu.Lock()
+	defer search.mu.Unlock()
+	search.SavedSearch.Updated = time.Now()
+	search.SavedSearch.Name = name
+	search.SavedSearch.Filter = string(filter)
+	search.Request = *request
+	search.filter = advsFilter
+	search.ToUpdate = true
+	toSave <- SaveTask{Cache: search, RequestId: requestId}
+	return nil
+}
+
+func DeleteSavedSearch(requestId int64, search *SavedSearchCache) {
+	search.mu.Lock()
+	defer search.mu.Unlock()
+	if !search.Deleted {
+		search.ToDelete = true
+	}
+	toSave <- SaveTask{Cache: search, RequestId: requestId}
+}
+
+func FindInviteCacheById(id string) *InviteCache {
+	invitesRWMutex.RLock()
+	defer invitesRWMutex.RUnlock()
+	for _, invite := range invites {
+		if invite.Invite.Id == id {
+			return invite
+		}
+	}
+	return nil
+}
+
+func CreateInvite(requestId int64, createdBy int64, request *dto.CreateInviteRequest) string {
+	inviteCache := &InviteCache{
+		Invite: models.Invite{
+			Id:        utils.GenerateInviteId(),
+			Company:   request.Company,
+			CreatedBy: createdBy,
+			MaxUses:   request.MaxUses,
+			Created:   time.Now(),
+			Expires:   request.Expires,
+		},
+		ToCreate: true,
+	}
+	invitesRWMutex.Lock()
+	invites = append(invites, inviteCache)
+	invitesRWMutex.Unlock()
+	toSave <- SaveTask{Cache: inviteCache, RequestId: requestId}
+	return inviteCache.Invite.Id
+}
+
+// RevokeInvite отозванное приглашение остается в списке, чтобы было видно, кто по нему пришел
+func RevokeInvite(requestId int64, invite *InviteCache) {
+	invite.mu.Lock()
+	defer invite.mu.Unlock()
+	invite.Invite.Revoked = true
+	invite.updateUsed()
+	invite.ToUpdate = true
+	toSave <- SaveTask{Cache: invite, RequestId: requestId}
+}
+
+func useInvite(requestId int64, inviteId string) error {
+	invite := FindInviteCacheById(inviteId)
+	if invite == nil {
+		return ErrInviteNotFound
+	}
+	invite.mu.Lock()
+	defer invite.mu.Unlock()
+	if invite.Invite.Used {
+		return ErrInviteUsed
+	}
+	if invite.isExpired(time.Now()) {
+		return ErrInviteExpired
+	}
+	invite.Invite.Uses++
+	invite.updateUsed()
+	invite.ToUpdate = true
+	toSave <- SaveTask{Cache: invite, RequestId: requestId}
+	return nil
+}
+
+// releaseInvite возвращает использование, списанное useInvite, если регистрация не удалась
+func releaseInvite(requestId int64, inviteId string) {
+	invite := FindInviteCacheById(inviteId)
+	if invite == nil {
+		return
+	}
+	invite.mu.Lock()
+	defer invite.mu.Unlock()
+	if invite.Invite.Uses > 0 {
+		invite.Invite.Uses--
+	}
+	invite.updateUsed()
+	invite.ToUpdate = true
+	toSave <- SaveTask{Cache: invite, RequestId: requestId}
+}
+
+// FindInvites приглашения от новых к старым вместе с пользователями, которые по ним зарегистрировались
+func FindInvites() []*dto.InviteResponseItem {
+	now := time.Now()
+	invitesRWMutex.RLock()
+	result := make([]*dto.InviteResponseItem, 0, len(invites))
+	byId := make(map[string]*dto.InviteResponseItem, len(invites))
+	for i := len(invites) - 1; i >= 0; i-- {
+		invite := invites[i]
+		invite.mu.RLock()
+		item := &dto.InviteResponseItem{
+			Id:        invite.Invite.Id,
+			Company:   invite.Invite.Company,
+			CreatedBy: invite.Invite.CreatedBy,
+			MaxUses:   invite.Invite.MaxUses,
+			Uses:      invite.Invite.Uses,
+			Created:   invite.Invite.Created,
+			Revoked:   invite.Invite.Revoked,
+			Active:    !invite.Invite.Used && !invite.isExpired(now),
+			Users:     make([]*dto.InviteUser, 0),
+		}
+		if !invite.Invite.Expires.IsZero() {
+			expires := invite.Invite.Expires
+			item.Expires = &expires
+		}
+		invite.mu.RUnlock()
+		result = append(result, item)
+		byId[item.Id] = item
+	}
+	invitesRWMutex.RUnlock()
+
+	usersRWMutex.RLock()
+	defer usersRWMutex.RUnlock()
+	for _, user := range users {
+		if user.ToDelete || user.Deleted {
+			continue
+		}
+		if item, ok := byId[user.CurrentUser.InviteId]; ok {
+			item.Users = append(item.Users, &dto.InviteUser{
+				Id:    user.CurrentUser.Id,
+				Email: user.CurrentUser.Email,
+				Name:  user.CurrentUser.Name,
+			})
+		}
+	}
+	return result
+}
+
+// sessionTouchInterval LastSeen обновляется не чаще, чтобы не писать в БД на каждый запрос
+const sessionTouchInterval = time.Minute
+
+func CreateSession(requestId int64, userId int64, device string, ip string, userAgent string) *SessionCache {
+	now := time.Now()
+	sessionsRWMutex.Lock()
+	//id генерируем под блокировкой, чтобы sessions оставался отсортированным для бинарного поиска
+	sessionCache := &SessionCache{
+		Session: models.Session{
+			Id:        utils.GenerateId(),
+			UserId:    userId,
+			Created:   now,
+			LastSeen:  now,
+			Device:    device,
+			Ip:        ip,
+			UserAgent: userAgent,
+		},
+		ToCreate: true,
+	}
+	sessions = append(sessions, sessionCache)
+	sessionsRWMutex.Unlock()
+	toSave <- SaveTask{Cache: sessionCache, RequestId: requestId}
+	return sessionCache
+}
+
+// legacySession сессия, к которой привязан токен старой версии без сессии
+type legacySession struct {
+	session *SessionCache
+	expires int64 //UnixNano, после истечения токена привязка больше не нужна
+}
+
+// legacySessions ключ - sha256 токена. Отозванная сессия остается в map до истечения токена,
+// чтобы тот же токен не создал новую. После перезапуска токен может привязаться еще раз
+var legacySessions = make(map[[32]byte]legacySession)
+var legacySessionsMutex sync.Mutex
+
+// BindLegacySession привязывает токен без сессии к новой сессии один раз, повторно возвращает ту же сессию.
+// nil - сессию уже отозвали
+func BindLegacySession(requestId int64, userId int64, tokenHash [32]byte, expires int64, ip string, userAgent string) *SessionCache {
+	legacySessionsMutex.Lock()
+	defer legacySessionsMutex.Unlock()
+	if bound, ok := legacySessions[tokenHash]; ok {
+		bound.session.mu.RLock()
+		defer bound.session.mu.RUnlock()
+		if bound.session.ToDelete || bound.session.Deleted || bound.session.Session.UserId != userId {
+			return nil
+		}
+		return bound.session
+	}
+	now := time.Now().UnixNano()
+	for hash, bound := range legacySessions {
+		if bound.expires < now {
+			delete(legacySessions, hash)
+		}
+	}
+	session := CreateSession(requestId, userId, "", ip, userAgent)
+	legacySessions[tokenHash] = legacySession{session: session, expires: expires}
+	return session
+}
+
+func FindSessionCacheById(id int64) *SessionCache {
+	sessionsRWMutex.RLock()
+	defer sessionsRWMutex.RUnlock()
+	i, found := slices.BinarySearchFunc(sessions, id, func(session *SessionCache, id int64) int {
+		return cmp.Compare(session.Session.Id, id)
+	})
+	if !found {
+		return nil
+	}
+	session := sessions[i]
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+	if session.ToDelete || session.Deleted {
+		return nil
+	}
+	return session
+}
+
+// FindUserSessions активные сессии пользователя, последние использованные первыми
+func FindUserSessions(userId int64, currentSessionId int64) []*dto.SessionResponseItem {
+	sessionsRWMutex.RLock()
+	list := make([]*SessionCache, 0)
+	for _, session := range sessions {
+		if session.Session.UserId == userId {
+			list = append(list, session)
+		}
+	}
+	sessionsRWMutex.RUnlock()
+	result := make([]*dto.SessionResponseItem, 0, len(list))
+	for _, session := range list {
+		session.mu.RLock()
+		if !session.ToDelete && !session.Deleted {
+			result = append(result, &dto.SessionResponseItem{
+				Id:        session.Session.Id,
+				Device:    session.Session.Device,
+				Ip:        session.Session.Ip,
+				UserAgent: session.Session.UserAgent,
+				Created:   session.Session.Created,
+				LastSeen:  session.Session.LastSeen,
+				Current:   session.Session.Id == currentSessionId,
+			})
+		}
+		session.mu.RUnlock()
+	}
+	slices.SortFunc(result, func(a, b *dto.SessionResponseItem) int {
+		return b.LastSeen.Compare(a.LastSeen)
+	})
+	return result
+}
+
+// TouchSession изменения сохраняются периодическим сохранением, а не очередью
+func TouchSession(session *SessionCache, ip string, userAgent string) {
+	now := time.Now()
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if now.Sub(session.Session.LastSeen) < sessionTouchInterval && session.Session.Ip == ip {
+		return
+	}
+	session.Session.LastSeen = now
+	session.Session.Ip = ip
+	session.Session.UserAgent = userAgent
+	if !session.ToCreate {
+		session.ToUpdate = true
+	}
+}
+
+func DeleteSession(requestId int64, session *SessionCache) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.Deleted {
+		session.ToDelete = true
+	}
+	toSave <- SaveTask{Cache: session, RequestId: requestId}
+}
+
+// deleteUserSessions после смены SessionSecret токены всех сессий уже недействительны, убираем их из списка
+func deleteUserSessions(requestId int64, userId int64) {
+	sessionsRWMutex.RLock()
+	list := make([]*SessionCache, 0)
+	for _, session := range sessions {
+		if session.Session.UserId == userId {
+			list = append(list, session)
+		}
+	}
+	sessionsRWMutex.RUnlock()
+	for _, session := range list {
+		session.mu.Lock()
+		if !session.ToDelete && !session.Deleted {
+			session.ToDelete = true
+			toSave <- SaveTask{Cache: session, RequestId: requestId}
+		}
+		session.mu.Unlock()
+	}
+}
+
+// MaxApiKeysPerUser ограничение числа активных API ключей пользователя
+const MaxApiKeysPerUser = 20
+
+// ErrApiKeysLimit у пользователя уже MaxApiKeysPerUser ключей
+var ErrApiKeysLimit = errors.New("api keys limit reached")
+
+// apiKeyTouchInterval LastUsed обновляется не чаще, чтобы не писать в БД на каждый запрос
+const apiKeyTouchInterval = time.Minute
+
+// CreateApiKey возвращает ключ вида "<id>.<случайная строка>", он показывается один раз, хранится только sha256 от случайной части
+func CreateApiKey(requestId int64, userId int64, name string, scopes uint8) (*ApiKeyCache, string, error) {
+	secret := utils.GenerateRandomToken(20)
+	hash := sha256.Sum256([]byte(secret))
+	apiKeysRWMutex.Lock()
+	//считаем ключи под той же блокировкой, чтобы параллельные запросы не превысили лимит
+	count := 0
+	for _, apiKey := range apiKeys {
+		if apiKey.ApiKey.UserId == userId && apiKey.isActive() {
+			count++
+		}
+	}
+	if count >= MaxApiKeysPerUser {
+		apiKeysRWMutex.Unlock()
+		return nil, "", ErrApiKeysLimit
+	}
+	//id генерируем под блокировкой, чтобы apiKeys оставался отсортированным для бинарного поиска
+	apiKeyCache := &ApiKeyCache{
+		ApiKey: models.ApiKey{
+			Id:      utils.GenerateId(),
+			UserId:  userId,
+			Name:    name,
+			Hash:    hash[:],
+			Scopes:  scopes,
+			Created: time.Now(),
+		},
+		ToCreate: true,
+	}
+	apiKeys = append(apiKeys, apiKeyCache)
+	apiKeysRWMutex.Unlock()
+	toSave <- SaveTask{Cache: apiKeyCache, RequestId: requestId}
+	return apiKeyCache, strconv.FormatInt(apiKeyCache.ApiKey.Id, 10) + "." + secret, nil
+}
+
+func FindApiKeyCacheById(id int64) *ApiKeyCache {
+	apiKeysRWMutex.RLock()
+	defer apiKeysRWMutex.RUnlock()
+	i, found := slices.BinarySearchFunc(apiKeys, id, func(apiKey *ApiKeyCache, id int64) int {
+		return cmp.Compare(apiKey.ApiKey.Id, id)
+	})
+	if !found || !apiKeys[i].isActive() {
+		return nil
+	}
+	return apiKeys[i]
+}
+
+// FindApiKeyByToken ключ из заголовка Authorization, nil если ключ неверный или отозван
+func FindApiKeyByToken(token string) *ApiKeyCache {
+	idStr, secret, found := strings.Cut(token, ".")
+	if !found {
+		return nil
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil
+	}
+	apiKey := FindApiKeyCacheById(id)
+	if apiKey == nil {
+		return nil
+	}
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(apiKey.ApiKey.Hash, hash[:]) != 1 {
+		return nil
+	}
+	return apiKey
+}
+
+// FindUserApiKeys активные ключи пользователя, новые первыми
+func FindUserApiKeys(userId int64) []*dto.ApiKeyResponseItem {
+	apiKeysRWMutex.RLock()
+	list := make([]*ApiKeyCache, 0)
+	for _, apiKey := range apiKeys {
+		if apiKey.ApiKey.UserId == userId {
+			list = append(list, apiKey)
+		}
+	}
+	apiKeysRWMutex.RUnlock()
+	result := make([]*dto.ApiKeyResponseItem, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		apiKey := list[i]
+		apiKey.mu.RLock()
+		if !apiKey.ToDelete && !apiKey.Deleted {
+			item := &dto.ApiKeyResponseItem{
+				Id:         apiKey.ApiKey.Id,
+				Name:       apiKey.ApiKey.Name,
+				Scopes:     apiScopeNames(apiKey.ApiKey.Scopes),
+				Created:    apiKey.ApiKey.Created,
+				LastUsedIp: apiKey.ApiKey.LastUsedIp,
+			}
+			if !apiKey.ApiKey.LastUsed.IsZero() {
+				lastUsed := apiKey.ApiKey.LastUsed
+				item.LastUsed = &lastUsed
+			}
+			result = append(result, item)
+		}
+		apiKey.mu.RUnlock()
+	}
+	return result
+}
+
+// TouchApiKey изменения сохраняются периодическим сохранением, а не очередью
+func TouchApiKey(apiKey *ApiKeyCache, ip string) {
+	now := time.Now()
+	apiKey.mu.Lock()
+	defer apiKey.mu.Unlock()
+	if now.Sub(apiKey.ApiKey.LastUsed) < apiKeyTouchInterval && apiKey.ApiKey.LastUsedIp == ip {
+		return
+	}
+	apiKey.ApiKey.LastUsed = now
+	apiKey.ApiKey.LastUsedIp = ip
+	if !apiKey.ToCreate {
+		apiKey.ToUpdate = true
+	}
+}
+
+func DeleteApiKey(requestId int64, apiKey *ApiKeyCache) {
+	apiKey.mu.Lock()
+	defer apiKey.mu.Unlock()
+	if !apiKey.Deleted {
+		apiKey.ToDelete = true
+	}
+	toSave <- SaveTask{Cache: apiKey, RequestId: requestId}
+}
+
+func deleteUserApiKeys(requestId int64, userId int64) {
+	apiKeysRWMutex.RLock()
+	list := make([]*ApiKeyCache, 0)
+	for _, apiKey := range apiKeys {
+		if apiKey.ApiKey.UserId == userId {
+			list = append(list, apiKey)
+		}
+	}
+	apiKeysRWMutex.RUnlock()
+	for _, apiKey := range list {
+		apiKey.mu.Lock()
+		if !apiKey.ToDelete && !apiKey.Deleted {
+			apiKey.ToDelete = true
+			toSave <- SaveTask{Cache: apiKey, RequestId: requestId}
+		}
+		apiKey.mu.Unlock()
+	}
+}
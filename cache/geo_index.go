@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"math"
+	"slices"
+)
+
+// geoCellSize размер ячейки сетки в градусах, примерно 11 км по широте
+const geoCellSize = 0.1
+
+type geoCellKey struct {
+	lat int32
+	lon int32
+}
+
+// geoIndex пространственный индекс объявлений - равномерная сетка по широте и долготе.
+// В каждой ячейке объявления отсортированы по id, как и в advs.
+// Не потокобезопасен, все обращения должны быть под advsRWMutex
+type geoIndex struct {
+	cells map[geoCellKey][]*AdvCache
+}
+
+func newGeoIndex() *geoIndex {
+	return &geoIndex{cells: make(map[geoCellKey][]*AdvCache)}
+}
+
+func geoCell(latitude, longitude float64) geoCellKey {
+	return geoCellKey{
+		lat: int32(math.Floor(latitude / geoCellSize)),
+		lon: int32(math.Floor(longitude / geoCellSize)),
+	}
+}
+
+func compareAdvIds(a, b *AdvCache) int {
+	if a.CurrentAdv.Id < b.CurrentAdv.Id {
+		return -1
+	}
+	if a.CurrentAdv.Id > b.CurrentAdv.Id {
+		return 1
+	}
+	return 0
+}
+
+func (g *geoIndex) add(adv *AdvCache) {
+	key := geoCell(adv.CurrentAdv.Latitude, adv.CurrentAdv.Longitude)
+	cell := g.cells[key]
+	//новые объявления почти всегда имеют самый большой id
+	if len(cell) == 0 || cell[len(cell)-1].CurrentAdv.Id < adv.CurrentAdv.Id {
+		g.cells[key] = append(cell, adv)
+		return
+	}
+	i, found := slices.BinarySearchFunc(cell, adv, compareAdvIds)
+	if found {
+		return
+	}
+	g.cells[key] = slices.Insert(cell, i, adv)
+}
+
+// remove удаляет объявление из ячейки, в которой оно лежит по координатам latitude, longitude
+func (g *geoIndex) remove(adv *AdvCache, latitude, longitude float64) {
+	key := geoCell(latitude, longitude)
+	cell := g.cells[key]
+	i, found := slices.BinarySearchFunc(cell, adv, compareAdvIds)
+	if !found {
+		return
+	}
+	cell = slices.Delete(cell, i, i+1)
+	if len(cell) == 0 {
+		delete(g.cells, key)
+	} else {
+		g.cells[key] = cell
+	}
+}
+
+func (g *geoIndex) move(adv *AdvCache, oldLatitude, oldLongitude float64) {
+	if geoCell(oldLatitude, oldLongitude) == geoCell(adv.CurrentAdv.Latitude, adv.CurrentAdv.Longitude) {
+		return
+	}
+	g.remove(adv, oldLatitude, oldLongitude)
+	g.add(adv)
+}
+
+// query возвращает отсортированные по id объявления из ячеек, пересекающих прямоугольник.
+// Объявления возвращаются с запасом, точную проверку координат делает вызывающий.
+// Если minLongitude > maxLongitude, прямоугольник пересекает 180-й меридиан.
+// Если кандидатов больше чем maxCount, возвращает false - полный перебор будет не дороже
+func (g *geoIndex) query(minLatitude, maxLatitude, minLongitude, maxLongitude float64, maxCount int) ([]*AdvCache, bool) {
+	cells := make([][]*AdvCache, 0, 16)
+	var count int
+	if minLongitude <= maxLongitude {
+		cells, count = g.collectCells(cells, count, minLatitude, maxLatitude, minLongitude, maxLongitude)
+	} else {
+		cells, count = g.collectCells(cells, count, minLatitude, maxLatitude, minLongitude, 180)
+		cells, count = g.collectCells(cells, count, minLatitude, maxLatitude, -180, maxLongitude)
+	}
+	if count > maxCount {
+		return nil, false
+	}
+	result := make([]*AdvCache, 0, count)
+	for _, cell := range cells {
+		result = append(result, cell...)
+	}
+	if len(cells) > 1 {
+		slices.SortFunc(result, compareAdvIds)
+	}
+	return result, true
+}
+
+func (g *geoIndex) collectCells(cells [][]*AdvCache, count int, minLatitude, maxLatitude, minLongitude, maxLongitude float64) ([][]*AdvCache, int) {
+	from := geoCell(minLatitude, minLongitude)
+	to := geoCell(maxLatitude, maxLongitude)
+	if from.lat > to.lat || from.lon > to.lon {
+		return cells, count
+	}
+	gridSize := (int64(to.lat) - int64(from.lat) + 1) * (int64(to.lon) - int64(from.lon) + 1)
+	if gridSize > int64(len(g.cells)) {
+		for key, cell := range g.cells {
+			if key.lat >= from.lat && key.lat <= to.lat && key.lon >= from.lon && key.lon <= to.lon {
+				cells = append(cells, cell)
+				count += len(cell)
+			}
+		}
+		return cells, count
+	}
+	for lat := from.lat; lat <= to.lat; lat++ {
+		for lon := from.lon; lon <= to.lon; lon++ {
+			if cell, ok := g.cells[geoCellKey{lat: lat, lon: lon}]; ok {
+				cells = append(cells, cell)
+				count += len(cell)
+			}
+		}
+	}
+	return cells, count
+}
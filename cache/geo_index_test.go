@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"math"
+	"math/rand"
+	"realty/models"
+	"testing"
+)
+
+const benchAdvsCount = 100_000
+
+var benchUser = &models.User{Id: 1, Email: "bench@example.com", Name: "bench"}
+
+// fillAdvs заполняет кэш объявлениями, разбросанными вокруг нескольких городов
+func fillAdvs(count int) {
+	centers := [][2]float64{{55.75, 37.62}, {59.94, 30.31}, {41.01, 28.97}, {40.41, 49.87}, {43.24, 76.89}}
+	random := rand.New(rand.NewSource(1))
+	advs = make([]*AdvCache, 0, count)
+	advsGeoIndex = newGeoIndex()
+	for i := range count {
+		center := centers[i%len(centers)]
+		adv := &AdvCache{
+			CurrentAdv: models.Adv{
+				Id:          1720060451151465000 + int64(i),
+				User:        benchUser,
+				Approved:    true,
+				DollarPrice: random.Int63n(1_000_000),
+				Latitude:    center[0] + random.NormFloat64()*0.5,
+				Longitude:   center[1] + random.NormFloat64()*0.5,
+			},
+			Watches: &WatchesCache{},
+		}
+		advs = append(advs, adv)
+		advsGeoIndex.add(adv)
+	}
+}
+
+func linearBbox(minLatitude, maxLatitude, minLongitude, maxLongitude float64) []int64 {
+	result := make([]int64, 0)
+	for _, adv := range advs {
+		a := &adv.CurrentAdv
+		if a.Latitude > minLatitude && a.Latitude < maxLatitude && a.Longitude > minLongitude && a.Longitude < maxLongitude {
+			result = append(result, a.Id)
+		}
+	}
+	return result
+}
+
+func TestGeoIndexQuery(t *testing.T) {
+	fillAdvs(20_000)
+	boxes := [][4]float64{
+		{55.6, 55.9, 37.4, 37.8},
+		{55.75, 55.751, 37.62, 37.621},
+		{40, 60, 25, 50},
+		{-10, 10, -10, 10},
+	}
+	for _, box := range boxes {
+		expected := linearBbox(box[0], box[1], box[2], box[3])
+		candidates, ok := advsGeoIndex.query(box[0], box[1], box[2], box[3], math.MaxInt)
+		if !ok {
+			t.Fatalf("%v: query refused", box)
+		}
+		got := make([]int64, 0, len(expected))
+		for i, adv := range candidates {
+			if i > 0 && candidates[i-1].CurrentAdv.Id >= adv.CurrentAdv.Id {
+				t.Fatalf("%v: candidates are not sorted by id", box)
+			}
+			a := &adv.CurrentAdv
+			if a.Latitude > box[0] && a.Latitude < box[1] && a.Longitude > box[2] && a.Longitude < box[3] {
+				got = append(got, a.Id)
+			}
+		}
+		if len(got) != len(expected) {
+			t.Fatalf("%v: got %d advs, want %d", box, len(got), len(expected))
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("%v: got id %d at %d, want %d", box, got[i], i, expected[i])
+			}
+		}
+	}
+
+	adv := advs[0]
+	oldLatitude, oldLongitude := adv.CurrentAdv.Latitude, adv.CurrentAdv.Longitude
+	adv.CurrentAdv.Latitude, adv.CurrentAdv.Longitude = -45.5, -120.5
+	advsGeoIndex.move(adv, oldLatitude, oldLongitude)
+	candidates, _ := advsGeoIndex.query(-46, -45, -121, -120, math.MaxInt)
+	if len(candidates) != 1 || candidates[0] != adv {
+		t.Fatalf("moved adv not found: %v", candidates)
+	}
+	advsGeoIndex.remove(adv, adv.CurrentAdv.Latitude, adv.CurrentAdv.Longitude)
+	candidates, _ = advsGeoIndex.query(-46, -45, -121, -120, math.MaxInt)
+	if len(candidates) != 0 {
+		t.Fatalf("removed adv found: %v", candidates)
+	}
+}
+
+func BenchmarkGeoIndexQuery(b *testing.B) {
+	fillAdvs(benchAdvsCount)
+	b.ResetTimer()
+	for range b.N {
+		advsGeoIndex.query(55.70, 55.80, 37.55, 37.70, benchAdvsCount/2)
+	}
+}
+
+func BenchmarkFindAdvsBbox(b *testing.B) {
+	fillAdvs(benchAdvsCount)
+	b.ResetTimer()
+	for range b.N {
+		FindAdvs(&AdvsFilter{MaxDollarPrice: math.MaxInt64, MinLongitude: 37.55, MaxLongitude: 37.70, MinLatitude: 55.70, MaxLatitude: 55.80, FirstNew: true}, nil, 0, 20)
+	}
+}
+
+func BenchmarkFindAdvsWorld(b *testing.B) {
+	fillAdvs(benchAdvsCount)
+	b.ResetTimer()
+	for range b.N {
+		FindAdvs(&AdvsFilter{MaxDollarPrice: math.MaxInt64, MinLongitude: -180, MaxLongitude: 180, MinLatitude: -90, MaxLatitude: 90, FirstNew: true}, nil, 0, 20)
+	}
+}
+
+func BenchmarkFindAdvsRadius(b *testing.B) {
+	fillAdvs(benchAdvsCount)
+	b.ResetTimer()
+	for range b.N {
+		FindAdvs(&AdvsFilter{MaxDollarPrice: math.MaxInt64, MinLongitude: -180, MaxLongitude: 180, MinLatitude: -90, MaxLatitude: 90,
+			Latitude: 55.75, Longitude: 37.62, RadiusKm: 5, Sort: "distance"}, nil, 0, 20)
+	}
+}
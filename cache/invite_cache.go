@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"realty/db"
+	"realty/models"
+	"sync"
+	"time"
+)
+
+type InviteCache struct {
+	Invite   models.Invite
+	ToCreate bool
+	ToUpdate bool
+	mu       sync.RWMutex
+}
+
+func (invite *InviteCache) Save() error {
+	invite.mu.Lock()
+	defer invite.mu.Unlock()
+	if invite.ToCreate {
+		err := db.CreateInvite(&invite.Invite)
+		if err != nil {
+			return err
+		}
+		invite.ToCreate = false
+		invite.ToUpdate = false
+	}
+	if invite.ToUpdate {
+		err := db.UpdateInvite(&invite.Invite)
+		if err != nil {
+			return err
+		}
+		invite.ToUpdate = false
+	}
+	return nil
+}
+
+// updateUsed вызывается под invite.mu после изменения Uses или Revoked
+func (invite *InviteCache) updateUsed() {
+	invite.Invite.Used = invite.Invite.Revoked || (invite.Invite.MaxUses > 0 && invite.Invite.Uses >= invite.Invite.MaxUses)
+}
+
+// isExpired вызывается под invite.mu
+func (invite *InviteCache) isExpired(now time.Time) bool {
+	return !invite.Invite.Expires.IsZero() && !now.Before(invite.Invite.Expires)
+}
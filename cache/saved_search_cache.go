@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"encoding/json"
+	"realty/db"
+	"realty/dto"
+	"realty/models"
+	"sync"
+)
+
+type SavedSearchCache struct {
+	SavedSearch models.SavedSearch
+	Request     dto.GetAdvListRequest //разобранный SavedSearch.Filter
+	filter      *AdvsFilter           //цены в долларах пересчитываются при каждой проверке
+	ToCreate    bool
+	ToUpdate    bool
+	ToDelete    bool
+	Deleted     bool
+	mu          sync.RWMutex
+}
+
+func newSavedSearchCache(search *models.SavedSearch) (*SavedSearchCache, error) {
+	searchCache := &SavedSearchCache{SavedSearch: *search}
+	if err := json.Unmarshal([]byte(search.Filter), &searchCache.Request); err != nil {
+		return nil, err
+	}
+	filter, err := NewAdvsFilter(&searchCache.Request)
+	if err != nil {
+		return nil, err
+	}
+	searchCache.filter = filter
+	return searchCache, nil
+}
+
+func (search *SavedSearchCache) Save() error {
+	search.mu.Lock()
+	defer search.mu.Unlock()
+	if search.Deleted {
+		return nil
+	}
+	if search.ToDelete {
+		if !search.ToCreate {
+			err := db.DeleteSavedSearch(search.SavedSearch.Id)
+			if err != nil {
+				return err
+			}
+		}
+		search.Deleted = true
+		search.ToDelete = false
+		search.ToCreate = false
+		search.ToUpdate = false
+	}
+	if search.ToCreate {
+		err := db.CreateSavedSearch(&search.SavedSearch)
+		if err != nil {
+			return err
+		}
+		search.ToCreate = false
+		search.ToUpdate = false
+	}
+	if search.ToUpdate {
+		err := db.UpdateSavedSearch(&search.SavedSearch)
+		if err != nil {
+			return err
+		}
+		search.ToUpdate = false
+	}
+	return nil
+}
+
+// match проверяет новое объявление на соответствие сохраненному поиску
+func (search *SavedSearchCache) match(advCache *AdvCache, adv *models.Adv) bool {
+	search.mu.RLock()
+	if search.ToDelete || search.Deleted || search.filter == nil {
+		search.mu.RUnlock()
+		return false
+	}
+	filter := *search.filter
+	filter.setDollarPrices(&search.Request)
+	search.mu.RUnlock()
+	if _, ok := filter.match(adv); !ok {
+		return false
+	}
+	if filter.Query == "" {
+		return true
+	}
+	advsRWMutex.RLock()
+	defer advsRWMutex.RUnlock()
+	return advsTextIndex.contains(advCache, filter.Query)
+}
+
+func (search *SavedSearchCache) ResponseItem() *dto.SavedSearchResponseItem {
+	search.mu.RLock()
+	defer search.mu.RUnlock()
+	request := search.Request
+	return &dto.SavedSearchResponseItem{
+		Id:      search.SavedSearch.Id,
+		Updated: search.SavedSearch.Updated,
+		Name:    search.SavedSearch.Name,
+		Filter:  &request,
+	}
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"realty/db"
+	"realty/models"
+	"sync"
+)
+
+type SessionCache struct {
+	Session  models.Session
+	ToCreate bool
+	ToUpdate bool
+	ToDelete bool
+	Deleted  bool
+	mu       sync.RWMutex
+}
+
+func (session *SessionCache) Save() error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.Deleted {
+		return nil
+	}
+	if session.ToDelete {
+		if !session.ToCreate {
+			err := db.DeleteSession(session.Session.Id)
+			if err != nil {
+				return err
+			}
+		}
+		session.Deleted = true
+		session.ToDelete = false
+		session.ToCreate = false
+		session.ToUpdate = false
+	}
+	if session.ToCreate {
+		err := db.CreateSession(&session.Session)
+		if err != nil {
+			return err
+		}
+		session.ToCreate = false
+		session.ToUpdate = false
+	}
+	if session.ToUpdate {
+		err := db.UpdateSession(&session.Session)
+		if err != nil {
+			return err
+		}
+		session.ToUpdate = false
+	}
+	return nil
+}
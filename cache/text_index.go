@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"math"
+	"realty/fulltext"
+)
+
+// веса полей объявления при подсчете релевантности
+const (
+	textWeightTitle       = 3
+	textWeightCity        = 2
+	textWeightAddress     = 2
+	textWeightDescription = 1
+)
+
+// параметры ранжирования BM25
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type textDoc struct {
+	terms  map[string]float64
+	length float64
+}
+
+// textIndex инвертированный индекс по Title, Description, City и Address.
+// Не потокобезопасен, все обращения должны быть под advsRWMutex
+type textIndex struct {
+	postings    map[string]map[*AdvCache]float64
+	docs        map[*AdvCache]*textDoc
+	totalLength float64
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{
+		postings: make(map[string]map[*AdvCache]float64),
+		docs:     make(map[*AdvCache]*textDoc),
+	}
+}
+
+func newTextDoc(adv *AdvCache) *textDoc {
+	doc := &textDoc{terms: make(map[string]float64)}
+	fields := []struct {
+		text   string
+		weight float64
+	}{
+		{adv.CurrentAdv.Title, textWeightTitle},
+		{adv.CurrentAdv.City, textWeightCity},
+		{adv.CurrentAdv.Address, textWeightAddress},
+		{adv.CurrentAdv.Description, textWeightDescription},
+	}
+	for _, field := range fields {
+		for _, term := range fulltext.Tokenize(field.text) {
+			doc.terms[term] += field.weight
+			doc.length += field.weight
+		}
+	}
+	return doc
+}
+
+// add индексирует объявление, если оно уже было в индексе - переиндексирует
+func (t *textIndex) add(adv *AdvCache) {
+	t.remove(adv)
+	doc := newTextDoc(adv)
+	for term, frequency := range doc.terms {
+		posting, ok := t.postings[term]
+		if !ok {
+			posting = make(map[*AdvCache]float64)
+			t.postings[term] = posting
+		}
+		posting[adv] = frequency
+	}
+	t.docs[adv] = doc
+	t.totalLength += doc.length
+}
+
+func (t *textIndex) remove(adv *AdvCache) {
+	doc, ok := t.docs[adv]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		posting := t.postings[term]
+		delete(posting, adv)
+		if len(posting) == 0 {
+			delete(t.postings, term)
+		}
+	}
+	delete(t.docs, adv)
+	t.totalLength -= doc.length
+}
+
+// search возвращает объявления, содержащие все слова запроса, с их релевантностью по BM25
+func (t *textIndex) search(query string) map[*AdvCache]float64 {
+	terms := fulltext.Tokenize(query)
+	if len(terms) == 0 || len(t.docs) == 0 {
+		return map[*AdvCache]float64{}
+	}
+	//начинаем с самого редкого слова, чтобы пересечение было минимальным
+	rarest := t.postings[terms[0]]
+	for _, term := range terms[1:] {
+		if posting := t.postings[term]; len(posting) < len(rarest) {
+			rarest = posting
+		}
+	}
+	count := float64(len(t.docs))
+	averageLength := t.totalLength / count
+	result := make(map[*AdvCache]float64, len(rarest))
+	for adv := range rarest {
+		doc := t.docs[adv]
+		var score float64
+		for _, term := range terms {
+			frequency, ok := doc.terms[term]
+			if !ok {
+				score = -1
+				break
+			}
+			documents := float64(len(t.postings[term]))
+			idf := math.Log(1 + (count-documents+0.5)/(documents+0.5))
+			score += idf * frequency * (bm25K1 + 1) / (frequency + bm25K1*(1-bm25B+bm25B*doc.length/averageLength))
+		}
+		if score >= 0 {
+			result[adv] = score
+		}
+	}
+	return result
+}
+
+// contains проверяет, что объявление содержит все слова запроса
+func (t *textIndex) contains(adv *AdvCache, query string) bool {
+	doc, ok := t.docs[adv]
+	if !ok {
+		return false
+	}
+	for _, term := range fulltext.Tokenize(query) {
+		if _, ok = doc.terms[term]; !ok {
+			return false
+		}
+	}
+	return true
+}
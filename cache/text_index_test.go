@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"realty/models"
+	"testing"
+)
+
+func newTextAdv(id int64, title, description, city, address string) *AdvCache {
+	return &AdvCache{CurrentAdv: models.Adv{
+		Id:          id,
+		Title:       title,
+		Description: description,
+		City:        city,
+		Address:     address,
+	}}
+}
+
+func TestTextIndexSearch(t *testing.T) {
+	index := newTextIndex()
+	inTitle := newTextAdv(1, "Квартира с видом на море", "Светлая, после ремонта", "Сочи", "ул. Морская, 5")
+	inDescription := newTextAdv(2, "Дом у леса", "Рядом море и квартиры соседей", "Анапа", "ул. Лесная, 1")
+	english := newTextAdv(3, "Sunny apartments", "Two bedrooms, parking", "London", "Baker street, 221")
+	for _, adv := range []*AdvCache{inTitle, inDescription, english} {
+		index.add(adv)
+	}
+
+	result := index.search("квартиры")
+	if len(result) != 2 {
+		t.Fatalf("got %d advs, want 2", len(result))
+	}
+	if result[inTitle] <= result[inDescription] {
+		t.Errorf("title match %v must rank higher than description match %v", result[inTitle], result[inDescription])
+	}
+	if result = index.search("квартира сочи"); len(result) != 1 || result[inTitle] == 0 {
+		t.Errorf("all query words must match: %v", result)
+	}
+	if result = index.search("Apartment bedroom"); len(result) != 1 || result[english] == 0 {
+		t.Errorf("english stemming: %v", result)
+	}
+	if result = index.search("221"); len(result) != 1 {
+		t.Errorf("numbers must be indexed: %v", result)
+	}
+
+	inTitle.CurrentAdv.Title = "Студия"
+	index.add(inTitle)
+	if result = index.search("квартира"); len(result) != 1 || result[inDescription] == 0 {
+		t.Errorf("reindexed adv found by old title: %v", result)
+	}
+	index.remove(inDescription)
+	if result = index.search("квартира"); len(result) != 0 {
+		t.Errorf("removed adv found: %v", result)
+	}
+	if len(index.postings["лес"]) != 0 {
+		t.Errorf("postings of removed adv left: %v", index.postings["лес"])
+	}
+}
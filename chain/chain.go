@@ -32,10 +32,15 @@ func Next() Result {
 // RequestData
 // можно расширять для передачи данных по цепочке обработчиков
 type RequestData struct {
-	User      *cache.UserCache
-	Adv       *cache.AdvCache
-	RequestId int64 //также используется в качестве времени старта запроса в ns
-	chain     *Chain
+	User          *cache.UserCache
+	Adv           *cache.AdvCache
+	Search        *cache.SavedSearchCache
+	Session       *cache.SessionCache //текущая сессия, токен без сессии привязывается к новой в Auth
+	TargetSession *cache.SessionCache //сессия из пути запроса, не текущая
+	ApiKey        *cache.ApiKeyCache  //не nil, если запрос авторизован API ключом, а не cookie
+	TargetApiKey  *cache.ApiKeyCache  //ключ из пути запроса
+	RequestId     int64               //также используется в качестве времени старта запроса в ns
+	chain         *Chain
 }
 
 func (rd *RequestData) Logger() *slog.Logger {
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"encoding/base64"
 	"log"
 	"log/slog"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type conf struct {
@@ -14,6 +17,31 @@ type conf struct {
 	dataUsersPath      string
 	dataAdvsPath       string
 	dataWatchesPath    string
+	currencyRatesPath  string
+	currencyProvider   string
+	currencyCheckSec   int
+	currencyRefreshSec int
+	maxPageSize        int
+	notifySink         string
+	notifyOutboxPath   string
+	passwordPepper     string
+	passwordIterations int
+	inviteOnly         bool
+	authKeys           map[uint8][]byte
+	authKeyId          uint8
+	authLegacyUntil    time.Time
+	resetEmailLimit    int
+	resetIpLimit       int
+	loginAccountTries  int
+	loginIpTries       int
+	loginMaxLockoutSec int
+	mailer             string
+	mailOutboxPath     string
+	mailFrom           string
+	smtpHost           string
+	smtpPort           string
+	smtpUsername       string
+	smtpPassword       string
 	availableCountries []string
 	language           string
 	domain             string
@@ -26,6 +54,12 @@ type conf struct {
 
 var c conf
 
+// startTime момент запуска процесса, от него отсчитывается окно приема старых токенов
+var startTime = time.Now()
+
+// legacyTokenWindow по умолчанию старые токены принимаются не дольше срока жизни cookie после запуска
+const legacyTokenWindow = 3 * 24 * time.Hour
+
 func Initialize() {
 	c = conf{
 		staticFilesPath:    "./static/",
@@ -34,10 +68,23 @@ func Initialize() {
 		availableCountries: make([]string, 0),
 		domain:             "localhost",
 		adminId:            35456456,
+		currencyCheckSec:   60,
+		maxPageSize:        100,
+		passwordIterations: 600_000,
+		resetEmailLimit:    3,
+		resetIpLimit:       20,
+		loginAccountTries:  5,
+		loginIpTries:       20,
+		loginMaxLockoutSec: 3600,
+		notifySink:         "file",
+		mailer:             "file",
+		mailFrom:           "noreply@localhost",
+		smtpPort:           "587",
 		logLevel:           slog.LevelDebug,
 		logSQL:             true,
 		logResponse:        true,
 		logInput:           true,
+		authLegacyUntil:    startTime.Add(legacyTokenWindow),
 	}
 	if v, ok := os.LookupEnv("STATIC_FILES_PATH"); ok {
 		c.staticFilesPath = v
@@ -45,6 +92,131 @@ func Initialize() {
 	if v, ok := os.LookupEnv("DATA_DIR"); ok {
 		c.dataDir = v
 	}
+	if v, ok := os.LookupEnv("CURRENCY_RATES_FILEPATH"); ok {
+		c.currencyRatesPath = v
+	}
+	if v, ok := os.LookupEnv("CURRENCY_RATES_PROVIDER"); ok {
+		c.currencyProvider = v
+	}
+	if v, ok := os.LookupEnv("CURRENCY_RATES_REFRESH_INTERVAL"); ok {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 0 {
+			log.Fatal("invalid CURRENCY_RATES_REFRESH_INTERVAL")
+		}
+		c.currencyRefreshSec = sec
+	}
+	if v, ok := os.LookupEnv("CURRENCY_RATES_CHECK_INTERVAL"); ok {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 0 {
+			log.Fatal("invalid CURRENCY_RATES_CHECK_INTERVAL")
+		}
+		c.currencyCheckSec = sec
+	}
+	if v, ok := os.LookupEnv("MAX_PAGE_SIZE"); ok {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			log.Fatal("invalid MAX_PAGE_SIZE")
+		}
+		c.maxPageSize = size
+	}
+	if v, ok := os.LookupEnv("NOTIFY_SINK"); ok && v != "" {
+		c.notifySink = v
+	}
+	if v, ok := os.LookupEnv("NOTIFY_OUTBOX_FILEPATH"); ok {
+		c.notifyOutboxPath = v
+	}
+	if v, ok := os.LookupEnv("PASSWORD_PEPPER"); ok {
+		c.passwordPepper = v
+	}
+	if v, ok := os.LookupEnv("PASSWORD_HASH_ITERATIONS"); ok {
+		iterations, err := strconv.Atoi(v)
+		if err != nil || iterations < 1 {
+			log.Fatal("invalid PASSWORD_HASH_ITERATIONS")
+		}
+		c.passwordIterations = iterations
+	}
+	if v, ok := os.LookupEnv("PASSWORD_RESET_EMAIL_LIMIT"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			log.Fatal("invalid PASSWORD_RESET_EMAIL_LIMIT")
+		}
+		c.resetEmailLimit = limit
+	}
+	if v, ok := os.LookupEnv("PASSWORD_RESET_IP_LIMIT"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			log.Fatal("invalid PASSWORD_RESET_IP_LIMIT")
+		}
+		c.resetIpLimit = limit
+	}
+	if v, ok := os.LookupEnv("LOGIN_ACCOUNT_MAX_ATTEMPTS"); ok {
+		tries, err := strconv.Atoi(v)
+		if err != nil || tries < 1 {
+			log.Fatal("invalid LOGIN_ACCOUNT_MAX_ATTEMPTS")
+		}
+		c.loginAccountTries = tries
+	}
+	if v, ok := os.LookupEnv("LOGIN_IP_MAX_ATTEMPTS"); ok {
+		tries, err := strconv.Atoi(v)
+		if err != nil || tries < 1 {
+			log.Fatal("invalid LOGIN_IP_MAX_ATTEMPTS")
+		}
+		c.loginIpTries = tries
+	}
+	if v, ok := os.LookupEnv("LOGIN_MAX_LOCKOUT"); ok {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 30 {
+			log.Fatal("invalid LOGIN_MAX_LOCKOUT")
+		}
+		c.loginMaxLockoutSec = sec
+	}
+	if v, ok := os.LookupEnv("AUTH_TOKEN_KEYS"); ok && v != "" {
+		c.authKeys = parseAuthTokenKeys(v)
+		for id := range c.authKeys {
+			c.authKeyId = max(c.authKeyId, id)
+		}
+	}
+	if v, ok := os.LookupEnv("AUTH_TOKEN_KEY_ID"); ok {
+		id, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || c.authKeys[uint8(id)] == nil {
+			log.Fatal("invalid AUTH_TOKEN_KEY_ID")
+		}
+		c.authKeyId = uint8(id)
+	}
+	if v, ok := os.LookupEnv("AUTH_TOKEN_LEGACY_UNTIL"); ok && v != "" {
+		until, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			log.Fatal("invalid AUTH_TOKEN_LEGACY_UNTIL")
+		}
+		c.authLegacyUntil = until
+	}
+	if v, ok := os.LookupEnv("INVITE_ONLY"); ok {
+		c.inviteOnly = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v, ok := os.LookupEnv("MAILER"); ok && v != "" {
+		c.mailer = v
+	}
+	if v, ok := os.LookupEnv("MAIL_OUTBOX_FILEPATH"); ok {
+		c.mailOutboxPath = v
+	}
+	if v, ok := os.LookupEnv("MAIL_FROM"); ok {
+		c.mailFrom = v
+	}
+	if v, ok := os.LookupEnv("SMTP_HOST"); ok {
+		c.smtpHost = v
+	}
+	if v, ok := os.LookupEnv("SMTP_PORT"); ok {
+		if _, err := strconv.Atoi(v); err != nil {
+			log.Fatal("invalid SMTP_PORT")
+		}
+		c.smtpPort = v
+	}
+	if v, ok := os.LookupEnv("SMTP_USERNAME"); ok {
+		c.smtpUsername = v
+	}
+	if v, ok := os.LookupEnv("SMTP_PASSWORD"); ok {
+		c.smtpPassword = v
+	}
 	if v, ok := os.LookupEnv("HTTP_SERVER_PORT"); ok {
 		c.httpServerPort = v
 	}
@@ -74,7 +246,7 @@ func Initialize() {
 	if v, ok := os.LookupEnv("LOG_INPUT"); ok {
 		c.logInput = strings.ToLower(v) == "true" || v == "1"
 	}
-	slog.Info("config", "STATIC_FILES_PATH", c.staticFilesPath, "DATA_DIR", c.dataDir, "HTTP_SERVER_PORT", c.httpServerPort, "DOMAIN", c.domain, "LOG_LEVEL", c.logLevel, "LOG_SQL", c.logSQL, "LOG_RESPONSE", c.logResponse, "LOG_INPUT", c.logInput)
+	slog.Info("config", "STATIC_FILES_PATH", c.staticFilesPath, "DATA_DIR", c.dataDir, "CURRENCY_RATES_PROVIDER", c.currencyProvider, "CURRENCY_RATES_FILEPATH", GetCurrencyRatesFilepath(), "CURRENCY_RATES_CHECK_INTERVAL", c.currencyCheckSec, "CURRENCY_RATES_REFRESH_INTERVAL", c.currencyRefreshSec, "MAX_PAGE_SIZE", c.maxPageSize, "NOTIFY_SINK", c.notifySink, "PASSWORD_HASH_ITERATIONS", c.passwordIterations, "PASSWORD_RESET_EMAIL_LIMIT", c.resetEmailLimit, "PASSWORD_RESET_IP_LIMIT", c.resetIpLimit, "LOGIN_ACCOUNT_MAX_ATTEMPTS", c.loginAccountTries, "LOGIN_IP_MAX_ATTEMPTS", c.loginIpTries, "LOGIN_MAX_LOCKOUT", c.loginMaxLockoutSec, "INVITE_ONLY", c.inviteOnly, "AUTH_TOKEN_KEY_ID", c.authKeyId, "AUTH_TOKEN_KEYS_COUNT", len(c.authKeys), "AUTH_TOKEN_LEGACY_UNTIL", c.authLegacyUntil, "MAILER", c.mailer, "MAIL_FROM", c.mailFrom, "SMTP_HOST", c.smtpHost, "SMTP_PORT", c.smtpPort, "HTTP_SERVER_PORT", c.httpServerPort, "DOMAIN", c.domain, "LOG_LEVEL", c.logLevel, "LOG_SQL", c.logSQL, "LOG_RESPONSE", c.logResponse, "LOG_INPUT", c.logInput)
 }
 
 func GetStaticFilesPath() string {
@@ -117,10 +289,152 @@ func GetDbWatchesPath() string {
 	return c.dataDir + "/watches.sqlite"
 }
 
+// GetCurrencyRatesFilepath путь к файлу или http(s) адрес, откуда провайдер берет курсы
 func GetCurrencyRatesFilepath() string {
+	if c.currencyRatesPath != "" {
+		return c.currencyRatesPath
+	}
 	return c.dataDir + "/currency.json"
 }
 
+// GetCurrencyRatesProvider формат источника курсов: json, ecb или csv
+func GetCurrencyRatesProvider() string {
+	return c.currencyProvider
+}
+
+func GetCurrencyRatesRefreshInterval() time.Duration {
+	return time.Duration(c.currencyRefreshSec) * time.Second
+}
+
+func GetCurrencyRatesCheckInterval() time.Duration {
+	return time.Duration(c.currencyCheckSec) * time.Second
+}
+
+// GetMaxPageSize максимальный limit для списков объявлений
+func GetMaxPageSize() int {
+	return c.maxPageSize
+}
+
+// GetNotifySink куда складываются уведомления о сохраненных поисках: file (по умолчанию) или memory для тестов
+func GetNotifySink() string {
+	return c.notifySink
+}
+
+func GetNotifyOutboxFilepath() string {
+	if c.notifyOutboxPath != "" {
+		return c.notifyOutboxPath
+	}
+	return c.dataDir + "/outbox.jsonl"
+}
+
+// GetPasswordPepper секрет, который подмешивается ко всем паролям и не хранится в БД
+func GetPasswordPepper() string {
+	return c.passwordPepper
+}
+
+// GetPasswordHashIterations стоимость хеширования паролей, хеши с меньшим числом итераций пересчитываются при входе
+func GetPasswordHashIterations() int {
+	return c.passwordIterations
+}
+
+// GetPasswordResetEmailLimit сколько писем для сброса пароля можно запросить на один email за час
+func GetPasswordResetEmailLimit() int {
+	return c.resetEmailLimit
+}
+
+// GetPasswordResetIpLimit сколько запросов на восстановление пароля можно сделать с одного IP за час
+func GetPasswordResetIpLimit() int {
+	return c.resetIpLimit
+}
+
+// GetLoginAccountMaxAttempts после стольких неудачных попыток подряд аккаунт временно блокируется
+func GetLoginAccountMaxAttempts() int {
+	return c.loginAccountTries
+}
+
+// GetLoginIpMaxAttempts после стольких неудачных попыток подряд IP временно блокируется
+func GetLoginIpMaxAttempts() int {
+	return c.loginIpTries
+}
+
+// GetLoginMaxLockout блокировка растет вдвое с каждой неудачей, но не дольше
+func GetLoginMaxLockout() time.Duration {
+	return time.Duration(c.loginMaxLockoutSec) * time.Second
+}
+
+// parseAuthTokenKeys ключи вида "1:base64,2:base64", ключ не короче 32 байт
+func parseAuthTokenKeys(v string) map[uint8][]byte {
+	keys := make(map[uint8][]byte)
+	for _, item := range strings.Split(v, ",") {
+		idStr, keyStr, found := strings.Cut(strings.TrimSpace(item), ":")
+		if !found {
+			log.Fatal("invalid AUTH_TOKEN_KEYS")
+		}
+		id, err := strconv.ParseUint(idStr, 10, 8)
+		if err != nil {
+			log.Fatal("invalid AUTH_TOKEN_KEYS")
+		}
+		key, err := base64.StdEncoding.DecodeString(keyStr)
+		if err != nil || len(key) < 32 {
+			log.Fatal("invalid AUTH_TOKEN_KEYS: key " + idStr + " must be base64 of at least 32 bytes")
+		}
+		keys[uint8(id)] = key
+	}
+	return keys
+}
+
+// GetAuthTokenKeys ключи подписи токенов авторизации по id, пустой - ключ генерируется при старте
+func GetAuthTokenKeys() map[uint8][]byte {
+	return c.authKeys
+}
+
+// GetAuthTokenKeyId ключ, которым подписываются новые токены, по умолчанию с наибольшим id
+func GetAuthTokenKeyId() uint8 {
+	return c.authKeyId
+}
+
+// GetAuthTokenLegacyUntil до какого момента принимаются старые токены SHA-1
+func GetAuthTokenLegacyUntil() time.Time {
+	return c.authLegacyUntil
+}
+
+// IsInviteOnly регистрация только по приглашению
+func IsInviteOnly() bool {
+	return c.inviteOnly
+}
+
+// GetMailer через что отправляются письма: file (по умолчанию), smtp или memory для тестов
+func GetMailer() string {
+	return c.mailer
+}
+
+func GetMailOutboxFilepath() string {
+	if c.mailOutboxPath != "" {
+		return c.mailOutboxPath
+	}
+	return c.dataDir + "/mail_outbox.jsonl"
+}
+
+func GetMailFrom() string {
+	return c.mailFrom
+}
+
+func GetSmtpHost() string {
+	return c.smtpHost
+}
+
+func GetSmtpAddr() string {
+	return c.smtpHost + ":" + c.smtpPort
+}
+
+func GetSmtpUsername() string {
+	return c.smtpUsername
+}
+
+func GetSmtpPassword() string {
+	return c.smtpPassword
+}
+
 func GetAvailableCountries() []string {
 	return c.availableCountries
 }
@@ -1,11 +1,352 @@
 package currency
 
-var g = `<option value="/m/0kz1h">Австралийский доллар</option><option value="/m/04bq4y">Азербайджанский манат</option><option value="/m/01n64b">Албанский лек</option><option value="/m/04wcz0">Алжирский динар</option><option value="/m/03c7mb">Ангольская кванза</option><option value="/m/024nzm">Аргентинское песо</option><option value="/m/033xr3">Армянский драм</option><option value="/m/08s1k3">Арубанский флорин</option><option value="/m/019vxc">Афганский афгани</option><option value="/m/01l6dm">Багамский доллар</option><option value="/m/02gsv3">Бангладешская така</option><option value="/m/05hy7p">Барбадосский доллар</option><option value="/m/04wd20">Бахрейнский динар</option><option value="/m/02bwg4">Белизский доллар</option><option value="/m/05c9_x">Белорусский рубль</option><option value="/m/04xb8t">Бермудский доллар</option><option value="/m/01nmfw">Болгарский лев</option><option value="/m/04tkg7">Боливийский боливиано</option><option value="/m/02nksv">Ботсванская пула</option><option value="/m/03385m">Бразильский реал</option><option value="/m/021x2r">Брунейский доллар</option><option value="/m/05jc3y">Бурундийский франк</option><option value="/m/02gt45">Бутанский нгултрум</option><option value="/m/01hfll">Венгерский форинт</option><option value="/m/02r4k">Восточнокарибский доллар</option><option value="/m/03ksl6">Вьетнамский донг</option><option value="/m/04xrp0">Гаитянский гурд</option><option value="/m/059mfk">Гайанский доллар</option><option value="/m/04wctd">Гамбийский даласи</option><option value="/m/01s733">Ганский седи</option><option value="/m/01crby">Гватемальский кетсаль</option><option value="/m/05yxld">Гвинейский франк</option><option value="/m/04krzv">Гондурасская лемпира</option><option value="/m/02nb4kq">Гонконгский доллар</option><option value="/m/03nh77">Грузинский лари</option><option value="/m/01j9nc">Датская крона</option><option value="/m/02zl8q">Дирхам ОАЭ</option><option value="/m/04xbgl">Доллар Каймановых Островов</option><option value="/m/01y8jz">Доллар Намибии</option><option value="/m/09nqf">Доллар США</option><option value="/m/05jpx1">Доллар Соломоновых Островов</option><option value="/m/04xcgz">Доллар Тринидада и Тобаго</option><option value="/m/04xbp1">Доллар Фиджи</option><option value="/m/04lt7_">Доминиканское песо</option><option selected="1" value="/m/02l6h">Евро</option><option value="/m/04phzg">Египетский фунт</option><option value="/m/0hn4_">Жэньминьби</option><option value="/m/0fr4f">Замбийская квача</option><option value="/m/025sw2q">Западноафриканский франк КФА</option><option value="/m/02gsvk">Индийская рупия</option><option value="/m/0203sy">Индонезийская рупия</option><option value="/m/028qvh">Иорданский динар</option><option value="/m/01kpb3">Иракский динар</option><option value="/m/034n11">Иранский риал</option><option value="/m/012nk9">Исландская крона</option><option value="/m/05yxwz">Йеменский риал</option><option value="/m/01km4c">Казахстанский тенге</option><option value="/m/03_m0v">Камбоджийский риель</option><option value="/m/0ptk_">Канадский доллар</option><option value="/m/05lf7w">Катарский риал</option><option value="/m/05yxpb">Кенийский шиллинг</option><option value="/m/04xblj">Кина</option><option value="/m/034sw6">Колумбийское песо</option><option value="/m/02lnq3">Конвертируемая марка Боснии и Герцеговины</option><option value="/m/04h1d6">Конголезский франк</option><option value="/m/04wccn">Коста-риканский колон</option><option value="/m/049p2z">Кубинский песо</option><option value="/m/01j2v3">Кувейтский динар</option><option value="/m/04k5c6">Кыргызский сом</option><option value="/m/04k4j1">Лаосский кип</option><option value="/m/05g359">Либерийский доллар</option><option value="/m/025tsrc">Ливанский фунт</option><option value="/m/024xpm">Ливийский динар</option><option value="/m/04xm1m">Лоти Лесото</option><option value="/m/02scxb">Маврикийская рупия</option><option value="/m/023c2n">Мавританская угия</option><option value="/m/022dkb">Македонский денар</option><option value="/m/0fr4w">Малавийская квача</option><option value="/m/04hx_7">Малагасийский ариари</option><option value="/m/01_c9q">Малайзийский ринггит</option><option value="/m/02gsxf">Мальдивская руфия</option><option value="/m/06qsj1">Марокканский дирхам</option><option value="/m/012ts8">Мексиканское песо</option><option value="/m/05yxqw">Мозамбикский метикал</option><option value="/m/02z6sq">Молдавский лей</option><option value="/m/04r7gc">Мьянманский кьят</option><option value="/m/02f4f4">Непальская рупия</option><option value="/m/018cg3">Нигерийская найра</option><option value="/m/08njbf">Нидерландский антильский гульден</option><option value="/m/02fvtk">Никарагуанская кордоба</option><option value="/m/015f1d">Новозеландский доллар</option><option value="/m/01jcw8">Новый израильский шекель</option><option value="/m/01t0lt">Новый тайваньский доллар</option><option value="/m/0h5dw">Норвежская крона</option><option value="/m/04_66x">Оманский риал</option><option value="/m/02svsf">Пакистанская рупия</option><option value="/m/0200cp">Панамский бальбоа</option><option value="/m/04w7dd">Парагвайский гуарани</option><option value="/m/02fbly">Патака Макао</option><option value="/m/0b423v">Перуанский соль</option><option value="/m/0glfp">Польский злотый</option><option value="/m/01hy_q">Российский рубль</option><option value="/m/02zsyq">Румынский лей</option><option value="/m/04wcnp">Сальвадорский колон</option><option value="/m/02d1cm">Саудовский риял</option><option value="/m/02pmxj">Свазилендский лилангени</option><option value="/m/01lvjz">Сейшельская рупия</option><option value="/m/02kz6b">Сербский динар</option><option value="/m/02f32g">Сингапурский доллар</option><option value="/m/05yxgz">Сомалийский шиллинг</option><option value="/g/11bc5b_s84">Суверенный боливар</option><option value="/m/08d4zw">Суданский фунт</option><option value="/m/02dl9v">Суринамский доллар</option><option value="/m/02vqvn">Сьерра-леонский леоне</option><option value="/m/0370bp">Таджикский сомони</option><option value="/m/0mcb5">Тайский бат</option><option value="/m/04s1qh">Танзанийский шиллинг</option><option value="/m/040qbv">Тонганская паанга</option><option value="/m/04z4ml">Тунисский динар</option><option value="/m/04dq0w">Турецкая лира</option><option value="/m/0425kx">Туркменский манат</option><option value="/m/04b6vh">Угандийский шиллинг</option><option value="/m/04l7bl">Узбекский сум</option><option value="/m/035qkb">Украинская гривна</option><option value="/m/04wblx">Уругвайское песо</option><option value="/m/0775_k">Условная расчетная единица Чили</option><option value="/m/01h5bw">Филиппинское песо</option><option value="/m/05yxn7">Франк Джибути</option><option value="/m/01qyjx">Франк КФП</option><option value="/m/05yxq3">Франк Комор</option><option value="/m/05yxkm">Франк Руанды</option><option value="/m/01nv4h">Фунт стерлингов</option><option value="/m/025sw2b">Центральноафриканский франк КФА</option><option value="/m/04rpc3">Чешская крона</option><option value="/m/0172zs">Чилийское песо</option><option value="/m/0485n">Шведская крона</option><option value="/m/01_h4b">Швейцарский франк</option><option value="/m/02gsxw">Шри-ланкийская рупия</option><option value="/m/06plyy">Эскудо Кабо-Верде</option><option value="/m/02_mbk">Эфиопский быр</option><option value="/m/01rmbs">Южноафриканский рэнд</option><option value="/m/01rn1k">Южнокорейская вона</option><option value="/m/04xc2m">Ямайский доллар</option><option value="/m/088n7">Японская иена</option><option value="/g/11c54p47s9">китайский офшорный юань</option>`
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"os"
+	"realty/config"
+	"realty/models"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/dustin/go-humanize"
+)
+
+// iso4217 действующие коды валют ISO 4217
+var iso4217 = map[string]string{
+	"AED": "Дирхам ОАЭ",
+	"AFN": "Афганский афгани",
+	"ALL": "Албанский лек",
+	"AMD": "Армянский драм",
+	"ANG": "Нидерландский антильский гульден",
+	"AOA": "Ангольская кванза",
+	"ARS": "Аргентинское песо",
+	"AUD": "Австралийский доллар",
+	"AWG": "Арубанский флорин",
+	"AZN": "Азербайджанский манат",
+	"BAM": "Конвертируемая марка Боснии и Герцеговины",
+	"BBD": "Барбадосский доллар",
+	"BDT": "Бангладешская така",
+	"BGN": "Болгарский лев",
+	"BHD": "Бахрейнский динар",
+	"BIF": "Бурундийский франк",
+	"BMD": "Бермудский доллар",
+	"BND": "Брунейский доллар",
+	"BOB": "Боливийский боливиано",
+	"BRL": "Бразильский реал",
+	"BSD": "Багамский доллар",
+	"BTN": "Бутанский нгултрум",
+	"BWP": "Ботсванская пула",
+	"BYN": "Белорусский рубль",
+	"BZD": "Белизский доллар",
+	"CAD": "Канадский доллар",
+	"CDF": "Конголезский франк",
+	"CHF": "Швейцарский франк",
+	"CLF": "Условная расчетная единица Чили",
+	"CLP": "Чилийское песо",
+	"CNH": "Китайский офшорный юань",
+	"CNY": "Жэньминьби",
+	"COP": "Колумбийское песо",
+	"CRC": "Коста-риканский колон",
+	"CUP": "Кубинский песо",
+	"CVE": "Эскудо Кабо-Верде",
+	"CZK": "Чешская крона",
+	"DJF": "Франк Джибути",
+	"DKK": "Датская крона",
+	"DOP": "Доминиканское песо",
+	"DZD": "Алжирский динар",
+	"EGP": "Египетский фунт",
+	"ERN": "Эритрейская накфа",
+	"ETB": "Эфиопский быр",
+	"EUR": "Евро",
+	"FJD": "Доллар Фиджи",
+	"GBP": "Фунт стерлингов",
+	"GEL": "Грузинский лари",
+	"GHS": "Ганский седи",
+	"GMD": "Гамбийский даласи",
+	"GNF": "Гвинейский франк",
+	"GTQ": "Гватемальский кетсаль",
+	"GYD": "Гайанский доллар",
+	"HKD": "Гонконгский доллар",
+	"HNL": "Гондурасская лемпира",
+	"HTG": "Гаитянский гурд",
+	"HUF": "Венгерский форинт",
+	"IDR": "Индонезийская рупия",
+	"ILS": "Новый израильский шекель",
+	"INR": "Индийская рупия",
+	"IQD": "Иракский динар",
+	"IRR": "Иранский риал",
+	"ISK": "Исландская крона",
+	"JMD": "Ямайский доллар",
+	"JOD": "Иорданский динар",
+	"JPY": "Японская иена",
+	"KES": "Кенийский шиллинг",
+	"KGS": "Кыргызский сом",
+	"KHR": "Камбоджийский риель",
+	"KMF": "Франк Комор",
+	"KRW": "Южнокорейская вона",
+	"KWD": "Кувейтский динар",
+	"KYD": "Доллар Каймановых Островов",
+	"KZT": "Казахстанский тенге",
+	"LAK": "Лаосский кип",
+	"LBP": "Ливанский фунт",
+	"LKR": "Шри-ланкийская рупия",
+	"LRD": "Либерийский доллар",
+	"LSL": "Лоти Лесото",
+	"LYD": "Ливийский динар",
+	"MAD": "Марокканский дирхам",
+	"MDL": "Молдавский лей",
+	"MGA": "Малагасийский ариари",
+	"MKD": "Македонский денар",
+	"MMK": "Мьянманский кьят",
+	"MNT": "Монгольский тугрик",
+	"MOP": "Патака Макао",
+	"MRU": "Мавританская угия",
+	"MUR": "Маврикийская рупия",
+	"MVR": "Мальдивская руфия",
+	"MWK": "Малавийская квача",
+	"MXN": "Мексиканское песо",
+	"MYR": "Малайзийский ринггит",
+	"MZN": "Мозамбикский метикал",
+	"NAD": "Доллар Намибии",
+	"NGN": "Нигерийская найра",
+	"NIO": "Никарагуанская кордоба",
+	"NOK": "Норвежская крона",
+	"NPR": "Непальская рупия",
+	"NZD": "Новозеландский доллар",
+	"OMR": "Оманский риал",
+	"PAB": "Панамский бальбоа",
+	"PEN": "Перуанский соль",
+	"PGK": "Кина",
+	"PHP": "Филиппинское песо",
+	"PKR": "Пакистанская рупия",
+	"PLN": "Польский злотый",
+	"PYG": "Парагвайский гуарани",
+	"QAR": "Катарский риал",
+	"RON": "Румынский лей",
+	"RSD": "Сербский динар",
+	"RUB": "Российский рубль",
+	"RWF": "Франк Руанды",
+	"SAR": "Саудовский риял",
+	"SBD": "Доллар Соломоновых Островов",
+	"SCR": "Сейшельская рупия",
+	"SDG": "Суданский фунт",
+	"SEK": "Шведская крона",
+	"SGD": "Сингапурский доллар",
+	"SLE": "Сьерра-леонский леоне",
+	"SOS": "Сомалийский шиллинг",
+	"SRD": "Суринамский доллар",
+	"SVC": "Сальвадорский колон",
+	"SZL": "Свазилендский лилангени",
+	"THB": "Тайский бат",
+	"TJS": "Таджикский сомони",
+	"TMT": "Туркменский манат",
+	"TND": "Тунисский динар",
+	"TOP": "Тонганская паанга",
+	"TRY": "Турецкая лира",
+	"TTD": "Доллар Тринидада и Тобаго",
+	"TWD": "Новый тайваньский доллар",
+	"TZS": "Танзанийский шиллинг",
+	"UAH": "Украинская гривна",
+	"UGX": "Угандийский шиллинг",
+	"USD": "Доллар США",
+	"UYU": "Уругвайское песо",
+	"UZS": "Узбекский сум",
+	"VES": "Суверенный боливар",
+	"VND": "Вьетнамский донг",
+	"XAF": "Центральноафриканский франк КФА",
+	"XCD": "Восточнокарибский доллар",
+	"XOF": "Западноафриканский франк КФА",
+	"XPF": "Франк КФП",
+	"YER": "Йеменский риал",
+	"ZAR": "Южноафриканский рэнд",
+	"ZMW": "Замбийская квача",
+}
+
+// rates сколько долларов стоит единица валюты.
+// Таблица никогда не меняется, при перезагрузке подменяется указатель целиком
+var rates atomic.Pointer[map[string]float64]
+var lastReloadTime atomic.Pointer[time.Time]
+var ratesDate atomic.Pointer[time.Time]
+var onReload atomic.Pointer[func(date time.Time, rates map[string]float64)]
+
+// reloadMutex выполняет замену курсов вместе с onReload по одной, иначе пересчет по старым курсам
+// может закончиться позже пересчета по новым
+var reloadMutex sync.Mutex
+
+func init() {
+	rates.Store(&map[string]float64{"USD": 1})
+}
+
+var provider RateProvider
+
+func Initialize() {
+	var err error
+	provider, err = NewProvider(config.GetCurrencyRatesProvider(), config.GetCurrencyRatesFilepath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = Update(provider); err != nil {
+		//без курсов фильтр по цене работает только для долларов
+		slog.Error("currency", "msg", err.Error())
+	} else {
+		slog.Info("currency", "rates", len(*rates.Load()))
+	}
+	if interval := config.GetCurrencyRatesCheckInterval(); interval > 0 && !isRemoteSource(config.GetCurrencyRatesFilepath()) {
+		go watch(config.GetCurrencyRatesFilepath(), interval)
+	}
+	if interval := config.GetCurrencyRatesRefreshInterval(); interval > 0 {
+		go schedule(interval)
+	}
+}
+
+// SetOnReload задает функцию, которая получает курсы после каждой успешной замены, до возврата из Update.
+// Если курсы уже загружены в Initialize, f сразу получает и их, чтобы вызывающий сохранил историю
+func SetOnReload(f func(date time.Time, rates map[string]float64)) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	onReload.Store(&f)
+	if date := ratesDate.Load(); date != nil {
+		f(*date, *rates.Load())
+	}
+}
+
+// Reload заново запрашивает курсы у провайдера из конфига
+func Reload() error {
+	return Update(provider)
+}
+
+// LoadRates читает файл с курсами в формате []models.CurrencyRate
+func LoadRates(filepath string) error {
+	return Update(&JsonProvider{Source: filepath})
+}
+
+// Update запрашивает курсы у провайдера и, если они целиком корректны, заменяет ими текущие.
+// В историю их сохраняет функция из SetOnReload. Одновременные вызовы выполняются по очереди
+func Update(p RateProvider) error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	list, date, err := p.Rates()
+	if err != nil {
+		return err
+	}
+	newRates, err := makeRates(list)
+	if err != nil {
+		return err
+	}
+	rates.Store(&newRates)
+	ratesDate.Store(&date)
+	now := time.Now()
+	lastReloadTime.Store(&now)
+	if f := onReload.Load(); f != nil {
+		(*f)(date, newRates)
+	}
+	return nil
+}
+
+// watch следит за изменением файла курсов.
+// Если файл не удалось прочитать (например, он еще дописывается), попытка повторяется на следующей проверке
+func watch(filepath string, interval time.Duration) {
+	var lastModTime time.Time
+	var lastSize int64
+	if info, err := os.Stat(filepath); err == nil {
+		lastModTime, lastSize = info.ModTime(), info.Size()
+	}
+	for {
+		time.Sleep(interval)
+		info, err := os.Stat(filepath)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Equal(lastModTime) && info.Size() == lastSize {
+			continue
+		}
+		if err = Update(provider); err != nil {
+			slog.Error("currency", "msg", err.Error())
+			continue
+		}
+		lastModTime, lastSize = info.ModTime(), info.Size()
+		slog.Info("currency", "msg", "rates reloaded", "rates", len(*rates.Load()))
+	}
+}
+
+// schedule периодически обновляет курсы, при ошибке остаются прежние
+func schedule(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := Update(provider); err != nil {
+			slog.Error("currency", "msg", err.Error())
+			continue
+		}
+		slog.Info("currency", "msg", "rates refreshed", "rates", len(*rates.Load()))
+	}
+}
+
+func GetLastReloadTime() *string {
+	t := lastReloadTime.Load()
+	if t == nil {
+		return nil
+	}
+	s := t.Format("2006/01/02 15:04:05")
+	return &s
+}
+
+func makeRates(list []models.CurrencyRate) (map[string]float64, error) {
+	if len(list) == 0 {
+		return nil, errors.New("currency: empty rates list")
+	}
+	result := make(map[string]float64, len(list)+1)
+	result["USD"] = 1
+	for _, rate := range list {
+		code := strings.ToUpper(rate.Currency)
+		if !IsValidCurrency(code) {
+			return nil, fmt.Errorf("currency: unknown currency %q", rate.Currency)
+		}
+		if rate.DollarRate <= 0 || math.IsInf(rate.DollarRate, 0) || math.IsNaN(rate.DollarRate) {
+			return nil, fmt.Errorf("currency: invalid rate %v for %s", rate.DollarRate, code)
+		}
+		if code == "USD" && rate.DollarRate != 1 {
+			return nil, fmt.Errorf("currency: USD rate must be 1, got %v", rate.DollarRate)
+		}
+		result[code] = rate.DollarRate
+	}
+	return result, nil
+}
+
+// CalcDollarPrice переводит цену в доллары, для валюты без курса возвращает 0
 func CalcDollarPrice(currency string, price int64) int64 {
-	return 0
+	rate, ok := (*rates.Load())[strings.ToUpper(currency)]
+	if !ok {
+		return 0
+	}
+	return int64(math.Round(float64(price) * rate))
+}
+
+// CalcPrice переводит цену в долларах в указанную валюту, для валюты без курса возвращает 0
+func CalcPrice(currency string, dollarPrice int64) int64 {
+	rate, ok := (*rates.Load())[strings.ToUpper(currency)]
+	if !ok {
+		return 0
+	}
+	return int64(math.Round(float64(dollarPrice) / rate))
+}
+
+// Format возвращает цену с разделителями разрядов и кодом валюты, например "1,250,000 RUB"
+func Format(currency string, price int64) string {
+	return humanize.Comma(price) + " " + strings.ToUpper(currency)
+}
+
+func HasRate(currency string) bool {
+	_, ok := (*rates.Load())[strings.ToUpper(currency)]
+	return ok
 }
 
 func IsValidCurrency(currency string) bool {
-	return true //todo
+	_, ok := iso4217[strings.ToUpper(currency)]
+	return ok
 }
@@ -0,0 +1,166 @@
+package currency
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"realty/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateProvider источник курсов валют.
+// Source у всех реализаций - путь к локальному файлу или http(s) адрес
+type RateProvider interface {
+	// Rates возвращает курсы к доллару и дату, на которую они действуют
+	Rates() ([]models.CurrencyRate, time.Time, error)
+}
+
+// JsonProvider файл в формате []models.CurrencyRate
+type JsonProvider struct {
+	Source string
+}
+
+// EcbProvider документ eurofxref Европейского центробанка, курсы в нем заданы к евро
+type EcbProvider struct {
+	Source string
+}
+
+// CsvProvider строки вида "currency,dollarRate", заголовок необязателен
+type CsvProvider struct {
+	Source string
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+func NewProvider(kind string, source string) (RateProvider, error) {
+	switch kind {
+	case "", "json":
+		return &JsonProvider{Source: source}, nil
+	case "ecb":
+		return &EcbProvider{Source: source}, nil
+	case "csv":
+		return &CsvProvider{Source: source}, nil
+	default:
+		return nil, fmt.Errorf("currency: unknown rates provider %q", kind)
+	}
+}
+
+func isRemoteSource(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+func openSource(source string) (io.ReadCloser, error) {
+	if !isRemoteSource(source) {
+		return os.Open(source)
+	}
+	response, err := httpClient.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		_ = response.Body.Close()
+		return nil, fmt.Errorf("currency: %s returned status %d", source, response.StatusCode)
+	}
+	return response.Body, nil
+}
+
+func (p *JsonProvider) Rates() ([]models.CurrencyRate, time.Time, error) {
+	reader, err := openSource(p.Source)
+	if err != nil {
+		return nil, time.Time{}, errors.Join(err, errors.New("currency.JsonProvider.Rates()"))
+	}
+	defer reader.Close()
+	list := make([]models.CurrencyRate, 0, len(iso4217))
+	if err = json.NewDecoder(reader).Decode(&list); err != nil {
+		return nil, time.Time{}, errors.Join(err, errors.New("currency.JsonProvider.Rates()"))
+	}
+	return list, time.Now(), nil
+}
+
+type ecbEnvelope struct {
+	Cube struct {
+		Days []struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string  `xml:"currency,attr"`
+				Rate     float64 `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	} `xml:"Cube"`
+}
+
+func (p *EcbProvider) Rates() ([]models.CurrencyRate, time.Time, error) {
+	reader, err := openSource(p.Source)
+	if err != nil {
+		return nil, time.Time{}, errors.Join(err, errors.New("currency.EcbProvider.Rates()"))
+	}
+	defer reader.Close()
+	envelope := ecbEnvelope{}
+	if err = xml.NewDecoder(reader).Decode(&envelope); err != nil {
+		return nil, time.Time{}, errors.Join(err, errors.New("currency.EcbProvider.Rates()"))
+	}
+	if len(envelope.Cube.Days) == 0 {
+		return nil, time.Time{}, errors.New("currency: ecb document has no rates")
+	}
+	//в eurofxref-hist дни идут от новых к старым, берем самый свежий
+	day := envelope.Cube.Days[0]
+	date, err := time.Parse(time.DateOnly, day.Time)
+	if err != nil {
+		return nil, time.Time{}, errors.Join(err, errors.New("currency.EcbProvider.Rates()"))
+	}
+	var dollarsPerEuro float64
+	for _, rate := range day.Rates {
+		if rate.Currency == "USD" {
+			dollarsPerEuro = rate.Rate
+		}
+	}
+	if dollarsPerEuro <= 0 {
+		return nil, time.Time{}, errors.New("currency: ecb document has no USD rate")
+	}
+	list := make([]models.CurrencyRate, 0, len(day.Rates)+1)
+	list = append(list, models.CurrencyRate{Currency: "EUR", DollarRate: dollarsPerEuro})
+	for _, rate := range day.Rates {
+		if rate.Currency == "USD" {
+			continue
+		}
+		if rate.Rate <= 0 {
+			return nil, time.Time{}, fmt.Errorf("currency: invalid ecb rate %v for %s", rate.Rate, rate.Currency)
+		}
+		list = append(list, models.CurrencyRate{Currency: rate.Currency, DollarRate: dollarsPerEuro / rate.Rate})
+	}
+	return list, date, nil
+}
+
+func (p *CsvProvider) Rates() ([]models.CurrencyRate, time.Time, error) {
+	reader, err := openSource(p.Source)
+	if err != nil {
+		return nil, time.Time{}, errors.Join(err, errors.New("currency.CsvProvider.Rates()"))
+	}
+	defer reader.Close()
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = 2
+	csvReader.TrimLeadingSpace = true
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, time.Time{}, errors.Join(err, errors.New("currency.CsvProvider.Rates()"))
+	}
+	list := make([]models.CurrencyRate, 0, len(records))
+	for i, record := range records {
+		if i == 0 && strings.EqualFold(record[0], "currency") {
+			continue
+		}
+		rate, err := strconv.ParseFloat(record[1], 64)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("currency: csv line %d: %w", i+1, err)
+		}
+		list = append(list, models.CurrencyRate{Currency: record[0], DollarRate: rate})
+	}
+	return list, time.Now(), nil
+}
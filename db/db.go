@@ -8,6 +8,7 @@ import (
 	"realty/config"
 	"realty/models"
 	"strings"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -43,7 +44,151 @@ func Initialize() {
 		if err := CreateInMemoryDB(); err != nil {
 			log.Fatal(err)
 		}
+	} else if err := Migrate(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Migrate доводит схему БД на диске до текущей: создает новые таблицы и добавляет новые колонки.
+// Все шаги идемпотентны и выполняются при каждом запуске
+func Migrate() error {
+	if err := MigrateEmailVerification(); err != nil {
+		return err
+	}
+	if _, err := dbUsers.Exec(createSessionsTable); err != nil {
+		return errors.Join(err, errors.New("db.Migrate() sessions"))
+	}
+	if err := addColumns(dbAdvs, "advs", [][2]string{
+		{"deal_type", "INTEGER NOT NULL DEFAULT 0"},
+		{"rent_period", "INTEGER NOT NULL DEFAULT 0"},
+		{"property_type", "INTEGER NOT NULL DEFAULT 0"},
+		{"rooms", "INTEGER NOT NULL DEFAULT 0"},
+		{"floor", "INTEGER NOT NULL DEFAULT 0"},
+		{"floors", "INTEGER NOT NULL DEFAULT 0"},
+		{"year_built", "INTEGER NOT NULL DEFAULT 0"},
+		{"total_area", "REAL NOT NULL DEFAULT 0"},
+		{"living_area", "REAL NOT NULL DEFAULT 0"},
+		{"duplicate_of", "INTEGER NOT NULL DEFAULT 0"},
+	}); err != nil {
+		return err
+	}
+	if _, err := dbUsers.Exec(createSavedSearchesTable); err != nil {
+		return errors.Join(err, errors.New("db.Migrate() saved_searches"))
+	}
+	if err := addColumns(dbUsers, "invites", [][2]string{
+		{"created_by", "INTEGER not null default 0"},
+		{"created", "INTEGER not null default 0"},
+		{"expires", "INTEGER not null default 0"},
+		{"max_uses", "INTEGER not null default 0"},
+		{"uses", "INTEGER not null default 0"},
+		{"revoked", "INTEGER not null default 0"},
+	}); err != nil {
+		return err
+	}
+	if err := addColumns(dbUsers, "users", [][2]string{
+		{"reset_hash", "BLOB"},
+		{"reset_expires", "INTEGER not null default 0"},
+	}); err != nil {
+		return err
+	}
+	if _, err := dbUsers.Exec(createApiKeysTable); err != nil {
+		return errors.Join(err, errors.New("db.Migrate() api_keys"))
+	}
+	if _, err := dbAdvs.Exec(createCurrencyRatesTable); err != nil {
+		return errors.Join(err, errors.New("db.Migrate() currency_rates"))
+	}
+	return nil
+}
+
+const createSavedSearchesTable = `
+    CREATE TABLE IF NOT EXISTS saved_searches (
+        id INTEGER PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        updated INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        filter TEXT NOT NULL
+    ) without ROWID, strict;
+`
+
+const createApiKeysTable = `
+    CREATE TABLE IF NOT EXISTS api_keys (
+        id INTEGER PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        hash BLOB NOT NULL,
+        scopes INTEGER NOT NULL,
+        created INTEGER NOT NULL,
+        last_used INTEGER NOT NULL,
+        last_used_ip TEXT NOT NULL
+    ) without ROWID, strict;
+`
+
+const createCurrencyRatesTable = `
+    CREATE TABLE IF NOT EXISTS currency_rates (
+        date TEXT NOT NULL,
+        currency TEXT NOT NULL,
+        dollar_rate REAL NOT NULL,
+        PRIMARY KEY (date, currency)
+    ) without ROWID, strict;
+`
+
+// addColumns добавляет в table колонки, которых в ней еще нет. columns - пары из имени и определения колонки
+func addColumns(db *sql.DB, table string, columns [][2]string) error {
+	for _, column := range columns {
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM pragma_table_info(?) WHERE name = ?", table, column[0]).Scan(&count); err != nil {
+			return errors.Join(err, errors.New("db.addColumns() "+table))
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column[0] + " " + column[1]); err != nil {
+			return errors.Join(err, errors.New("db.addColumns() "+table+"."+column[0]))
+		}
 	}
+	return nil
+}
+
+const createSessionsTable = `
+    CREATE TABLE IF NOT EXISTS sessions (
+        id INTEGER PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        created INTEGER NOT NULL,
+        last_seen INTEGER NOT NULL,
+        device TEXT NOT NULL,
+        ip TEXT NOT NULL,
+        user_agent TEXT NOT NULL
+    ) without ROWID, strict;
+`
+
+// MigrateEmailVerification добавляет в таблицу users колонки подтверждения email, если их еще нет.
+// Пользователи, зарегистрированные до подтверждения, считаются подтвержденными, иначе им нельзя подавать объявления
+func MigrateEmailVerification() error {
+	var count int
+	if err := dbUsers.QueryRow("SELECT count(*) FROM pragma_table_info('users') WHERE name = 'email_verified'").Scan(&count); err != nil {
+		return errors.Join(err, errors.New("db.MigrateEmailVerification() 1"))
+	}
+	if count > 0 {
+		return nil
+	}
+	tx, err := dbUsers.Begin()
+	if err != nil {
+		return errors.Join(err, errors.New("db.MigrateEmailVerification() 2"))
+	}
+	defer tx.Rollback()
+	for _, query := range []string{
+		"ALTER TABLE users ADD COLUMN email_verified INTEGER not null default 1",
+		"ALTER TABLE users ADD COLUMN verify_token TEXT not null default ''",
+		"ALTER TABLE users ADD COLUMN verify_expires INTEGER not null default 0",
+	} {
+		if _, err = tx.Exec(query); err != nil {
+			return errors.Join(err, errors.New("db.MigrateEmailVerification() 3"))
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Join(err, errors.New("db.MigrateEmailVerification() 4"))
+	}
+	return nil
 }
 
 func CreateInMemoryDB() error {
@@ -60,15 +205,26 @@ func CreateInMemoryDB() error {
     balance        REAL      not null,
     trusted        INTEGER   not null,
     enabled        INTEGER   not null,
-    description    TEXT
+    description    TEXT,
+    email_verified INTEGER   not null default 0,
+    verify_token   TEXT      not null default '',
+    verify_expires INTEGER   not null default 0,
+    reset_hash     BLOB,
+    reset_expires  INTEGER   not null default 0
 ) without ROWID, strict;`); err != nil {
 		return errors.Join(err, errors.New("db.CreateInMemoryDB() 1"))
 	}
 
 	if _, err := dbUsers.Exec(`create table invites
 (
-    id   TEXT primary key,
-	name TEXT
+    id         TEXT primary key,
+	name       TEXT,
+	created_by INTEGER not null default 0,
+	created    INTEGER not null default 0,
+	expires    INTEGER not null default 0,
+	max_uses   INTEGER not null default 0,
+	uses       INTEGER not null default 0,
+	revoked    INTEGER not null default 0
 ) without ROWID, strict;`); err != nil {
 		return errors.Join(err, errors.New("db.CreateInMemoryDB() 2"))
 	}
@@ -95,7 +251,17 @@ func CreateInMemoryDB() error {
 		        paid_adv INTEGER NOT NULL,
 		        se_visible INTEGER NOT NULL,
 		        user_comment TEXT NOT NULL,
-		        admin_comment TEXT NOT NULL
+		        admin_comment TEXT NOT NULL,
+		        deal_type INTEGER NOT NULL DEFAULT 0,
+		        rent_period INTEGER NOT NULL DEFAULT 0,
+		        property_type INTEGER NOT NULL DEFAULT 0,
+		        rooms INTEGER NOT NULL DEFAULT 0,
+		        floor INTEGER NOT NULL DEFAULT 0,
+		        floors INTEGER NOT NULL DEFAULT 0,
+		        year_built INTEGER NOT NULL DEFAULT 0,
+		        total_area REAL NOT NULL DEFAULT 0,
+		        living_area REAL NOT NULL DEFAULT 0,
+		        duplicate_of INTEGER NOT NULL DEFAULT 0
 		    ) without ROWID, strict;
 		`); err != nil {
 		return errors.Join(err, errors.New("db.CreateInMemoryDB() 3"))
@@ -119,13 +285,38 @@ func CreateInMemoryDB() error {
 `); err != nil {
 		return errors.Join(err, errors.New("db.CreateInMemoryDB() 5"))
 	}
+
+	if _, err := dbAdvs.Exec(createCurrencyRatesTable); err != nil {
+		return errors.Join(err, errors.New("db.CreateInMemoryDB() 6"))
+	}
+
+	if _, err := dbUsers.Exec(createSessionsTable); err != nil {
+		return errors.Join(err, errors.New("db.CreateInMemoryDB() 8"))
+	}
+
+	if _, err := dbUsers.Exec(createApiKeysTable); err != nil {
+		return errors.Join(err, errors.New("db.CreateInMemoryDB() 9"))
+	}
+
+	if _, err := dbUsers.Exec(createSavedSearchesTable); err != nil {
+		return errors.Join(err, errors.New("db.CreateInMemoryDB() 7"))
+	}
 	return nil
 }
 
-func ReadDb() (users []*models.User, advs []*models.Adv, photos []*models.Photo, watches []*models.Watches, err error) {
+func ReadDb() (users []*models.User, sessions []*models.Session, apiKeys []*models.ApiKey, invites []*models.Invite, advs []*models.Adv, photos []*models.Photo, watches []*models.Watches, savedSearches []*models.SavedSearch, err error) {
 	if users, err = GetUsers(); err != nil {
 		return
 	}
+	if sessions, err = GetSessions(); err != nil {
+		return
+	}
+	if apiKeys, err = GetApiKeys(); err != nil {
+		return
+	}
+	if invites, err = GetInvites(); err != nil {
+		return
+	}
 	if advs, err = GetAdvs(); err != nil {
 		return
 	}
@@ -135,6 +326,9 @@ func ReadDb() (users []*models.User, advs []*models.Adv, photos []*models.Photo,
 	if watches, err = GetWatches(); err != nil {
 		return
 	}
+	if savedSearches, err = GetSavedSearches(); err != nil {
+		return
+	}
 	return
 }
 
@@ -144,9 +338,12 @@ func CreateAdv(adv *models.Adv) error {
 			id, user_id, updated, approved, lang, origin_lang, title,
 			description, price, currency, country, city, address, latitude,
 			longitude, paid_adv, se_visible, user_comment,
-			admin_comment, translated_to, translated_by
+			admin_comment, translated_to, translated_by,
+			deal_type, rent_period, property_type, rooms, floor,
+			floors, year_built, total_area, living_area, duplicate_of
 		) VALUES (
-			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?,
+			?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 		)
 	`
 	_, err := dbAdvs.Exec(query,
@@ -155,6 +352,8 @@ func CreateAdv(adv *models.Adv) error {
 		adv.Country, adv.City, adv.Address, adv.Latitude, adv.Longitude,
 		adv.PaidAdv, adv.SeVisible, adv.UserComment,
 		adv.AdminComment, adv.TranslatedTo, adv.TranslatedBy,
+		adv.DealType, adv.RentPeriod, adv.PropertyType, adv.Rooms, adv.Floor,
+		adv.Floors, adv.YearBuilt, adv.TotalArea, adv.LivingArea, adv.DuplicateOf,
 	)
 	if err != nil {
 		return errors.Join(err, errors.New("db.CreateAdv()"))
@@ -181,7 +380,13 @@ func GetAdv(id int64) (*models.Adv, error) {
 }
 
 func GetAdvs() ([]*models.Adv, error) {
-	rows, err := dbAdvs.Query("SELECT * FROM advs ORDER BY id")
+	rows, err := dbAdvs.Query(`
+		SELECT id, user_id, updated, approved, lang, origin_lang, title, description, price,
+			currency, country, city, address, latitude, longitude, paid_adv, se_visible,
+			user_comment, admin_comment, translated_to,
+			deal_type, rent_period, property_type, rooms, floor,
+			floors, year_built, total_area, living_area, duplicate_of
+		FROM advs ORDER BY id`)
 	if err != nil {
 		return nil, errors.Join(err, errors.New("db.GetAdvs()"))
 	}
@@ -197,6 +402,8 @@ func GetAdvs() ([]*models.Adv, error) {
 			&adv.Currency, &adv.Country, &adv.City, &adv.Address, &adv.Latitude,
 			&adv.Longitude, &adv.PaidAdv, &adv.SeVisible,
 			&adv.UserComment, &adv.AdminComment, &adv.TranslatedTo,
+			&adv.DealType, &adv.RentPeriod, &adv.PropertyType, &adv.Rooms, &adv.Floor,
+			&adv.Floors, &adv.YearBuilt, &adv.TotalArea, &adv.LivingArea, &adv.DuplicateOf,
 		)
 		if err != nil {
 			return nil, errors.Join(err, errors.New("db.GetAdvs()"))
@@ -228,7 +435,17 @@ func UpdateAdv(adv *models.Adv) error {
 			se_visible = ?,
 			user_comment = ?,
 			admin_comment = ?,
-			translated_to = ?
+			translated_to = ?,
+			deal_type = ?,
+			rent_period = ?,
+			property_type = ?,
+			rooms = ?,
+			floor = ?,
+			floors = ?,
+			year_built = ?,
+			total_area = ?,
+			living_area = ?,
+			duplicate_of = ?
 		WHERE id = ?
 	`
 	_, err := dbUsers.Exec(query,
@@ -236,7 +453,9 @@ func UpdateAdv(adv *models.Adv) error {
 		adv.OriginLang, adv.Title, adv.Description, adv.Price, adv.Currency,
 		adv.Country, adv.City, adv.Address, adv.Latitude, adv.Longitude,
 		adv.PaidAdv, adv.SeVisible, adv.UserComment,
-		adv.AdminComment, adv.TranslatedTo, adv.Id,
+		adv.AdminComment, adv.TranslatedTo,
+		adv.DealType, adv.RentPeriod, adv.PropertyType, adv.Rooms, adv.Floor,
+		adv.Floors, adv.YearBuilt, adv.TotalArea, adv.LivingArea, adv.DuplicateOf, adv.Id,
 	)
 	if err != nil {
 		return errors.Join(err, errors.New("db.UpdateAdv()"))
@@ -245,8 +464,8 @@ func UpdateAdv(adv *models.Adv) error {
 }
 
 func UpdateAdvChanges(oldAdv, newAdv *models.Adv) error {
-	args := make([]interface{}, 0, 16)
-	setClauses := make([]string, 0, 16)
+	args := make([]interface{}, 0, 32)
+	setClauses := make([]string, 0, 32)
 
 	if oldAdv.UserId != newAdv.UserId {
 		setClauses = append(setClauses, "user_id = ?")
@@ -324,6 +543,46 @@ func UpdateAdvChanges(oldAdv, newAdv *models.Adv) error {
 		setClauses = append(setClauses, "translated_to = ?")
 		args = append(args, newAdv.TranslatedTo)
 	}
+	if oldAdv.DealType != newAdv.DealType {
+		setClauses = append(setClauses, "deal_type = ?")
+		args = append(args, newAdv.DealType)
+	}
+	if oldAdv.RentPeriod != newAdv.RentPeriod {
+		setClauses = append(setClauses, "rent_period = ?")
+		args = append(args, newAdv.RentPeriod)
+	}
+	if oldAdv.PropertyType != newAdv.PropertyType {
+		setClauses = append(setClauses, "property_type = ?")
+		args = append(args, newAdv.PropertyType)
+	}
+	if oldAdv.Rooms != newAdv.Rooms {
+		setClauses = append(setClauses, "rooms = ?")
+		args = append(args, newAdv.Rooms)
+	}
+	if oldAdv.Floor != newAdv.Floor {
+		setClauses = append(setClauses, "floor = ?")
+		args = append(args, newAdv.Floor)
+	}
+	if oldAdv.Floors != newAdv.Floors {
+		setClauses = append(setClauses, "floors = ?")
+		args = append(args, newAdv.Floors)
+	}
+	if oldAdv.YearBuilt != newAdv.YearBuilt {
+		setClauses = append(setClauses, "year_built = ?")
+		args = append(args, newAdv.YearBuilt)
+	}
+	if oldAdv.TotalArea != newAdv.TotalArea {
+		setClauses = append(setClauses, "total_area = ?")
+		args = append(args, newAdv.TotalArea)
+	}
+	if oldAdv.LivingArea != newAdv.LivingArea {
+		setClauses = append(setClauses, "living_area = ?")
+		args = append(args, newAdv.LivingArea)
+	}
+	if oldAdv.DuplicateOf != newAdv.DuplicateOf {
+		setClauses = append(setClauses, "duplicate_of = ?")
+		args = append(args, newAdv.DuplicateOf)
+	}
 
 	if len(setClauses) == 0 {
 		return nil
@@ -353,15 +612,17 @@ func CreateUser(user *models.User) error {
 	query := `
 		INSERT INTO users (
 			id, email, name, password_hash, session_secret, invite_id, trusted,
-			enabled, balance, description
+			enabled, balance, description, email_verified, verify_token, verify_expires,
+			reset_hash, reset_expires
 		) VALUES (
-			?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 		)
 	`
 	_, err := dbUsers.Exec(query,
 		user.Id, user.Email, user.Name, user.PasswordHash, user.SessionSecret[:],
 		user.InviteId, user.Trusted, user.Enabled, user.Balance,
-		user.Description,
+		user.Description, user.EmailVerified, user.VerifyToken, unixNanoOrZero(user.VerifyExpires),
+		user.ResetHash, unixNanoOrZero(user.ResetExpires),
 	)
 	if err != nil {
 		return errors.Join(err, errors.New("db.CreateUser()"))
@@ -370,17 +631,31 @@ func CreateUser(user *models.User) error {
 	return nil
 }
 
+// userColumns в порядке Scan в GetUser и GetUsers. В старых БД порядок колонок другой и есть лишние, поэтому без SELECT *
+const userColumns = `id, email, name, password_hash, session_secret, invite_id, trusted, enabled,
+	balance, description, email_verified, verify_token, verify_expires, reset_hash, reset_expires`
+
 func GetUser(id int64) (*models.User, error) {
 	user := &models.User{}
-	query := "SELECT * FROM users WHERE id = ?"
+	var verifyExpires, resetExpires int64
+	var sessionSecret []byte
+	query := "SELECT " + userColumns + " FROM users WHERE id = ?"
 	err := dbUsers.QueryRow(query, id).Scan(
 		&user.Id, &user.Email, &user.Name, &user.PasswordHash,
-		&user.SessionSecret, &user.InviteId, &user.Trusted, &user.Enabled,
-		&user.Balance, &user.Description,
+		&sessionSecret, &user.InviteId, &user.Trusted, &user.Enabled,
+		&user.Balance, &user.Description, &user.EmailVerified, &user.VerifyToken,
+		&verifyExpires, &user.ResetHash, &resetExpires,
 	)
 	if err != nil {
 		return nil, errors.Join(err, errors.New("db.GetUser()"))
 	}
+	copy(user.SessionSecret[:], sessionSecret)
+	if verifyExpires != 0 {
+		user.VerifyExpires = time.Unix(0, verifyExpires)
+	}
+	if resetExpires != 0 {
+		user.ResetExpires = time.Unix(0, resetExpires)
+	}
 
 	return user, nil
 }
@@ -396,13 +671,19 @@ func UpdateUser(user *models.User) error {
 			trusted = ?,
 			enabled = ?,
 			balance = ?,
-			description = ?
+			description = ?,
+			email_verified = ?,
+			verify_token = ?,
+			verify_expires = ?,
+			reset_hash = ?,
+			reset_expires = ?
 		WHERE id = ?
 	`
 	_, err := dbUsers.Exec(query,
 		user.Email, user.Name, user.PasswordHash, user.SessionSecret[:],
 		user.InviteId, user.Trusted, user.Enabled, user.Balance,
-		user.Description, user.Id,
+		user.Description, user.EmailVerified, user.VerifyToken, unixNanoOrZero(user.VerifyExpires),
+		user.ResetHash, unixNanoOrZero(user.ResetExpires), user.Id,
 	)
 
 	if err != nil {
@@ -413,8 +694,8 @@ func UpdateUser(user *models.User) error {
 
 func UpdateUserChanges(oldUser, newUser *models.User) error {
 
-	args := make([]interface{}, 0, 9)
-	setClauses := make([]string, 0, 9)
+	args := make([]interface{}, 0, 14)
+	setClauses := make([]string, 0, 14)
 
 	if oldUser.Email != newUser.Email {
 		setClauses = append(setClauses, "email = ?")
@@ -452,6 +733,26 @@ func UpdateUserChanges(oldUser, newUser *models.User) error {
 		setClauses = append(setClauses, "description = ?")
 		args = append(args, newUser.Description)
 	}
+	if oldUser.EmailVerified != newUser.EmailVerified {
+		setClauses = append(setClauses, "email_verified = ?")
+		args = append(args, newUser.EmailVerified)
+	}
+	if oldUser.VerifyToken != newUser.VerifyToken {
+		setClauses = append(setClauses, "verify_token = ?")
+		args = append(args, newUser.VerifyToken)
+	}
+	if !oldUser.VerifyExpires.Equal(newUser.VerifyExpires) {
+		setClauses = append(setClauses, "verify_expires = ?")
+		args = append(args, unixNanoOrZero(newUser.VerifyExpires))
+	}
+	if !bytes.Equal(oldUser.ResetHash, newUser.ResetHash) {
+		setClauses = append(setClauses, "reset_hash = ?")
+		args = append(args, newUser.ResetHash)
+	}
+	if !oldUser.ResetExpires.Equal(newUser.ResetExpires) {
+		setClauses = append(setClauses, "reset_expires = ?")
+		args = append(args, unixNanoOrZero(newUser.ResetExpires))
+	}
 
 	if len(setClauses) == 0 {
 		return nil
@@ -468,7 +769,7 @@ func UpdateUserChanges(oldUser, newUser *models.User) error {
 }
 
 func GetUsers() ([]*models.User, error) {
-	rows, err := dbUsers.Query("SELECT * FROM users ORDER BY id")
+	rows, err := dbUsers.Query("SELECT " + userColumns + " FROM users ORDER BY id")
 	if err != nil {
 		return nil, errors.Join(err, errors.New("db.GetUsers()"))
 	}
@@ -478,14 +779,25 @@ func GetUsers() ([]*models.User, error) {
 
 	for rows.Next() {
 		user := &models.User{}
+		var verifyExpires, resetExpires int64
+		//database/sql не сканирует BLOB в массив, только в слайс
+		var sessionSecret []byte
 		err := rows.Scan(
 			&user.Id, &user.Email, &user.Name, &user.PasswordHash,
-			&user.SessionSecret, &user.InviteId, &user.Trusted, &user.Enabled,
-			&user.Balance, &user.Description,
+			&sessionSecret, &user.InviteId, &user.Trusted, &user.Enabled,
+			&user.Balance, &user.Description, &user.EmailVerified, &user.VerifyToken,
+			&verifyExpires, &user.ResetHash, &resetExpires,
 		)
 		if err != nil {
 			return nil, errors.Join(err, errors.New("db.GetUsers()"))
 		}
+		copy(user.SessionSecret[:], sessionSecret)
+		if verifyExpires != 0 {
+			user.VerifyExpires = time.Unix(0, verifyExpires)
+		}
+		if resetExpires != 0 {
+			user.ResetExpires = time.Unix(0, resetExpires)
+		}
 		users = append(users, user)
 	}
 
@@ -613,3 +925,306 @@ func DeleteWatches(id int64) error {
 	}
 	return nil
 }
+
+func CreateSavedSearch(search *models.SavedSearch) error {
+	query := `
+		INSERT INTO saved_searches (
+			id, user_id, updated, name, filter
+		) VALUES (
+			?, ?, ?, ?, ?
+		)
+	`
+	_, err := dbUsers.Exec(query,
+		search.Id, search.UserId, search.Updated.UnixNano(), search.Name, search.Filter,
+	)
+	if err != nil {
+		return errors.Join(err, errors.New("db.CreateSavedSearch()"))
+	}
+	return nil
+}
+
+func GetSavedSearches() ([]*models.SavedSearch, error) {
+	rows, err := dbUsers.Query("SELECT id, user_id, updated, name, filter FROM saved_searches ORDER BY id")
+	if err != nil {
+		return nil, errors.Join(err, errors.New("db.GetSavedSearches()"))
+	}
+	defer rows.Close()
+	var searches []*models.SavedSearch
+	for rows.Next() {
+		search := &models.SavedSearch{}
+		var updated int64
+		err := rows.Scan(
+			&search.Id, &search.UserId, &updated, &search.Name, &search.Filter,
+		)
+		if err != nil {
+			return nil, errors.Join(err, errors.New("db.GetSavedSearches()"))
+		}
+		search.Updated = time.Unix(0, updated)
+		searches = append(searches, search)
+	}
+	return searches, nil
+}
+
+func UpdateSavedSearch(search *models.SavedSearch) error {
+	query := `
+		UPDATE saved_searches SET
+			updated = ?, name = ?, filter = ?
+		WHERE id = ?
+	`
+	_, err := dbUsers.Exec(query,
+		search.Updated.UnixNano(), search.Name, search.Filter, search.Id,
+	)
+	if err != nil {
+		return errors.Join(err, errors.New("db.UpdateSavedSearch()"))
+	}
+	return nil
+}
+
+func DeleteSavedSearch(id int64) error {
+	query := "DELETE FROM saved_searches WHERE id = ?"
+	_, err := dbUsers.Exec(query, id)
+	if err != nil {
+		return errors.Join(err, errors.New("db.DeleteSavedSearch()"))
+	}
+	return nil
+}
+
+func CreateSession(session *models.Session) error {
+	query := `
+		INSERT INTO sessions (
+			id, user_id, created, last_seen, device, ip, user_agent
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?
+		)
+	`
+	_, err := dbUsers.Exec(query,
+		session.Id, session.UserId, session.Created.UnixNano(), session.LastSeen.UnixNano(),
+		session.Device, session.Ip, session.UserAgent,
+	)
+	if err != nil {
+		return errors.Join(err, errors.New("db.CreateSession()"))
+	}
+	return nil
+}
+
+func GetSessions() ([]*models.Session, error) {
+	rows, err := dbUsers.Query("SELECT id, user_id, created, last_seen, device, ip, user_agent FROM sessions ORDER BY id")
+	if err != nil {
+		return nil, errors.Join(err, errors.New("db.GetSessions()"))
+	}
+	defer rows.Close()
+	var sessions []*models.Session
+	for rows.Next() {
+		session := &models.Session{}
+		var created, lastSeen int64
+		err := rows.Scan(
+			&session.Id, &session.UserId, &created, &lastSeen,
+			&session.Device, &session.Ip, &session.UserAgent,
+		)
+		if err != nil {
+			return nil, errors.Join(err, errors.New("db.GetSessions()"))
+		}
+		session.Created = time.Unix(0, created)
+		session.LastSeen = time.Unix(0, lastSeen)
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func UpdateSession(session *models.Session) error {
+	query := `
+		UPDATE sessions SET
+			last_seen = ?, ip = ?, user_agent = ?
+		WHERE id = ?
+	`
+	_, err := dbUsers.Exec(query,
+		session.LastSeen.UnixNano(), session.Ip, session.UserAgent, session.Id,
+	)
+	if err != nil {
+		return errors.Join(err, errors.New("db.UpdateSession()"))
+	}
+	return nil
+}
+
+func DeleteSession(id int64) error {
+	query := "DELETE FROM sessions WHERE id = ?"
+	_, err := dbUsers.Exec(query, id)
+	if err != nil {
+		return errors.Join(err, errors.New("db.DeleteSession()"))
+	}
+	return nil
+}
+
+func CreateApiKey(apiKey *models.ApiKey) error {
+	query := `
+		INSERT INTO api_keys (
+			id, user_id, name, hash, scopes, created, last_used, last_used_ip
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?
+		)
+	`
+	_, err := dbUsers.Exec(query,
+		apiKey.Id, apiKey.UserId, apiKey.Name, apiKey.Hash, apiKey.Scopes,
+		apiKey.Created.UnixNano(), unixNanoOrZero(apiKey.LastUsed), apiKey.LastUsedIp,
+	)
+	if err != nil {
+		return errors.Join(err, errors.New("db.CreateApiKey()"))
+	}
+	return nil
+}
+
+func GetApiKeys() ([]*models.ApiKey, error) {
+	rows, err := dbUsers.Query("SELECT id, user_id, name, hash, scopes, created, last_used, last_used_ip FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, errors.Join(err, errors.New("db.GetApiKeys()"))
+	}
+	defer rows.Close()
+	var apiKeys []*models.ApiKey
+	for rows.Next() {
+		apiKey := &models.ApiKey{}
+		var created, lastUsed int64
+		err := rows.Scan(
+			&apiKey.Id, &apiKey.UserId, &apiKey.Name, &apiKey.Hash, &apiKey.Scopes,
+			&created, &lastUsed, &apiKey.LastUsedIp,
+		)
+		if err != nil {
+			return nil, errors.Join(err, errors.New("db.GetApiKeys()"))
+		}
+		apiKey.Created = time.Unix(0, created)
+		if lastUsed != 0 {
+			apiKey.LastUsed = time.Unix(0, lastUsed)
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	return apiKeys, nil
+}
+
+func UpdateApiKey(apiKey *models.ApiKey) error {
+	query := `
+		UPDATE api_keys SET
+			last_used = ?, last_used_ip = ?
+		WHERE id = ?
+	`
+	_, err := dbUsers.Exec(query,
+		unixNanoOrZero(apiKey.LastUsed), apiKey.LastUsedIp, apiKey.Id,
+	)
+	if err != nil {
+		return errors.Join(err, errors.New("db.UpdateApiKey()"))
+	}
+	return nil
+}
+
+func DeleteApiKey(id int64) error {
+	query := "DELETE FROM api_keys WHERE id = ?"
+	_, err := dbUsers.Exec(query, id)
+	if err != nil {
+		return errors.Join(err, errors.New("db.DeleteApiKey()"))
+	}
+	return nil
+}
+
+func CreateInvite(invite *models.Invite) error {
+	query := `
+		INSERT INTO invites (
+			id, name, created_by, created, expires, max_uses, uses, revoked
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?
+		)
+	`
+	_, err := dbUsers.Exec(query,
+		invite.Id, invite.Company, invite.CreatedBy, invite.Created.UnixNano(), unixNanoOrZero(invite.Expires),
+		invite.MaxUses, invite.Uses, invite.Revoked,
+	)
+	if err != nil {
+		return errors.Join(err, errors.New("db.CreateInvite()"))
+	}
+	return nil
+}
+
+func GetInvites() ([]*models.Invite, error) {
+	rows, err := dbUsers.Query("SELECT id, name, created_by, created, expires, max_uses, uses, revoked FROM invites ORDER BY created")
+	if err != nil {
+		return nil, errors.Join(err, errors.New("db.GetInvites()"))
+	}
+	defer rows.Close()
+	var invites []*models.Invite
+	for rows.Next() {
+		invite := &models.Invite{}
+		var company sql.NullString
+		var created, expires int64
+		err := rows.Scan(
+			&invite.Id, &company, &invite.CreatedBy, &created, &expires,
+			&invite.MaxUses, &invite.Uses, &invite.Revoked,
+		)
+		if err != nil {
+			return nil, errors.Join(err, errors.New("db.GetInvites()"))
+		}
+		invite.Company = company.String
+		invite.Created = time.Unix(0, created)
+		if expires != 0 {
+			invite.Expires = time.Unix(0, expires)
+		}
+		invites = append(invites, invite)
+	}
+	return invites, nil
+}
+
+func UpdateInvite(invite *models.Invite) error {
+	query := `
+		UPDATE invites SET
+			uses = ?, revoked = ?
+		WHERE id = ?
+	`
+	_, err := dbUsers.Exec(query,
+		invite.Uses, invite.Revoked, invite.Id,
+	)
+	if err != nil {
+		return errors.Join(err, errors.New("db.UpdateInvite()"))
+	}
+	return nil
+}
+
+// unixNanoOrZero нулевое время хранится как 0, а не как UnixNano() от 0001 года
+func unixNanoOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// SaveCurrencyRates сохраняет курсы в историю, повторное сохранение за ту же дату перезаписывает курсы
+func SaveCurrencyRates(date time.Time, rates map[string]float64) error {
+	tx, err := dbAdvs.Begin()
+	if err != nil {
+		return errors.Join(err, errors.New("db.SaveCurrencyRates()"))
+	}
+	query := "INSERT OR REPLACE INTO currency_rates (date, currency, dollar_rate) VALUES (?, ?, ?)"
+	day := date.Format(time.DateOnly)
+	for currency, rate := range rates {
+		if _, err = tx.Exec(query, day, currency, rate); err != nil {
+			_ = tx.Rollback()
+			return errors.Join(err, errors.New("db.SaveCurrencyRates()"))
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Join(err, errors.New("db.SaveCurrencyRates()"))
+	}
+	return nil
+}
+
+func GetCurrencyRates(date time.Time) ([]*models.CurrencyRate, error) {
+	rows, err := dbAdvs.Query("SELECT currency, dollar_rate FROM currency_rates WHERE date = ? ORDER BY currency", date.Format(time.DateOnly))
+	if err != nil {
+		return nil, errors.Join(err, errors.New("db.GetCurrencyRates()"))
+	}
+	defer rows.Close()
+	var rates []*models.CurrencyRate
+	for rows.Next() {
+		rate := &models.CurrencyRate{}
+		if err = rows.Scan(&rate.Currency, &rate.DollarRate); err != nil {
+			return nil, errors.Join(err, errors.New("db.GetCurrencyRates()"))
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"realty/config"
+	"testing"
+	"time"
+)
+
+// baselineSchema схема БД на диске до появления миграций, как в data/*.sqlite
+var baselineSchema = map[string][]string{
+	"users.sqlite": {
+		`CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			email TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			password_hash BLOB NOT NULL,
+			session_secret BLOB NOT NULL,
+			invite_id TEXT,
+			balance REAL NOT NULL,
+			trusted BOOLEAN NOT NULL,
+			created TIMESTAMP NOT NULL,
+			enabled BOOLEAN NOT NULL,
+			description TEXT
+		)`,
+		`CREATE TABLE invites (id TEXT primary key, name TEXT) without ROWID, strict`,
+		`INSERT INTO users VALUES (1720360451151465000, 'old@example.com', 'Old', x'00', x'00', '', 0, 0, 0, 1, '')`,
+		`INSERT INTO invites VALUES ('old-invite', 'Агентство')`,
+	},
+	"advs.sqlite": {
+		`CREATE TABLE advs (
+			id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, updated INTEGER NOT NULL, approved INTEGER NOT NULL,
+			lang INTEGER NOT NULL, origin_lang INTEGER NOT NULL, translated_by INTEGER NOT NULL, translated_to TEXT NOT NULL,
+			title TEXT NOT NULL, description TEXT NOT NULL, price INTEGER NOT NULL, currency TEXT NOT NULL,
+			country TEXT NOT NULL, city TEXT NOT NULL, address TEXT NOT NULL, latitude REAL NOT NULL, longitude REAL NOT NULL,
+			paid_adv INTEGER NOT NULL, se_visible INTEGER NOT NULL, user_comment TEXT NOT NULL, admin_comment TEXT NOT NULL
+		) without ROWID, strict`,
+	},
+	"photos.sqlite": {
+		`CREATE TABLE photos (id INTEGER PRIMARY KEY, adv_id INTEGER NOT NULL, ext INTEGER NOT NULL) without ROWID, strict`,
+	},
+	"watches.sqlite": {
+		`CREATE TABLE watches (adv_id INTEGER not null primary key, count INTEGER not null) without rowid, strict`,
+	},
+}
+
+func TestMigrateBaselineDb(t *testing.T) {
+	dir := t.TempDir()
+	for file, queries := range baselineSchema {
+		baseline, err := sql.Open("sqlite", dir+"/"+file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, query := range queries {
+			if _, err = baseline.Exec(query); err != nil {
+				t.Fatalf("%s: %v", file, err)
+			}
+		}
+		_ = baseline.Close()
+	}
+	t.Setenv("DATA_DIR", dir)
+	config.Initialize()
+	Initialize()
+	//повторный запуск ничего не меняет
+	if err := Migrate(); err != nil {
+		t.Fatalf("second migration: %v", err)
+	}
+
+	users, sessions, apiKeys, invites, advs, _, _, savedSearches, err := ReadDb()
+	if err != nil {
+		t.Fatalf("read migrated db: %v", err)
+	}
+	if len(users) != 1 || !users[0].EmailVerified || users[0].Email != "old@example.com" {
+		t.Errorf("wrong migrated users: %+v", users)
+	}
+	if len(invites) != 1 || invites[0].Id != "old-invite" || invites[0].Revoked {
+		t.Errorf("wrong migrated invites: %+v", invites)
+	}
+	if len(advs) != 0 || len(sessions) != 0 || len(apiKeys) != 0 || len(savedSearches) != 0 {
+		t.Errorf("tables must be empty: %d %d %d %d", len(advs), len(sessions), len(apiKeys), len(savedSearches))
+	}
+
+	date := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	if err = SaveCurrencyRates(date, map[string]float64{"EUR": 1.07, "RUB": 0.011}); err != nil {
+		t.Fatalf("save currency rates: %v", err)
+	}
+	if rates, err := GetCurrencyRates(date); err != nil || len(rates) != 2 {
+		t.Errorf("wrong currency rates history: %v %v", rates, err)
+	}
+}
@@ -1,18 +1,23 @@
 package dto
 
 import (
+	"encoding/json"
 	"time"
 )
 
 type Metrics struct {
-	UnSavedChangesQueueCount int64                            `json:"unSavedChangesCount"`
-	DbErrorCount             int64                            `json:"dbErrorCount"`
-	RecoveredPanicsCount     int64                            `json:"recoveredPanicsCount"`
-	InstanceStartTime        string                           `json:"instanceStartTime"`
-	InstanceCurrentTime      string                           `json:"instanceCurrentTime"`
-	IsGracefullyStopped      bool                             `json:"isGracefullyStopped"`
-	GracefullyStopTime       *string                          `json:"gracefullyStopTime,omitempty"`
-	Hits                     map[string]map[int]RequestMetric `json:"hits"`
+	UnSavedChangesQueueCount   int64                            `json:"unSavedChangesCount"`
+	DbErrorCount               int64                            `json:"dbErrorCount"`
+	RecoveredPanicsCount       int64                            `json:"recoveredPanicsCount"`
+	InstanceStartTime          string                           `json:"instanceStartTime"`
+	InstanceCurrentTime        string                           `json:"instanceCurrentTime"`
+	IsGracefullyStopped        bool                             `json:"isGracefullyStopped"`
+	GracefullyStopTime         *string                          `json:"gracefullyStopTime,omitempty"`
+	CurrencyRatesReloadTime    *string                          `json:"currencyRatesReloadTime,omitempty"`
+	LockedAccountsCount        int                              `json:"lockedAccountsCount"` //временно заблокированы после неудачных попыток входа
+	LockedIpsCount             int                              `json:"lockedIpsCount"`
+	LockedRegistrationIpsCount int                              `json:"lockedRegistrationIpsCount"` //после неудачных регистраций
+	Hits                       map[string]map[int]RequestMetric `json:"hits"`
 }
 
 type RequestMetric struct {
@@ -32,6 +37,7 @@ type Result struct {
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Device   string `json:"device,omitempty"` //название устройства для списка сессий
 }
 
 type RegisterRequest struct {
@@ -56,6 +62,99 @@ type CreateAdvRequest struct {
 	City         string  `json:"city,omitempty"`
 	Address      string  `json:"address,omitempty"`
 	UserComment  string  `json:"userComment,omitempty"`
+	DealType     int8    `json:"dealType,omitempty"`   //1 продажа, 2 аренда
+	RentPeriod   int8    `json:"rentPeriod,omitempty"` //1 посуточно, 2 помесячно, только для аренды
+	PropertyType int8    `json:"propertyType,omitempty"`
+	Rooms        int8    `json:"rooms,omitempty"`
+	Floor        int16   `json:"floor,omitempty"`
+	Floors       int16   `json:"floors,omitempty"`
+	YearBuilt    int16   `json:"yearBuilt,omitempty"`
+	TotalArea    float64 `json:"totalArea,omitempty"`
+	LivingArea   float64 `json:"livingArea,omitempty"`
+}
+
+type SessionResponseItem struct {
+	Id        int64     `json:"id"`
+	Device    string    `json:"device,omitempty"`
+	Ip        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Current   bool      `json:"current"` //сессия, с которой сделан запрос
+}
+
+type GetSessionListResponse struct {
+	List []*SessionResponseItem `json:"list"`
+}
+
+type CreateApiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"` //read, adv-write, photos
+}
+
+type CreateApiKeyResponse struct {
+	Id        int64  `json:"id"`
+	Key       string `json:"key"` //показывается только один раз
+	RequestId int64  `json:"requestId"`
+}
+
+type ApiKeyResponseItem struct {
+	Id         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Created    time.Time  `json:"created"`
+	LastUsed   *time.Time `json:"lastUsed,omitempty"`
+	LastUsedIp string     `json:"lastUsedIp,omitempty"`
+}
+
+type GetApiKeyListResponse struct {
+	List []*ApiKeyResponseItem `json:"list"`
+}
+
+type CreateInviteRequest struct {
+	Company string    `json:"company,omitempty"`
+	MaxUses int64     `json:"maxUses,omitempty"` //0 - без ограничений
+	Expires time.Time `json:"expires,omitempty"` //не указано - бессрочное
+}
+
+type CreateInviteResponse struct {
+	InviteId  string `json:"inviteId"`
+	RequestId int64  `json:"requestId"`
+}
+
+type InviteUser struct {
+	Id    int64  `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type InviteResponseItem struct {
+	Id        string        `json:"id"`
+	Company   string        `json:"company,omitempty"`
+	CreatedBy int64         `json:"createdBy"`
+	MaxUses   int64         `json:"maxUses"`
+	Uses      int64         `json:"uses"`
+	Created   time.Time     `json:"created"`
+	Expires   *time.Time    `json:"expires,omitempty"`
+	Revoked   bool          `json:"revoked"`
+	Active    bool          `json:"active"` //по приглашению еще можно зарегистрироваться
+	Users     []*InviteUser `json:"users"`
+}
+
+type GetInviteListResponse struct {
+	Count int                   `json:"count"`
+	List  []*InviteResponseItem `json:"list"`
+}
+
+// DuplicateCluster оригинал и объявления, признанные его повторами
+type DuplicateCluster struct {
+	Original   *GetAdvResponseItem   `json:"original"`
+	Duplicates []*GetAdvResponseItem `json:"duplicates"`
+}
+
+type GetDuplicatesResponse struct {
+	Count    int                 `json:"count"`
+	Clusters []*DuplicateCluster `json:"clusters"`
 }
 
 type CreateAdvResponse struct {
@@ -68,53 +167,168 @@ type AddPhotoRequest struct {
 }
 
 type GetAdvListRequest struct {
-	FirstNew     bool    `json:"firstNew,omitempty"`
-	Page         int     `json:"page,omitempty"`
-	MinPrice     int64   `json:"minPrice,omitempty"`
-	MaxPrice     int64   `json:"maxPrice,omitempty"`
-	MinLongitude float64 `json:"minLongitude,omitempty"`
-	MaxLongitude float64 `json:"maxLongitude,omitempty"`
-	MinLatitude  float64 `json:"minLatitude,omitempty"`
-	MaxLatitude  float64 `json:"maxLatitude,omitempty"`
-	Currency     string  `json:"currency,omitempty"`
-	CountryCode  string  `json:"countryCode,omitempty"`
-	Location     string  `json:"location,omitempty"`
+	FirstNew        bool             `json:"firstNew,omitempty"`
+	Page            int              `json:"page,omitempty"`
+	Limit           int              `json:"limit,omitempty"`
+	MinPrice        int64            `json:"minPrice,omitempty"`
+	MaxPrice        int64            `json:"maxPrice,omitempty"`
+	MinLongitude    float64          `json:"minLongitude,omitempty"`
+	MaxLongitude    float64          `json:"maxLongitude,omitempty"`
+	MinLatitude     float64          `json:"minLatitude,omitempty"`
+	MaxLatitude     float64          `json:"maxLatitude,omitempty"`
+	Latitude        float64          `json:"lat,omitempty"`
+	Longitude       float64          `json:"lon,omitempty"`
+	RadiusKm        float64          `json:"radiusKm,omitempty"`
+	Currency        string           `json:"currency,omitempty"`
+	CountryCode     string           `json:"countryCode,omitempty"`
+	Location        string           `json:"location,omitempty"`
+	Query           string           `json:"q,omitempty"`
+	DisplayCurrency string           `json:"displayCurrency,omitempty"`
+	Sort            string           `json:"sort,omitempty"`
+	Cursor          string           `json:"cursor,omitempty"`
+	Facets          bool             `json:"facets,omitempty"`
+	Area            *GeoJSONGeometry `json:"area,omitempty"` //в GET передается как JSON в параметре area
+	DealType        int8             `json:"dealType,omitempty"`
+	RentPeriod      int8             `json:"rentPeriod,omitempty"`
+	PropertyType    int8             `json:"propertyType,omitempty"`
+	MinRooms        int8             `json:"minRooms,omitempty"`
+	MaxRooms        int8             `json:"maxRooms,omitempty"`
+	MinFloor        int16            `json:"minFloor,omitempty"`
+	MaxFloor        int16            `json:"maxFloor,omitempty"`
+	MinFloors       int16            `json:"minFloors,omitempty"`
+	MaxFloors       int16            `json:"maxFloors,omitempty"`
+	MinYearBuilt    int16            `json:"minYearBuilt,omitempty"`
+	MaxYearBuilt    int16            `json:"maxYearBuilt,omitempty"`
+	MinTotalArea    float64          `json:"minTotalArea,omitempty"`
+	MaxTotalArea    float64          `json:"maxTotalArea,omitempty"`
+	MinLivingArea   float64          `json:"minLivingArea,omitempty"`
+	MaxLivingArea   float64          `json:"maxLivingArea,omitempty"`
+}
+
+// GetAdvClustersRequest фильтры те же, что у списка объявлений, поля страницы и сортировки не используются
+type GetAdvClustersRequest struct {
+	GetAdvListRequest
+	Zoom int       `json:"zoom"`
+	Bbox []float64 `json:"bbox"` //minLon,minLat,maxLon,maxLat как в GeoJSON, в GET через запятую
+}
+
+type AdvCluster struct {
+	Latitude       float64 `json:"latitude"` //центроид объявлений кластера
+	Longitude      float64 `json:"longitude"`
+	Count          int     `json:"count"`
+	MinDollarPrice int64   `json:"minDollarPrice"`
+	MaxDollarPrice int64   `json:"maxDollarPrice"`
+	AdvId          int64   `json:"advId,omitempty"` //только для кластера из одного объявления
+}
+
+type GetAdvClustersResponse struct {
+	Count    int           `json:"count"`
+	Clusters []*AdvCluster `json:"clusters"`
+}
+
+// GeoJSONGeometry geometry из GeoJSON, для поиска поддерживаются Polygon и MultiPolygon
+type GeoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
 }
 
 type GetUserAdvListRequest struct {
-	Page     int  `json:"page,omitempty"`
-	FirstNew bool `json:"firstNew,omitempty"`
+	Page     int    `json:"page,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+	FirstNew bool   `json:"firstNew,omitempty"`
+	Sort     string `json:"sort,omitempty"`
+	Cursor   string `json:"cursor,omitempty"`
+}
+
+// SavedSearchRequest в Filter не сохраняются page, limit, cursor и facets
+type SavedSearchRequest struct {
+	Name   string             `json:"name"`
+	Filter *GetAdvListRequest `json:"filter"`
+}
+
+type CreateSavedSearchResponse struct {
+	SearchId  int64 `json:"searchId"`
+	RequestId int64 `json:"requestId"`
+}
+
+type SavedSearchResponseItem struct {
+	Id      int64              `json:"id"`
+	Updated time.Time          `json:"updated"`
+	Name    string             `json:"name"`
+	Filter  *GetAdvListRequest `json:"filter"`
+}
+
+type GetSavedSearchListResponse struct {
+	List []*SavedSearchResponseItem `json:"list"`
 }
 
 type GetAdvResponseItem struct {
-	Id           int64     `json:"id,omitempty"`
-	Price        int64     `json:"price,omitempty"`
-	DollarPrice  int64     `json:"dollarPrice,omitempty"` //не хранится в БД
-	Watches      int64     `json:"watches,omitempty"`
-	Latitude     float64   `json:"latitude,omitempty"`
-	Longitude    float64   `json:"longitude,omitempty"`
-	Approved     bool      `json:"approved,omitempty"`
-	SeVisible    bool      `json:"seVisible,omitempty"`
-	Lang         int8      `json:"lang,omitempty"`
-	OriginLang   int8      `json:"originLang,omitempty"`
-	TranslatedBy int8      `json:"translatedBy,omitempty"`
-	Created      time.Time `json:"created"`
-	Updated      time.Time `json:"updated"`
-	UserEmail    string    `json:"userEmail,omitempty"`
-	UserName     string    `json:"userName,omitempty"`
-	Title        string    `json:"title,omitempty"`
-	Description  string    `json:"description,omitempty"`
-	Currency     string    `json:"currency,omitempty"`
-	Country      string    `json:"country,omitempty"`
-	City         string    `json:"city,omitempty"`
-	Address      string    `json:"address,omitempty"`
-	UserComment  string    `json:"userComment,omitempty"`
-	Photos       []string  `json:"photos,omitempty"`
+	Id                    int64     `json:"id,omitempty"`
+	Price                 int64     `json:"price,omitempty"`
+	DollarPrice           int64     `json:"dollarPrice,omitempty"` //не хранится в БД
+	DisplayPrice          int64     `json:"displayPrice,omitempty"`
+	Watches               int64     `json:"watches,omitempty"`
+	DuplicateOf           int64     `json:"duplicateOf,omitempty"` //id оригинала, если объявление признано повтором
+	Latitude              float64   `json:"latitude,omitempty"`
+	Longitude             float64   `json:"longitude,omitempty"`
+	Distance              float64   `json:"distance,omitempty"`   //км, только при поиске по радиусу
+	Relevance             float64   `json:"relevance,omitempty"`  //только при поиске по q
+	Similarity            float64   `json:"similarity,omitempty"` //только в похожих объявлениях, от 0 до 1
+	TotalArea             float64   `json:"totalArea,omitempty"`
+	LivingArea            float64   `json:"livingArea,omitempty"`
+	Floor                 int16     `json:"floor,omitempty"`
+	Floors                int16     `json:"floors,omitempty"`
+	YearBuilt             int16     `json:"yearBuilt,omitempty"`
+	DealType              int8      `json:"dealType,omitempty"`
+	RentPeriod            int8      `json:"rentPeriod,omitempty"`
+	PropertyType          int8      `json:"propertyType,omitempty"`
+	Rooms                 int8      `json:"rooms,omitempty"`
+	Approved              bool      `json:"approved,omitempty"`
+	SeVisible             bool      `json:"seVisible,omitempty"`
+	Lang                  int8      `json:"lang,omitempty"`
+	OriginLang            int8      `json:"originLang,omitempty"`
+	TranslatedBy          int8      `json:"translatedBy,omitempty"`
+	Created               time.Time `json:"created"`
+	Updated               time.Time `json:"updated"`
+	UserEmail             string    `json:"userEmail,omitempty"`
+	UserName              string    `json:"userName,omitempty"`
+	Title                 string    `json:"title,omitempty"`
+	Description           string    `json:"description,omitempty"`
+	Currency              string    `json:"currency,omitempty"`
+	Country               string    `json:"country,omitempty"`
+	City                  string    `json:"city,omitempty"`
+	Address               string    `json:"address,omitempty"`
+	UserComment           string    `json:"userComment,omitempty"`
+	DisplayCurrency       string    `json:"displayCurrency,omitempty"`
+	DisplayPriceFormatted string    `json:"displayPriceFormatted,omitempty"`
+	SimilarUrl            string    `json:"similarUrl,omitempty"` //только в GET /adv/{advId}
+	Photos                []string  `json:"photos,omitempty"`
 }
 
 type GetAdvListResponse struct {
-	Count int                   `json:"count"`
-	List  []*GetAdvResponseItem `json:"list"`
+	Count      int                   `json:"count"`
+	List       []*GetAdvResponseItem `json:"list"`
+	NextCursor string                `json:"nextCursor,omitempty"` //пустой, если страница последняя
+	Facets     *AdvFacets            `json:"facets,omitempty"`
+}
+
+// AdvFacets количество подходящих под фильтр объявлений в разрезе полей, по убыванию количества
+type AdvFacets struct {
+	Countries  []*FacetCount       `json:"countries"`
+	Cities     []*FacetCount       `json:"cities"`
+	Currencies []*FacetCount       `json:"currencies"`
+	Prices     []*PriceBucketCount `json:"prices"` //по возрастанию цены
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type PriceBucketCount struct {
+	MinDollarPrice int64 `json:"minDollarPrice"`
+	MaxDollarPrice int64 `json:"maxDollarPrice,omitempty"` //не включительно, не задана у последнего интервала
+	Count          int   `json:"count"`
 }
 
 type UpdateAdvRequest struct {
@@ -132,6 +346,15 @@ type UpdateAdvRequest struct {
 	City         string  `json:"city,omitempty"`
 	Address      string  `json:"address,omitempty"`
 	UserComment  string  `json:"userComment,omitempty"`
+	DealType     int8    `json:"dealType,omitempty"`   //1 продажа, 2 аренда
+	RentPeriod   int8    `json:"rentPeriod,omitempty"` //1 посуточно, 2 помесячно, только для аренды
+	PropertyType int8    `json:"propertyType,omitempty"`
+	Rooms        int8    `json:"rooms,omitempty"`
+	Floor        int16   `json:"floor,omitempty"`
+	Floors       int16   `json:"floors,omitempty"`
+	YearBuilt    int16   `json:"yearBuilt,omitempty"`
+	TotalArea    float64 `json:"totalArea,omitempty"`
+	LivingArea   float64 `json:"livingArea,omitempty"`
 }
 
 type UpdateUserRequest struct {
@@ -144,6 +367,15 @@ type UpdatePasswordRequest struct {
 	NewPassword string `json:"newPassword,omitempty"`
 }
 
+type ForgotPasswordRequest struct {
+	Email string `json:"email,omitempty"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token,omitempty"`
+	NewPassword string `json:"newPassword,omitempty"`
+}
+
 type Err struct {
 	RequestId  int64  `json:"requestId,omitempty"`
 	ErrMessage string `json:"errMessage,omitempty"`
@@ -0,0 +1,93 @@
+package fulltext
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// minStemLength минимальная длина основы в рунах, короче окончания не отрезаются
+const minStemLength = 3
+
+// russianEndings падежные и родовые окончания, самые длинные проверяются первыми
+var russianEndings = []string{
+	"остями", "остью", "ости", "ость",
+	"иями", "ями", "ами", "иях", "ией", "ием", "ого", "его", "ому", "ему", "ыми", "ими",
+	"ах", "ях", "ам", "ям", "ом", "ем", "ой", "ей", "ий", "ый", "ая", "яя", "ое", "ее",
+	"ые", "ие", "ую", "юю", "ов", "ев", "ых", "их", "ия", "ью",
+	"а", "я", "о", "е", "ы", "и", "у", "ю", "ь", "й",
+}
+
+func init() {
+	slices.SortStableFunc(russianEndings, func(a, b string) int {
+		return utf8.RuneCountInString(b) - utf8.RuneCountInString(a)
+	})
+}
+
+// Tokenize разбивает текст на слова, приводит их к нижнему регистру и отрезает окончания.
+// Однобуквенные слова отбрасываются, числа сохраняются
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.ReplaceAll(strings.ToLower(word), "ё", "е")
+		if utf8.RuneCountInString(word) < 2 && !isNumber(word) {
+			continue
+		}
+		terms = append(terms, Stem(word))
+	}
+	return terms
+}
+
+// Stem облегченный стемминг для русских и английских слов, слово должно быть в нижнем регистре
+func Stem(word string) string {
+	for _, r := range word {
+		if unicode.Is(unicode.Cyrillic, r) {
+			return stemRussian(word)
+		}
+		if r >= 'a' && r <= 'z' {
+			return stemEnglish(word)
+		}
+	}
+	return word
+}
+
+func stemRussian(word string) string {
+	length := utf8.RuneCountInString(word)
+	for _, ending := range russianEndings {
+		if strings.HasSuffix(word, ending) && length-utf8.RuneCountInString(ending) >= minStemLength {
+			return strings.TrimSuffix(word, ending)
+		}
+	}
+	return word
+}
+
+func stemEnglish(word string) string {
+	length := len(word)
+	switch {
+	case length > 4 && strings.HasSuffix(word, "ies"):
+		return word[:length-3] + "y"
+	case strings.HasSuffix(word, "sses"), strings.HasSuffix(word, "xes"),
+		strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "shes"):
+		return word[:length-2]
+	case length > 5 && strings.HasSuffix(word, "ing"):
+		return word[:length-3]
+	case length > 4 && strings.HasSuffix(word, "ed"):
+		return word[:length-2]
+	case length > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us"):
+		return word[:length-1]
+	}
+	return word
+}
+
+func isNumber(word string) bool {
+	for _, r := range word {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return word != ""
+}
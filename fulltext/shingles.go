@@ -0,0 +1,39 @@
+package fulltext
+
+import (
+	"hash/fnv"
+	"strings"
+)
+
+// Shingles множество хешей последовательностей из size подряд идущих слов.
+// Если слов меньше size, шинглом считается весь текст
+func Shingles(terms []string, size int) map[uint64]struct{} {
+	result := make(map[uint64]struct{}, max(len(terms)-size+1, 1))
+	if len(terms) == 0 {
+		return result
+	}
+	hash := fnv.New64a()
+	for i := 0; i+size <= len(terms) || i == 0; i++ {
+		hash.Reset()
+		_, _ = hash.Write([]byte(strings.Join(terms[i:min(i+size, len(terms))], " ")))
+		result[hash.Sum64()] = struct{}{}
+	}
+	return result
+}
+
+// Jaccard отношение размера пересечения множеств к размеру объединения, для пустых множеств 0
+func Jaccard(a, b map[uint64]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	var intersection int
+	for shingle := range a {
+		if _, ok := b[shingle]; ok {
+			intersection++
+		}
+	}
+	return float64(intersection) / float64(len(a)+len(b)-intersection)
+}
@@ -0,0 +1,106 @@
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxAreaPoints ограничение на число вершин, чтобы проверка точки оставалась дешевой
+const maxAreaPoints = 10_000
+
+// Area область из GeoJSON Polygon или MultiPolygon.
+// Полигоны, пересекающие 180-й меридиан, должны быть разрезаны по нему, как требует RFC 7946
+type Area struct {
+	//полигон - внешнее кольцо и дыры, кольцо - замкнутый список [долгота, широта]
+	polygons     [][][][2]float64
+	minLatitude  float64
+	maxLatitude  float64
+	minLongitude float64
+	maxLongitude float64
+}
+
+// ParseArea разбирает coordinates геометрии GeoJSON типа Polygon или MultiPolygon
+func ParseArea(geometryType string, coordinates json.RawMessage) (*Area, error) {
+	area := &Area{
+		minLatitude:  math.Inf(1),
+		maxLatitude:  math.Inf(-1),
+		minLongitude: math.Inf(1),
+		maxLongitude: math.Inf(-1),
+	}
+	switch geometryType {
+	case "Polygon":
+		polygon := make([][][2]float64, 0)
+		if err := json.Unmarshal(coordinates, &polygon); err != nil {
+			return nil, errors.New("invalid Polygon coordinates")
+		}
+		area.polygons = [][][][2]float64{polygon}
+	case "MultiPolygon":
+		if err := json.Unmarshal(coordinates, &area.polygons); err != nil {
+			return nil, errors.New("invalid MultiPolygon coordinates")
+		}
+	default:
+		return nil, fmt.Errorf("type must be Polygon or MultiPolygon")
+	}
+	if len(area.polygons) == 0 {
+		return nil, errors.New("area has no polygons")
+	}
+	var count int
+	for _, polygon := range area.polygons {
+		if len(polygon) == 0 {
+			return nil, errors.New("polygon has no rings")
+		}
+		for _, ring := range polygon {
+			if len(ring) < 4 {
+				return nil, errors.New("ring must have at least 4 positions")
+			}
+			if ring[0] != ring[len(ring)-1] {
+				return nil, errors.New("ring must be closed")
+			}
+			count += len(ring)
+			if count > maxAreaPoints {
+				return nil, fmt.Errorf("area must have at most %d positions", maxAreaPoints)
+			}
+			for _, point := range ring {
+				if point[0] < -180 || point[0] > 180 || point[1] < -90 || point[1] > 90 {
+					return nil, fmt.Errorf("invalid position %v", point)
+				}
+				area.minLongitude = math.Min(area.minLongitude, point[0])
+				area.maxLongitude = math.Max(area.maxLongitude, point[0])
+				area.minLatitude = math.Min(area.minLatitude, point[1])
+				area.maxLatitude = math.Max(area.maxLatitude, point[1])
+			}
+		}
+	}
+	return area, nil
+}
+
+// BoundingBox прямоугольник, в который попадает вся область
+func (a *Area) BoundingBox() (minLatitude, maxLatitude, minLongitude, maxLongitude float64) {
+	return a.minLatitude, a.maxLatitude, a.minLongitude, a.maxLongitude
+}
+
+// Contains проверяет попадание точки в область методом трассировки луча.
+// Дыры учитываются правилом чет-нечет по всем кольцам полигона
+func (a *Area) Contains(latitude, longitude float64) bool {
+	if latitude < a.minLatitude || latitude > a.maxLatitude || longitude < a.minLongitude || longitude > a.maxLongitude {
+		return false
+	}
+	for _, polygon := range a.polygons {
+		var inside bool
+		for _, ring := range polygon {
+			for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+				lon1, lat1 := ring[i][0], ring[i][1]
+				lon2, lat2 := ring[j][0], ring[j][1]
+				if (lat1 > latitude) != (lat2 > latitude) && longitude < (lon2-lon1)*(latitude-lat1)/(lat2-lat1)+lon1 {
+					inside = !inside
+				}
+			}
+		}
+		if inside {
+			return true
+		}
+	}
+	return false
+}
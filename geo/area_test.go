@@ -0,0 +1,58 @@
+package geo
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAreaContains(t *testing.T) {
+	//квадрат 0..10 с дырой 4..6 и отдельный треугольник
+	area, err := ParseArea("MultiPolygon", json.RawMessage(`[
+		[[[0,0],[10,0],[10,10],[0,10],[0,0]], [[4,4],[6,4],[6,6],[4,6],[4,4]]],
+		[[[20,20],[30,20],[20,30],[20,20]]]
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		latitude  float64
+		longitude float64
+		inside    bool
+	}{
+		{1, 1, true},
+		{5, 5, false},
+		{3, 5, true},
+		{11, 5, false},
+		{21, 21, true},
+		{29, 29, false},
+		{-1, 5, false},
+	}
+	for _, c := range cases {
+		if got := area.Contains(c.latitude, c.longitude); got != c.inside {
+			t.Errorf("Contains(%v, %v) = %v, want %v", c.latitude, c.longitude, got, c.inside)
+		}
+	}
+	minLatitude, maxLatitude, minLongitude, maxLongitude := area.BoundingBox()
+	if minLatitude != 0 || maxLatitude != 30 || minLongitude != 0 || maxLongitude != 30 {
+		t.Errorf("wrong bounding box: %v %v %v %v", minLatitude, maxLatitude, minLongitude, maxLongitude)
+	}
+}
+
+func TestParseAreaErrors(t *testing.T) {
+	cases := []struct {
+		geometryType string
+		coordinates  string
+	}{
+		{"Point", `[1,2]`},
+		{"Polygon", `[]`},
+		{"Polygon", `[[[0,0],[1,0],[1,1],[0,1]]]`},
+		{"Polygon", `[[[0,0],[1,0],[0,0]]]`},
+		{"Polygon", `[[[0,0],[200,0],[1,1],[0,0]]]`},
+		{"MultiPolygon", `[[[0,0],[1,0],[1,1],[0,0]]]`},
+	}
+	for _, c := range cases {
+		if _, err := ParseArea(c.geometryType, json.RawMessage(c.coordinates)); err == nil {
+			t.Errorf("%s %s: error expected", c.geometryType, c.coordinates)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package geo
+
+import "math"
+
+const EarthRadiusKm = 6371.0
+
+// kmPerDegree длина одного градуса меридиана
+const kmPerDegree = math.Pi * EarthRadiusKm / 180
+
+// Distance расстояние по большому кругу между двумя точками в км (формула гаверсинусов)
+func Distance(latitude1, longitude1, latitude2, longitude2 float64) float64 {
+	lat1 := latitude1 * math.Pi / 180
+	lat2 := latitude2 * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (longitude2 - longitude1) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// RadiusBox прямоугольник, в который целиком попадает круг радиусом radiusKm.
+// Если круг пересекает 180-й меридиан, то minLongitude > maxLongitude
+func RadiusBox(latitude, longitude, radiusKm float64) (minLatitude, maxLatitude, minLongitude, maxLongitude float64) {
+	dLat := radiusKm / kmPerDegree
+	minLatitude = latitude - dLat
+	maxLatitude = latitude + dLat
+	if minLatitude <= -90 || maxLatitude >= 90 {
+		//круг захватывает полюс, подходят все долготы
+		return math.Max(minLatitude, -90), math.Min(maxLatitude, 90), -180, 180
+	}
+	//на краях круга широта больше по модулю, поэтому берем худший случай
+	maxAbsLatitude := math.Max(math.Abs(minLatitude), math.Abs(maxLatitude))
+	dLon := radiusKm / (kmPerDegree * math.Cos(maxAbsLatitude*math.Pi/180))
+	if dLon >= 180 {
+		return minLatitude, maxLatitude, -180, 180
+	}
+	minLongitude = NormalizeLongitude(longitude - dLon)
+	maxLongitude = NormalizeLongitude(longitude + dLon)
+	return minLatitude, maxLatitude, minLongitude, maxLongitude
+}
+
+// NormalizeLongitude приводит долготу к диапазону [-180, 180]
+func NormalizeLongitude(longitude float64) float64 {
+	for longitude > 180 {
+		longitude -= 360
+	}
+	for longitude < -180 {
+		longitude += 360
+	}
+	return longitude
+}
+
+// InLongitudeRange проверяет попадание долготы в диапазон, который может пересекать 180-й меридиан
+func InLongitudeRange(longitude, minLongitude, maxLongitude float64) bool {
+	if minLongitude <= maxLongitude {
+		return longitude > minLongitude && longitude < maxLongitude
+	}
+	return longitude > minLongitude || longitude < maxLongitude
+}
@@ -1,11 +1,13 @@
 module realty
 
-go 1.22
+go 1.24
 
-require modernc.org/sqlite v1.30.1
+require (
+	github.com/dustin/go-humanize v1.0.1
+	modernc.org/sqlite v1.30.1
+)
 
 require (
-	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
@@ -0,0 +1,158 @@
+package mailer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/smtp"
+	"os"
+	"realty/config"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Message struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	Created time.Time `json:"created"`
+}
+
+// Mailer отправитель писем пользователям
+type Mailer interface {
+	Send(message *Message) error
+}
+
+// SMTPMailer отправляет письма через SMTP сервер, авторизация только если указан Username
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+// FileMailer дописывает письма в файл по одному JSON на строку, для локального запуска без почтового сервера
+type FileMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// MemoryMailer хранит письма в памяти, для тестов
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+var mailer atomic.Pointer[Mailer]
+
+func Initialize() {
+	m, err := NewMailer(config.GetMailer())
+	if err != nil {
+		panic(err)
+	}
+	SetMailer(m)
+}
+
+func NewMailer(kind string) (Mailer, error) {
+	switch kind {
+	case "memory":
+		return &MemoryMailer{}, nil
+	case "file":
+		return &FileMailer{Path: config.GetMailOutboxFilepath()}, nil
+	case "smtp":
+		if config.GetSmtpHost() == "" {
+			return nil, errors.New("mailer: SMTP_HOST is required")
+		}
+		return &SMTPMailer{
+			Addr:     config.GetSmtpAddr(),
+			Host:     config.GetSmtpHost(),
+			Username: config.GetSmtpUsername(),
+			Password: config.GetSmtpPassword(),
+			From:     config.GetMailFrom(),
+		}, nil
+	default:
+		return nil, fmt.Errorf("mailer: unknown mailer %q", kind)
+	}
+}
+
+func SetMailer(m Mailer) {
+	mailer.Store(&m)
+}
+
+func GetMailer() Mailer {
+	if m := mailer.Load(); m != nil {
+		return *m
+	}
+	return nil
+}
+
+func Send(message *Message) error {
+	m := GetMailer()
+	if m == nil {
+		return errors.New("mailer: mailer is not initialized")
+	}
+	if err := m.Send(message); err != nil {
+		return err
+	}
+	slog.Debug("mailer", "to", message.To, "subject", message.Subject)
+	return nil
+}
+
+func (m *SMTPMailer) Send(message *Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	var data strings.Builder
+	data.WriteString("From: " + m.From + "\r\n")
+	data.WriteString("To: " + message.To + "\r\n")
+	data.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", message.Subject) + "\r\n")
+	data.WriteString("Date: " + message.Created.Format(time.RFC1123Z) + "\r\n")
+	data.WriteString("MIME-Version: 1.0\r\n")
+	data.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	data.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	data.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	if err := smtp.SendMail(m.Addr, auth, m.From, []string{message.To}, []byte(data.String())); err != nil {
+		return errors.Join(err, errors.New("mailer.SMTPMailer.Send()"))
+	}
+	return nil
+}
+
+func (m *FileMailer) Send(message *Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return errors.Join(err, errors.New("mailer.FileMailer.Send()"))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Join(err, errors.New("mailer.FileMailer.Send()"))
+	}
+	defer file.Close()
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		return errors.Join(err, errors.New("mailer.FileMailer.Send()"))
+	}
+	return nil
+}
+
+func (m *MemoryMailer) Send(message *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Take возвращает накопленные письма и очищает outbox
+func (m *MemoryMailer) Take() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := m.messages
+	m.messages = nil
+	return result
+}
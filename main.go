@@ -4,9 +4,14 @@ import (
 	"log"
 	"log/slog"
 	"net/http"
+	"realty/auth_token"
 	"realty/cache"
 	"realty/config"
+	"realty/currency"
 	"realty/db"
+	"realty/mailer"
+	"realty/notify"
+	"realty/ratelimit"
 	"realty/router"
 	"time"
 )
@@ -14,9 +19,14 @@ import (
 func main() {
 	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime)
 	config.Initialize()
+	auth_token.Initialize()
 	slog.SetLogLoggerLevel(config.GetLogLevel())
 	slog.Info("START", "time", time.Now().Format("2006/01/02 15:04:05"))
 	db.Initialize()
+	currency.Initialize()
+	notify.Initialize()
+	mailer.Initialize()
+	ratelimit.Initialize()
 	cache.Initialize()
 	mux := router.Initialize()
 	log.Fatal(http.ListenAndServe(config.GetHttpServerPort(), mux))
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,17 +12,26 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"realty/application"
 	"realty/auth_token"
 	"realty/cache"
 	"realty/config"
+	"realty/currency"
 	"realty/db"
 	"realty/dto"
+	"realty/mailer"
+	"realty/models"
 	"realty/moderation"
+	"realty/notify"
+	"realty/ratelimit"
 	"realty/render"
 	"realty/router"
+	"realty/utils"
 	"realty/validator"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -41,9 +52,19 @@ const newPassword = "123456789"
 func init() {
 	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime)
 	slog.Info("start", "time", time.Now().Format("2006/01/02 15:04:05"))
+	_ = os.Setenv("CURRENCY_RATES_FILEPATH", "./data/currency.json")
+	_ = os.Setenv("PASSWORD_HASH_ITERATIONS", "1000")
+	_ = os.Setenv("MAILER", "memory")
+	_ = os.Setenv("NOTIFY_SINK", "memory")
+	_ = os.Setenv("AUTH_TOKEN_KEYS", "1:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
 	config.Initialize()
+	auth_token.Initialize()
 	slog.SetLogLoggerLevel(config.GetLogLevel())
 	db.Initialize()
+	currency.Initialize()
+	notify.Initialize()
+	mailer.Initialize()
+	ratelimit.Initialize()
 	cache.Initialize()
 	mux = router.Initialize()
 	resultOKBytes, _ := json.Marshal(render.ResultOK)
@@ -148,6 +169,7 @@ func TestRegistration(t *testing.T) {
 		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
 	}
 	time.Sleep(timeSleepMs * time.Millisecond)
+	verifyEmail(t, userEmail)
 }
 
 func TestLogin(t *testing.T) {
@@ -175,6 +197,10 @@ func TestLogin(t *testing.T) {
 }
 
 func TestUpdatePassword(t *testing.T) {
+	_, apiKey, err := cache.CreateApiKey(0, cache.FindUserCacheByLogin(userEmail).CurrentUser.Id, "password", models.ApiScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
 	req, err := NewRequest("PUT", H{"Cookie": cookie}, "/password", nil, nil, &dto.UpdatePasswordRequest{
 		OldPassword: password,
 		NewPassword: newPassword,
@@ -194,6 +220,9 @@ func TestUpdatePassword(t *testing.T) {
 	if rr.Body.String() != expected {
 		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
 	}
+	if status := apiKeyStatus(apiKey); status != http.StatusUnauthorized {
+		t.Errorf("api key after password change: wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
 	time.Sleep(timeSleepMs * time.Millisecond)
 }
 
@@ -301,6 +330,14 @@ func TestUpdateAdv(t *testing.T) {
 		Latitude:     2,
 		Longitude:    34,
 		UserComment:  "",
+		DealType:     models.DealTypeSale,
+		PropertyType: models.PropertyTypeFlat,
+		Rooms:        2,
+		Floor:        3,
+		Floors:       9,
+		YearBuilt:    2005,
+		TotalArea:    54.5,
+		LivingArea:   32,
 	})
 	if err != nil {
 		t.Fatalf("Failed to create request: %v", err)
@@ -366,53 +403,260 @@ func TestGetAdvList(t *testing.T) {
 	time.Sleep(timeSleepMs * time.Millisecond)
 }
 
-func TestGenerateId(t *testing.T) {
-	req, err := NewRequest("GET", H{"Cookie": cookie}, "/generate/id", nil, nil, nil)
-	if err != nil {
-		t.Fatal(err)
+func TestGetAdvListByPrice(t *testing.T) {
+	dollarPrice := currency.CalcDollarPrice("rub", 22220)
+	if dollarPrice == 0 {
+		t.Fatal("dollarPrice == 0")
+	}
+	cases := []struct {
+		query H
+		count int
+	}{
+		{H{"currency": "usd", "page": "1", "minPrice": fmt.Sprint(dollarPrice - 1), "maxPrice": fmt.Sprint(dollarPrice + 1)}, 1},
+		{H{"currency": "USD", "page": "1", "minPrice": fmt.Sprint(dollarPrice + 1)}, 0},
+		{H{"currency": "eur", "page": "1", "maxPrice": "1"}, 0},
+		{H{"currency": "rub", "page": "1", "minPrice": "22000", "maxPrice": "23000"}, 1},
+	}
+	for _, c := range cases {
+		req, err := NewRequest("GET", nil, "/adv", nil, c.query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+
+		var response dto.GetAdvListResponse
+		err = json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Count != c.count {
+			t.Errorf("%v: wrong count value: got %v want %v", c.query, response.Count, c.count)
+		}
 	}
 
+	req, err := NewRequest("GET", nil, "/adv", nil, H{"currency": "xxx", "page": "1"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
 
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+func TestGetAdvListByRadius(t *testing.T) {
+	cases := []struct {
+		query H
+		count int
+	}{
+		{H{"currency": "rub", "page": "1", "lat": "2", "lon": "34.3", "radiusKm": "50", "sort": "distance"}, 1},
+		{H{"currency": "rub", "page": "1", "lat": "2", "lon": "34.3", "radiusKm": "10"}, 0},
+		{H{"currency": "rub", "page": "1", "minLongitude": "30", "maxLongitude": "-170"}, 1},
+		{H{"currency": "rub", "page": "1", "minLongitude": "170", "maxLongitude": "-170"}, 0},
+	}
+	for _, c := range cases {
+		req, err := NewRequest("GET", nil, "/adv", nil, c.query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+
+		var response dto.GetAdvListResponse
+		err = json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Count != c.count {
+			t.Fatalf("%v: wrong count value: got %v want %v", c.query, response.Count, c.count)
+		}
+		if c.query["radiusKm"] != "" && c.count > 0 && (response.List[0].Distance < 33 || response.List[0].Distance > 34) {
+			t.Errorf("%v: wrong distance: %v", c.query, response.List[0].Distance)
+		}
 	}
 
-	var response dto.GenerateIdResponse
-	err = json.NewDecoder(rr.Body).Decode(&response)
-	if err != nil {
-		t.Fatalf("failed to decode response: %v", err)
+	for _, query := range []H{
+		{"currency": "rub", "page": "1", "lat": "2", "lon": "34", "radiusKm": "-1"},
+		{"currency": "rub", "page": "1", "lat": "91", "lon": "34", "radiusKm": "10"},
+		{"currency": "rub", "page": "1", "sort": "distance"},
+	} {
+		req, err := NewRequest("GET", nil, "/adv", nil, query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%v: handler returned wrong status code: got %v want %v", query, status, http.StatusBadRequest)
+		}
 	}
-	if !validator.IsValidUnixNanoId(response.Id) {
-		t.Fatalf("is not valid id: %v", response.Id)
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestGetAdvListByQuery(t *testing.T) {
+	cases := []struct {
+		query H
+		count int
+	}{
+		{H{"currency": "rub", "page": "1", "q": "пентхаус"}, 1},
+		{H{"currency": "rub", "page": "1", "q": "Пентхауса в Москве"}, 1},
+		{H{"currency": "rub", "page": "1", "q": "пентхаусы Казани"}, 0},
+		{H{"currency": "rub", "page": "1", "q": "кукушкину"}, 1},
+		{H{"currency": "rub", "page": "1", "q": "кукушника"}, 0},
+		{H{"currency": "rub", "page": "1", "q": "пентхаус", "countryCode": "NO"}, 0},
+	}
+	for _, c := range cases {
+		req, err := NewRequest("GET", nil, "/adv", nil, c.query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%v: handler returned wrong status code: got %v want %v", c.query, status, http.StatusOK)
+		}
+
+		var response dto.GetAdvListResponse
+		err = json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Count != c.count {
+			t.Fatalf("%v: wrong count value: got %v want %v", c.query, response.Count, c.count)
+		}
+		if c.count > 0 && response.List[0].Relevance <= 0 {
+			t.Errorf("%v: wrong relevance: %v", c.query, response.List[0].Relevance)
+		}
 	}
 
+	req, err := NewRequest("GET", nil, "/adv", nil, H{"currency": "rub", "page": "1", "q": "!!"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
 	time.Sleep(timeSleepMs * time.Millisecond)
 }
 
-func TestAddAdvPhoto(t *testing.T) {
-	req, err := NewRequest("POST", H{"Cookie": cookie}, fmt.Sprintf("/adv/%d/photos", advId), nil, nil, &dto.AddPhotoRequest{Filename: fmt.Sprintf("%d.png", photoId)})
+func TestGetUsersAdvListSort(t *testing.T) {
+	cases := []struct {
+		query  H
+		status int
+	}{
+		{H{"page": "1", "sort": "-price"}, http.StatusOK},
+		{H{"page": "1", "sort": "updated", "firstNew": "true"}, http.StatusOK},
+		{H{"page": "1", "sort": "distance"}, http.StatusBadRequest},
+		{H{"page": "1", "sort": "title"}, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		req, err := NewRequest("GET", H{"Cookie": cookie}, "/user/adv", nil, c.query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if status := rr.Code; status != c.status {
+			t.Fatalf("%v: handler returned wrong status code: got %v want %v", c.query, status, c.status)
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		var response dto.GetAdvListResponse
+		if err = json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Count != 1 || response.List[0].Id != advId {
+			t.Errorf("%v: wrong list: %v", c.query, response.List)
+		}
+	}
+
+	req, err := NewRequest("GET", nil, "/adv", nil, H{"currency": "rub", "page": "1", "sort": "relevance"}, nil)
 	if err != nil {
 		t.Fatalf("Failed to create request: %v", err)
 	}
-
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
 
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+func TestGetAdvListCursor(t *testing.T) {
+	before := (&cache.AdvsCursor{Id: advId - 1}).Encode()
+	after := (&cache.AdvsCursor{Id: advId}).Encode()
+	cases := []struct {
+		query H
+		count int
+		items int
+	}{
+		{H{"currency": "rub", "page": "1", "limit": "1"}, 1, 1},
+		{H{"currency": "rub", "page": "1", "limit": "1000"}, 1, 1},
+		{H{"currency": "rub", "cursor": before}, 1, 1},
+		{H{"currency": "rub", "cursor": after}, 1, 0},
+	}
+	for _, c := range cases {
+		req, err := NewRequest("GET", nil, "/adv", nil, c.query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%v: handler returned wrong status code: got %v want %v", c.query, status, http.StatusOK)
+		}
+
+		var response dto.GetAdvListResponse
+		err = json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Count != c.count || len(response.List) != c.items || response.NextCursor != "" {
+			t.Errorf("%v: wrong response: count %v, items %v, nextCursor %q", c.query, response.Count, len(response.List), response.NextCursor)
+		}
 	}
 
-	expected := resultOKStr // Replace with actual expected response
-	if rr.Body.String() != expected {
-		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	for _, query := range []H{
+		{"currency": "rub", "cursor": "bad"},
+		{"currency": "rub", "cursor": before, "sort": "price"},
+		{"currency": "rub", "page": "1", "limit": "-1"},
+		{"currency": "rub"},
+	} {
+		req, err := NewRequest("GET", nil, "/adv", nil, query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%v: handler returned wrong status code: got %v want %v", query, status, http.StatusBadRequest)
+		}
 	}
 	time.Sleep(timeSleepMs * time.Millisecond)
 }
 
-func TestDeleteAdvPhoto(t *testing.T) {
-	req, err := NewRequest("DELETE", H{"Cookie": cookie}, fmt.Sprintf("/adv/%d/photos/%d", advId, photoId), nil, nil, nil)
+func TestGetAdvListFacets(t *testing.T) {
+	req, err := NewRequest("GET", nil, "/adv", nil, H{"currency": "rub", "page": "1", "facets": "true"}, nil)
 	if err != nil {
 		t.Fatalf("Failed to create request: %v", err)
 	}
@@ -421,113 +665,1574 @@ func TestDeleteAdvPhoto(t *testing.T) {
 	mux.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 
-	expected := resultOKStr // Replace with actual expected response
-	if rr.Body.String() != expected {
-		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	var response dto.GetAdvListResponse
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	facets := response.Facets
+	if facets == nil {
+		t.Fatal("facets not returned")
+	}
+	if len(facets.Countries) != 1 || facets.Countries[0].Value != "Russia" || facets.Countries[0].Count != 1 {
+		t.Errorf("wrong countries facet: %v", facets.Countries)
+	}
+	if len(facets.Cities) != 1 || facets.Cities[0].Value != "Москва" {
+		t.Errorf("wrong cities facet: %v", facets.Cities)
+	}
+	if len(facets.Currencies) != 1 || facets.Currencies[0].Value != "rub" {
+		t.Errorf("wrong currencies facet: %v", facets.Currencies)
+	}
+	if len(facets.Prices) != 1 || facets.Prices[0].MinDollarPrice != 0 || facets.Prices[0].MaxDollarPrice != 1000 {
+		t.Errorf("wrong prices facet: %v", facets.Prices)
 	}
 	time.Sleep(timeSleepMs * time.Millisecond)
 }
 
-func TestDeleteAdv(t *testing.T) {
-	req, err := NewRequest("DELETE", H{"Cookie": cookie}, fmt.Sprintf("/adv/%d", advId), nil, nil, nil)
+func TestGetAdvListByArea(t *testing.T) {
+	around := &dto.GeoJSONGeometry{Type: "Polygon", Coordinates: json.RawMessage(`[[[33,1],[35,1],[35,3],[33,3],[33,1]]]`)}
+	aside := &dto.GeoJSONGeometry{Type: "MultiPolygon", Coordinates: json.RawMessage(`[[[[33,1],[33.5,1],[33.5,3],[33,3],[33,1]]],[[[36,1],[37,1],[37,3],[36,1]]]]`)}
+	areaJson, err := json.Marshal(around)
 	if err != nil {
-		t.Fatalf("Failed to create request: %v", err)
+		t.Fatal(err)
 	}
+	cases := []struct {
+		method string
+		url    string
+		query  H
+		body   any
+		count  int
+	}{
+		{"POST", "/adv/search", nil, &dto.GetAdvListRequest{Currency: "rub", Page: 1, Area: around}, 1},
+		{"POST", "/adv/search", nil, &dto.GetAdvListRequest{Currency: "rub", Page: 1, Area: aside}, 0},
+		{"GET", "/adv", H{"currency": "rub", "page": "1", "area": string(areaJson)}, nil, 1},
+	}
+	for _, c := range cases {
+		req, err := NewRequest(c.method, H{"Content-Type": "application/json"}, c.url, nil, c.query, c.body)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
 
-	rr := httptest.NewRecorder()
-	mux.ServeHTTP(rr, req)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", c.method, status, http.StatusOK)
+		}
+
+		var response dto.GetAdvListResponse
+		err = json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Count != c.count {
+			t.Errorf("%s: wrong count value: got %v want %v", c.method, response.Count, c.count)
+		}
 	}
 
-	expected := resultOKStr // Replace with actual expected response
-	if rr.Body.String() != expected {
-		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	notClosed := &dto.GeoJSONGeometry{Type: "Polygon", Coordinates: json.RawMessage(`[[[33,1],[35,1],[35,3],[33,3]]]`)}
+	req, err := NewRequest("POST", H{"Content-Type": "application/json"}, "/adv/search", nil, nil, &dto.GetAdvListRequest{Currency: "rub", Page: 1, Area: notClosed})
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
 	}
 	time.Sleep(timeSleepMs * time.Millisecond)
 }
 
-func TestLogoutMe(t *testing.T) {
-	req, err := NewRequest("GET", H{"Cookie": cookie}, "/logout/me", nil, nil, nil) //todo
+func TestGetAdvListByAttributes(t *testing.T) {
+	cases := []struct {
+		query H
+		count int
+	}{
+		{H{"currency": "rub", "page": "1", "dealType": "1", "propertyType": "1"}, 1},
+		{H{"currency": "rub", "page": "1", "dealType": "2"}, 0},
+		{H{"currency": "rub", "page": "1", "minRooms": "2", "maxRooms": "3", "minTotalArea": "50"}, 1},
+		{H{"currency": "rub", "page": "1", "minRooms": "3"}, 0},
+		{H{"currency": "rub", "page": "1", "maxTotalArea": "50"}, 0},
+		{H{"currency": "rub", "page": "1", "minFloor": "2", "maxFloor": "5", "maxFloors": "9"}, 1},
+		{H{"currency": "rub", "page": "1", "minYearBuilt": "2010"}, 0},
+		{H{"currency": "rub", "page": "1", "minLivingArea": "30", "maxYearBuilt": "2005"}, 1},
+	}
+	for _, c := range cases {
+		req, err := NewRequest("GET", nil, "/adv", nil, c.query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+
+		var response dto.GetAdvListResponse
+		err = json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Count != c.count {
+			t.Errorf("%v: wrong count value: got %v want %v", c.query, response.Count, c.count)
+		}
+		if response.Count == 1 && (response.List[0].Rooms != 2 || response.List[0].TotalArea != 54.5) {
+			t.Errorf("%v: attributes not returned: %+v", c.query, response.List[0])
+		}
+	}
+
+	for _, query := range []H{
+		{"currency": "rub", "page": "1", "minRooms": "4", "maxRooms": "2"},
+		{"currency": "rub", "page": "1", "dealType": "7"},
+		{"currency": "rub", "page": "1", "minTotalArea": "-1"},
+	} {
+		req, err := NewRequest("GET", nil, "/adv", nil, query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%v: handler returned wrong status code: got %v want %v", query, status, http.StatusBadRequest)
+		}
+	}
+
+	req, err := NewRequest("POST", H{"Cookie": cookie}, "/adv", nil, nil, &dto.CreateAdvRequest{
+		OriginLang: 1, TranslatedBy: 1, TranslatedTo: "ru", Title: "Дом", Price: 1000, Currency: "rub",
+		Country: "Russia", City: "Тверь", Latitude: 56, Longitude: 36,
+		DealType: models.DealTypeSale, RentPeriod: models.RentPeriodMonthly,
+	})
 	if err != nil {
 		t.Fatalf("Failed to create request: %v", err)
 	}
-
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("rentPeriod for sale: handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
 
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+func TestGetAdvClusters(t *testing.T) {
+	cases := []struct {
+		query    H
+		clusters int
+		count    int
+	}{
+		{H{"currency": "rub", "zoom": "3", "bbox": "30,0,40,10"}, 1, 1},
+		{H{"currency": "rub", "zoom": "3", "bbox": "30,0,40,10", "q": "пентхаус"}, 1, 1},
+		{H{"currency": "rub", "zoom": "3", "bbox": "30,0,40,10", "countryCode": "TR"}, 0, 0},
+		{H{"currency": "rub", "zoom": "10", "bbox": "35,0,40,10"}, 0, 0},
+	}
+	for _, c := range cases {
+		req, err := NewRequest("GET", nil, "/adv/clusters", nil, c.query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%v: handler returned wrong status code: got %v want %v", c.query, status, http.StatusOK)
+		}
+
+		var response dto.GetAdvClustersResponse
+		err = json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Count != c.count || len(response.Clusters) != c.clusters {
+			t.Errorf("%v: wrong clusters: got %v/%v want %v/%v", c.query, len(response.Clusters), response.Count, c.clusters, c.count)
+		}
+		if len(response.Clusters) == 1 {
+			cluster := response.Clusters[0]
+			if cluster.AdvId != advId || cluster.Latitude != 2 || cluster.Longitude != 34 || cluster.MinDollarPrice != cluster.MaxDollarPrice {
+				t.Errorf("%v: wrong cluster %+v", c.query, cluster)
+			}
+		}
 	}
 
-	expected := resultOKStr // Replace with actual expected response
-	if rr.Body.String() != expected {
-		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	for _, query := range []H{
+		{"currency": "rub", "zoom": "3"},
+		{"currency": "rub", "zoom": "30", "bbox": "30,0,40,10"},
+		{"currency": "rub", "zoom": "3", "bbox": "30,10,40,0"},
+	} {
+		req, err := NewRequest("GET", nil, "/adv/clusters", nil, query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%v: handler returned wrong status code: got %v want %v", query, status, http.StatusBadRequest)
+		}
 	}
 	time.Sleep(timeSleepMs * time.Millisecond)
 }
 
-func TestLogoutAll(t *testing.T) {
-	req, err := NewRequest("GET", H{"Cookie": cookie}, "/logout/all", nil, nil, nil)
+func TestGetSimilarAdvs(t *testing.T) {
+	var ids []int64
+	for _, request := range []*dto.CreateAdvRequest{
+		{Title: "Пентхаус с террасой", Price: 25000, Latitude: 2.01, Longitude: 34.01, DealType: models.DealTypeSale},
+		{Title: "Пентхаус", Price: 22220, Latitude: 2.01, Longitude: 34.01, DealType: models.DealTypeRent},
+		{Title: "Пентхаус", Price: 22220, Latitude: 5, Longitude: 34, DealType: models.DealTypeSale},
+	} {
+		request.OriginLang, request.TranslatedBy, request.TranslatedTo = 1, 1, "ru"
+		request.Currency, request.Country, request.City = "rub", "Russia", "Москва"
+		req, err := NewRequest("POST", H{"Cookie": cookie}, "/adv", nil, nil, request)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		var response dto.CreateAdvResponse
+		if err = json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		ids = append(ids, response.AdvId)
+	}
+
+	req, err := NewRequest("GET", nil, fmt.Sprintf("/adv/%d", advId), nil, nil, nil)
 	if err != nil {
 		t.Fatalf("Failed to create request: %v", err)
 	}
-
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
+	var adv dto.GetAdvResponseItem
+	if err = json.NewDecoder(rr.Body).Decode(&adv); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
 
+	req, err = NewRequest("GET", nil, adv.SimilarUrl, nil, H{"displayCurrency": "usd"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
 	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var response dto.GetAdvListResponse
+	if err = json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	//аренда не сравнивается с продажей, а объявление в 330 км слишком далеко
+	if len(response.List) != 1 || response.List[0].Id != ids[0] {
+		t.Errorf("wrong similar adverts: %+v", response.List)
+	} else if response.List[0].Similarity <= 0.5 || response.List[0].DisplayCurrency != "USD" {
+		t.Errorf("wrong similar advert: %+v", response.List[0])
 	}
 
-	expected := resultOKStr // Replace with actual expected response
-	if rr.Body.String() != expected {
-		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	req, err = NewRequest("GET", nil, adv.SimilarUrl, nil, H{"limit": "0"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	for _, id := range ids {
+		req, err = NewRequest("DELETE", H{"Cookie": cookie}, fmt.Sprintf("/adv/%d", id), nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		mux.ServeHTTP(httptest.NewRecorder(), req)
 	}
 	time.Sleep(timeSleepMs * time.Millisecond)
 }
 
-func TestMetricsHandler(t *testing.T) {
-	req, err := http.NewRequest("GET", "/metrics", nil)
-	if err != nil {
-		t.Fatal(err)
+func TestAdvDuplicates(t *testing.T) {
+	send := func(method string, cookie string, url string, body any) *httptest.ResponseRecorder {
+		req, err := NewRequest(method, H{"Cookie": cookie}, url, nil, nil, body)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	repost := &dto.CreateAdvRequest{
+		OriginLang: 1, TranslatedBy: 1, TranslatedTo: "ru", Title: "Пентхаус", Description: "Описание пентхауса!",
+		Price: 22300, Currency: "rub", Country: "Russia", City: "Москва", Address: "Пушкина ул., дом Кукушкина",
+		Latitude: 2.0001, Longitude: 34,
+	}
+	rr := send("POST", cookie, "/adv", repost)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("own repost: handler returned wrong status code: got %v want %v", status, http.StatusConflict)
 	}
 
-	rr := httptest.NewRecorder()
-	mux.ServeHTTP(rr, req)
+	const agentEmail = "agent@example.com"
+	if rr = send("POST", "", "/registration", &dto.RegisterRequest{Email: agentEmail, Name: "Agent", Password: password}); rr.Code != http.StatusOK {
+		t.Fatalf("registration: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+	verifyEmail(t, agentEmail)
+	rr = send("POST", "", "/login", &dto.LoginRequest{Email: agentEmail, Password: password})
+	agentCookie := rr.Header().Get("Set-Cookie")
 
+	rr = send("POST", agentCookie, "/adv", repost)
 	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		t.Fatalf("foreign repost: handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
-
-	expected := dto.Metrics{
-		InstanceStartTime:        application.GetInstanceStartTime().Format("2006/01/02 15:04:05"),
-		UnSavedChangesQueueCount: cache.GetToSaveCount(),
-		RecoveredPanicsCount:     application.GetRecoveredPanicsCount(),
+	var created dto.CreateAdvResponse
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
 	}
-
-	var response dto.Metrics
-	err = json.NewDecoder(rr.Body).Decode(&response)
-	if err != nil {
+	rr = send("GET", "", fmt.Sprintf("/adv/%d", created.AdvId), nil)
+	var adv dto.GetAdvResponseItem
+	if err := json.NewDecoder(rr.Body).Decode(&adv); err != nil {
 		t.Fatalf("failed to decode response: %v", err)
 	}
+	if adv.DuplicateOf != advId {
+		t.Errorf("wrong duplicateOf: got %v want %v", adv.DuplicateOf, advId)
+	}
 
-	if response.InstanceStartTime != expected.InstanceStartTime {
-		t.Errorf("instanceStartTime mismatch: got %v want %v", response.InstanceStartTime, expected.InstanceStartTime)
+	if rr = send("GET", agentCookie, "/admin/duplicates", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("report for not admin: handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	clusters := cache.FindDuplicateClusters()
+	if len(clusters) != 1 || clusters[0].Original.Id != advId || len(clusters[0].Duplicates) != 1 || clusters[0].Duplicates[0].Id != created.AdvId {
+		t.Errorf("wrong duplicate clusters: %+v", clusters)
 	}
 
-	if response.UnSavedChangesQueueCount != expected.UnSavedChangesQueueCount {
-		t.Errorf("unSavedChangesQueueCount mismatch: got %v want %v", response.UnSavedChangesQueueCount, expected.UnSavedChangesQueueCount)
+	send("DELETE", agentCookie, fmt.Sprintf("/adv/%d", created.AdvId), nil)
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestLegacyPasswordUpgrade(t *testing.T) {
+	send := func(url string, body any) *httptest.ResponseRecorder {
+		req, err := NewRequest("POST", nil, url, nil, nil, body)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
 	}
+	const legacyEmail = "legacy@example.com"
+	if rr := send("/registration", &dto.RegisterRequest{Email: legacyEmail, Name: "Legacy", Password: password}); rr.Code != http.StatusOK {
+		t.Fatalf("registration: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+	userCache := cache.FindUserCacheByLogin(legacyEmail)
+	if userCache == nil {
+		t.Fatal("user not found")
+	}
+	//хеш в старом формате: SHA-1 без соли
+	legacy := sha1.Sum([]byte(password + "bla bla secret 36464663464"))
+	userCache.CurrentUser.PasswordHash = legacy[:]
 
-	if response.RecoveredPanicsCount != expected.RecoveredPanicsCount {
-		t.Errorf("recoveredPanicsCount mismatch: got %v want %v", response.RecoveredPanicsCount, expected.RecoveredPanicsCount)
+	if rr := send("/login", &dto.LoginRequest{Email: legacyEmail, Password: newPassword}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if len(userCache.CurrentUser.PasswordHash) != sha1.Size {
+		t.Errorf("hash upgraded after failed login")
+	}
+	if rr := send("/login", &dto.LoginRequest{Email: legacyEmail, Password: password}); rr.Code != http.StatusOK {
+		t.Fatalf("legacy login: handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	ok, needsRehash := utils.CheckPassword(password, userCache.CurrentUser.PasswordHash)
+	if !ok || needsRehash || len(userCache.CurrentUser.PasswordHash) == sha1.Size {
+		t.Errorf("hash was not upgraded: ok=%v needsRehash=%v len=%d", ok, needsRehash, len(userCache.CurrentUser.PasswordHash))
 	}
+	if rr := send("/login", &dto.LoginRequest{Email: legacyEmail, Password: password}); rr.Code != http.StatusOK {
+		t.Errorf("login after upgrade: handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if other := utils.GeneratePasswordHash(password); bytes.Equal(other, userCache.CurrentUser.PasswordHash) {
+		t.Errorf("hashes of the same password must differ by salt")
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
 
+func TestRegistrationDuplicateEmail(t *testing.T) {
+	send := func(url string, body any) *httptest.ResponseRecorder {
+		req, err := NewRequest("POST", nil, url, nil, nil, body)
+		if err != nil {
+			t.Errorf("Failed to create request: %v", err)
+			return httptest.NewRecorder()
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := send("/registration", &dto.RegisterRequest{Email: "  " + strings.ToUpper(userEmail) + " ", Name: "Copy", Password: password}); rr.Code != http.StatusConflict {
+		t.Errorf("duplicate email: handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := send("/login", &dto.LoginRequest{Email: strings.ToUpper(userEmail), Password: newPassword}); rr.Code != http.StatusOK {
+		t.Errorf("login with upper case email: handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	const concurrentEmail = "Concurrent@Example.com"
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = send("/registration", &dto.RegisterRequest{Email: concurrentEmail, Name: "Concurrent", Password: password}).Code
+		}()
+	}
+	wg.Wait()
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("concurrent registration: unexpected status code %v", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("concurrent registration: created %d users, want 1", created)
+	}
+	userCache := cache.FindUserCacheByLogin(concurrentEmail)
+	if userCache == nil || userCache.CurrentUser.Email != strings.ToLower(concurrentEmail) {
+		t.Errorf("email was not normalized: %+v", userCache)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestInvites(t *testing.T) {
+	send := func(cookie string, url string, body any) *httptest.ResponseRecorder {
+		req, err := NewRequest("POST", H{"Cookie": cookie}, url, nil, nil, body)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	register := func(email string, inviteId string) int {
+		return send("", "/registration", &dto.RegisterRequest{Email: email, Name: "Invited", Password: password, InviteId: inviteId}).Code
+	}
+	if rr := send(cookie, "/admin/invites", &dto.CreateInviteRequest{MaxUses: 1}); rr.Code != http.StatusForbidden {
+		t.Errorf("invite by not admin: handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	inviteId := cache.CreateInvite(0, config.GetAdminId(), &dto.CreateInviteRequest{Company: "Агентство", MaxUses: 1})
+	if code := register("invited@example.com", inviteId); code != http.StatusOK {
+		t.Errorf("registration by invite: wrong status code: got %v want %v", code, http.StatusOK)
+	}
+	if code := register("invited2@example.com", inviteId); code != http.StatusForbidden {
+		t.Errorf("registration by used invite: wrong status code: got %v want %v", code, http.StatusForbidden)
+	}
+	if code := register("invited2@example.com", "unknown"); code != http.StatusForbidden {
+		t.Errorf("registration by unknown invite: wrong status code: got %v want %v", code, http.StatusForbidden)
+	}
+	expiredId := cache.CreateInvite(0, config.GetAdminId(), &dto.CreateInviteRequest{Expires: time.Now().Add(timeSleepMs * time.Millisecond)})
+	revokedId := cache.CreateInvite(0, config.GetAdminId(), &dto.CreateInviteRequest{})
+	cache.RevokeInvite(0, cache.FindInviteCacheById(revokedId))
+	time.Sleep(2 * timeSleepMs * time.Millisecond)
+	if code := register("invited2@example.com", expiredId); code != http.StatusForbidden {
+		t.Errorf("registration by expired invite: wrong status code: got %v want %v", code, http.StatusForbidden)
+	}
+	if code := register("invited2@example.com", revokedId); code != http.StatusForbidden {
+		t.Errorf("registration by revoked invite: wrong status code: got %v want %v", code, http.StatusForbidden)
+	}
+
+	takeVerifyToken(t, "invited@example.com")
+	_ = os.Setenv("INVITE_ONLY", "true")
+	config.Initialize()
+	openId := cache.CreateInvite(0, config.GetAdminId(), &dto.CreateInviteRequest{})
+	if code := register("invited2@example.com", ""); code != http.StatusForbidden {
+		t.Errorf("invite only without invite: wrong status code: got %v want %v", code, http.StatusForbidden)
+	}
+	if code := register("invited2@example.com", openId); code != http.StatusOK {
+		t.Errorf("invite only with invite: wrong status code: got %v want %v", code, http.StatusOK)
+	}
+	takeVerifyToken(t, "invited2@example.com")
+	_ = os.Unsetenv("INVITE_ONLY")
+	config.Initialize()
+
+	var used, open *dto.InviteResponseItem
+	for _, item := range cache.FindInvites() {
+		switch item.Id {
+		case inviteId:
+			used = item
+		case openId:
+			open = item
+		}
+	}
+	if used == nil || used.Uses != 1 || used.Active || used.Company != "Агентство" || len(used.Users) != 1 || used.Users[0].Email != "invited@example.com" {
+		t.Errorf("wrong used invite: %+v", used)
+	}
+	if open == nil || open.Uses != 1 || !open.Active || len(open.Users) != 1 || open.Users[0].Email != "invited2@example.com" {
+		t.Errorf("wrong unlimited invite: %+v", open)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestEmailVerification(t *testing.T) {
+	send := func(method string, cookie string, url string, body any) *httptest.ResponseRecorder {
+		req, err := NewRequest(method, H{"Cookie": cookie}, url, nil, nil, body)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	const unverifiedEmail = "unverified@example.com"
+	if rr := send("POST", "", "/registration", &dto.RegisterRequest{Email: unverifiedEmail, Name: "Unverified", Password: password}); rr.Code != http.StatusOK {
+		t.Fatalf("registration: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+	firstToken := takeVerifyToken(t, unverifiedEmail)
+	rr := send("POST", "", "/login", &dto.LoginRequest{Email: unverifiedEmail, Password: password})
+	userCookie := rr.Header().Get("Set-Cookie")
+
+	if rr = send("POST", userCookie, "/adv", &dto.CreateAdvRequest{}); rr.Code != http.StatusForbidden {
+		t.Errorf("adv by unverified user: wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr = send("GET", "", "/verify-email?token=unknown", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown token: wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr = send("POST", userCookie, "/verify-email/resend", nil); rr.Code != http.StatusOK {
+		t.Errorf("resend: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+	secondToken := takeVerifyToken(t, unverifiedEmail)
+	if rr = send("GET", "", "/verify-email?token="+firstToken, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("replaced token: wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr = send("GET", "", "/verify-email?token="+secondToken, nil); rr.Code != http.StatusOK {
+		t.Errorf("verify: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr = send("GET", "", "/verify-email?token="+secondToken, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("used token: wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr = send("POST", userCookie, "/verify-email/resend", nil); rr.Code != http.StatusConflict {
+		t.Errorf("resend after verify: wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	//после подтверждения запрос доходит до валидации
+	if rr = send("POST", userCookie, "/adv", &dto.CreateAdvRequest{}); rr.Code != http.StatusBadRequest {
+		t.Errorf("adv by verified user: wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestPasswordReset(t *testing.T) {
+	outboxPath := t.TempDir() + "/outbox.jsonl"
+	send := func(remoteAddr string, cookie string, url string, body any) *httptest.ResponseRecorder {
+		req, err := NewRequest("POST", H{"Cookie": cookie}, url, nil, nil, body)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	readToken := func() string {
+		time.Sleep(timeSleepMs * time.Millisecond)
+		data, err := os.ReadFile(outboxPath)
+		if err != nil {
+			t.Fatalf("failed to read outbox: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		var message mailer.Message
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &message); err != nil {
+			t.Fatalf("failed to decode message: %v", err)
+		}
+		_, link, _ := strings.Cut(message.Body, "token=")
+		token, _, _ := strings.Cut(link, "\n")
+		return token
+	}
+	const resetEmail = "reset@example.com"
+	const addr = "10.0.0.1:1234"
+	if rr := send(addr, "", "/registration", &dto.RegisterRequest{Email: resetEmail, Name: "Reset", Password: password}); rr.Code != http.StatusOK {
+		t.Fatalf("registration: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+	rr := send(addr, "", "/login", &dto.LoginRequest{Email: resetEmail, Password: password})
+	oldCookie := rr.Header().Get("Set-Cookie")
+	//письмо о подтверждении уже отправлено, дальше письма пишутся в файл
+	prevMailer := mailer.GetMailer()
+	mailer.SetMailer(&mailer.FileMailer{Path: outboxPath})
+	defer mailer.SetMailer(prevMailer)
+
+	if rr = send(addr, "", "/password/forgot", &dto.ForgotPasswordRequest{Email: "nobody@example.com"}); rr.Code != http.StatusOK {
+		t.Errorf("unknown email: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+	if _, err := os.Stat(outboxPath); err == nil {
+		t.Errorf("message was sent for unknown email")
+	}
+
+	if rr = send(addr, "", "/password/forgot", &dto.ForgotPasswordRequest{Email: resetEmail}); rr.Code != http.StatusOK {
+		t.Fatalf("forgot: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	token := readToken()
+	userCache := cache.FindUserCacheByLogin(resetEmail)
+	if userCache == nil || len(userCache.CurrentUser.ResetHash) == 0 || strings.Contains(string(userCache.CurrentUser.ResetHash), token) {
+		t.Errorf("reset token must be stored hashed")
+	}
+	_, apiKey, err := cache.CreateApiKey(0, userCache.CurrentUser.Id, "reset", models.ApiScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr = send(addr, "", "/password/reset", &dto.ResetPasswordRequest{Token: token + "x", NewPassword: newPassword}); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong token: wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr = send(addr, "", "/password/reset", &dto.ResetPasswordRequest{Token: token, NewPassword: newPassword}); rr.Code != http.StatusOK {
+		t.Errorf("reset: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr = send(addr, "", "/password/reset", &dto.ResetPasswordRequest{Token: token, NewPassword: password}); rr.Code != http.StatusNotFound {
+		t.Errorf("reused token: wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+	if rr = send(addr, "", "/login", &dto.LoginRequest{Email: resetEmail, Password: newPassword}); rr.Code != http.StatusOK {
+		t.Errorf("login with new password: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	req, _ := NewRequest("GET", H{"Cookie": oldCookie}, "/user/searches", nil, nil, nil)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("old session after reset: wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if status := apiKeyStatus(apiKey); status != http.StatusUnauthorized {
+		t.Errorf("api key after reset: wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+
+	if rr = send(addr, "", "/password/forgot", &dto.ForgotPasswordRequest{Email: resetEmail}); rr.Code != http.StatusOK {
+		t.Fatalf("forgot: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	token = readToken()
+	userCache.CurrentUser.ResetExpires = time.Now().Add(-time.Second)
+	if rr = send(addr, "", "/password/reset", &dto.ResetPasswordRequest{Token: token, NewPassword: password}); rr.Code != http.StatusGone {
+		t.Errorf("expired token: wrong status code: got %v want %v", rr.Code, http.StatusGone)
+	}
+
+	//лимит на email не зависит от IP
+	for i := 2; i < config.GetPasswordResetEmailLimit(); i++ {
+		send(fmt.Sprintf("10.0.1.%d:1234", i), "", "/password/forgot", &dto.ForgotPasswordRequest{Email: resetEmail})
+	}
+	if rr = send("10.0.2.1:1234", "", "/password/forgot", &dto.ForgotPasswordRequest{Email: " RESET@example.com"}); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("email limit: wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	const limitedAddr = "10.0.3.1:1234"
+	for i := range config.GetPasswordResetIpLimit() {
+		send(limitedAddr, "", "/password/forgot", &dto.ForgotPasswordRequest{Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+	if rr = send(limitedAddr, "", "/password/forgot", &dto.ForgotPasswordRequest{Email: "other@example.com"}); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("ip limit: wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestAuthTokenRotation(t *testing.T) {
+	defer auth_token.SetKeys(config.GetAuthTokenKeys(), config.GetAuthTokenKeyId())
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	sessionSecret := [24]byte{1, 2, 3}
+	expires := time.Now().Add(time.Hour).UnixNano()
+
+	auth_token.SetKeys(map[uint8][]byte{1: oldKey}, 1)
+	oldToken := auth_token.NewToken(42, 7, expires, sessionSecret)
+	if len(oldToken) != auth_token.TokenLength || oldToken[0] != auth_token.TokenVersion || oldToken[1] != 1 {
+		t.Fatalf("wrong token header: %v", oldToken[:2])
+	}
+	token, err := auth_token.Parse(oldToken)
+	if err != nil || token.UserId != 42 || token.SessionId != 7 || token.Expires != expires || token.Legacy {
+		t.Fatalf("wrong parsed token: %+v, %v", token, err)
+	}
+	if !token.IsValid(sessionSecret) {
+		t.Error("token must be valid")
+	}
+	if token.IsValid([24]byte{3, 2, 1}) {
+		t.Error("token must be invalid after session secret change")
+	}
+	tampered := slices.Clone(oldToken)
+	tampered[5]++
+	if token, err = auth_token.Parse(tampered); err != nil || token.IsValid(sessionSecret) {
+		t.Error("tampered token must be invalid")
+	}
+
+	auth_token.SetKeys(map[uint8][]byte{1: oldKey, 2: newKey}, 2)
+	if token, err = auth_token.Parse(oldToken); err != nil || !token.IsValid(sessionSecret) {
+		t.Errorf("token signed by previous key must be valid during rotation: %v", err)
+	}
+	if newToken := auth_token.NewToken(42, 7, expires, sessionSecret); newToken[1] != 2 {
+		t.Errorf("new token must be signed by current key, got key %d", newToken[1])
+	}
+	auth_token.SetKeys(map[uint8][]byte{2: newKey}, 2)
+	if _, err = auth_token.Parse(oldToken); !errors.Is(err, auth_token.ErrTokenKey) {
+		t.Errorf("token signed by removed key: got %v want %v", err, auth_token.ErrTokenKey)
+	}
+
+	//токен без сессии один раз привязывается к новой сессии и заменяется токеном с ней
+	userCache := cache.FindUserCacheByLogin(userEmail)
+	sessionsBefore := len(cache.FindUserSessions(userCache.CurrentUser.Id, 0))
+	legacy := auth_token.Shuffle(auth_token.CreateToken(userCache.CurrentUser.Id, expires, userCache.CurrentUser.SessionSecret))
+	sessionless := auth_token.NewToken(userCache.CurrentUser.Id, 0, expires, userCache.CurrentUser.SessionSecret)
+	sendToken := func(tokenBytes []byte) (int, *auth_token.Token) {
+		req, _ := NewRequest("GET", H{"Cookie": "auth_token=" + base64.StdEncoding.EncodeToString(tokenBytes)}, "/user/searches", nil, nil, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		for _, c := range rr.Result().Cookies() {
+			if c.Name != "auth_token" {
+				continue
+			}
+			reissued, _ := base64.StdEncoding.DecodeString(c.Value)
+			token, err := auth_token.Parse(reissued)
+			if err != nil || len(reissued) != auth_token.TokenLength || reissued[1] != 2 {
+				t.Errorf("cookie was not reissued in new format: %v", err)
+			}
+			return rr.Code, token
+		}
+		return rr.Code, nil
+	}
+	for _, tokenBytes := range [][]byte{legacy[:], sessionless} {
+		code, first := sendToken(tokenBytes)
+		if code != http.StatusOK || first == nil || first.SessionId == 0 {
+			t.Fatalf("sessionless cookie: wrong status code %v or no session in reissued token", code)
+		}
+		if code, again := sendToken(tokenBytes); code != http.StatusOK || again == nil || again.SessionId != first.SessionId {
+			t.Errorf("sessionless cookie must be bound to one session: status %v", code)
+		}
+		cache.DeleteSession(0, cache.FindSessionCacheById(first.SessionId))
+		if code, _ = sendToken(tokenBytes); code != http.StatusUnauthorized {
+			t.Errorf("sessionless cookie after session revoke: wrong status code: got %v want %v", code, http.StatusUnauthorized)
+		}
+	}
+	if sessionsAfter := len(cache.FindUserSessions(userCache.CurrentUser.Id, 0)); sessionsAfter != sessionsBefore {
+		t.Errorf("sessionless cookies left sessions: got %v want %v", sessionsAfter, sessionsBefore)
+	}
+
+	if _, err = auth_token.Parse(legacy[:]); err != nil || !config.GetAuthTokenLegacyUntil().After(time.Now()) {
+		t.Errorf("legacy token within default migration window: %v", err)
+	}
+	_ = os.Setenv("AUTH_TOKEN_LEGACY_UNTIL", time.Now().Add(-time.Hour).Format(time.RFC3339))
+	config.Initialize()
+	if _, err = auth_token.Parse(legacy[:]); !errors.Is(err, auth_token.ErrTokenLegacy) {
+		t.Errorf("legacy token after migration window: got %v want %v", err, auth_token.ErrTokenLegacy)
+	}
+	_ = os.Unsetenv("AUTH_TOKEN_LEGACY_UNTIL")
+	config.Initialize()
+}
+
+func TestSessions(t *testing.T) {
+	const sessionsEmail = "sessions@example.com"
+	send := func(method string, addr string, cookie string, url string, body any) *httptest.ResponseRecorder {
+		req, err := NewRequest(method, H{"Cookie": cookie}, url, nil, nil, body)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.RemoteAddr = addr
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := send("POST", "10.0.4.1:1234", "", "/registration", &dto.RegisterRequest{Email: sessionsEmail, Name: "Sessions", Password: password}); rr.Code != http.StatusOK {
+		t.Fatalf("registration: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+	login := func(addr string, device string) string {
+		rr := send("POST", addr, "", "/login", &dto.LoginRequest{Email: sessionsEmail, Password: password, Device: device})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("login %s: wrong status code: got %v want %v", device, rr.Code, http.StatusOK)
+		}
+		return rr.Header().Get("Set-Cookie")
+	}
+	laptopCookie := login("10.0.4.1:1234", "laptop")
+	phoneCookie := login("10.0.4.2:1234", "phone")
+
+	rr := send("GET", "10.0.4.1:1234", laptopCookie, "/user/sessions", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	response := &dto.GetSessionListResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.List) != 2 {
+		t.Fatalf("wrong sessions count: got %v want %v", len(response.List), 2)
+	}
+	var phoneSessionId int64
+	for _, session := range response.List {
+		switch session.Device {
+		case "laptop":
+			if !session.Current || session.Ip != "10.0.4.1" {
+				t.Errorf("wrong laptop session: %+v", session)
+			}
+		case "phone":
+			if session.Current || session.Ip != "10.0.4.2" {
+				t.Errorf("wrong phone session: %+v", session)
+			}
+			phoneSessionId = session.Id
+		}
+	}
+	if phoneSessionId == 0 {
+		t.Fatal("phone session not found")
+	}
+
+	//чужую сессию удалить нельзя
+	if rr = send("DELETE", "10.0.0.1:1234", cookie, fmt.Sprintf("/user/sessions/%d", phoneSessionId), nil); rr.Code != http.StatusNotFound {
+		t.Errorf("foreign session: wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr = send("DELETE", "10.0.4.1:1234", laptopCookie, fmt.Sprintf("/user/sessions/%d", phoneSessionId), nil); rr.Code != http.StatusOK {
+		t.Fatalf("delete: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+	if rr = send("GET", "10.0.4.2:1234", phoneCookie, "/user/sessions", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session: wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr = send("GET", "10.0.4.1:1234", laptopCookie, "/user/sessions", nil); rr.Code != http.StatusOK {
+		t.Errorf("current session after revoke: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr = send("DELETE", "10.0.4.1:1234", laptopCookie, fmt.Sprintf("/user/sessions/%d", phoneSessionId), nil); rr.Code != http.StatusNotFound {
+		t.Errorf("deleted session: wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestApiKeys(t *testing.T) {
+	send := func(method string, headers H, url string, body any) *httptest.ResponseRecorder {
+		req, err := NewRequest(method, headers, url, nil, H{"page": "1"}, body)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.RemoteAddr = "10.0.5.1:1234"
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	createKey := func(name string, scopes ...string) *dto.CreateApiKeyResponse {
+		rr := send("POST", H{"Cookie": cookie}, "/user/api-keys", &dto.CreateApiKeyRequest{Name: name, Scopes: scopes})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("create %s: wrong status code: got %v want %v", name, rr.Code, http.StatusOK)
+		}
+		response := &dto.CreateApiKeyResponse{}
+		if err := json.Unmarshal(rr.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	if rr := send("POST", H{"Cookie": cookie}, "/user/api-keys", &dto.CreateApiKeyRequest{Name: "bad", Scopes: []string{"admin"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown scope: wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	readKey := createKey("reader", "read")
+	writeKey := createKey("writer", "adv-write", "photos")
+	time.Sleep(timeSleepMs * time.Millisecond)
+
+	rr := send("GET", H{"Authorization": "Bearer " + readKey.Key}, "/user/adv", nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("read key: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr.Header().Get("Set-Cookie") != "" {
+		t.Error("request with api key must not set auth cookie")
+	}
+	if rr = send("POST", H{"Authorization": "Bearer " + readKey.Key}, "/adv", &dto.CreateAdvRequest{}); rr.Code != http.StatusForbidden {
+		t.Errorf("read key without adv-write: wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	//ключ с adv-write проходит авторизацию и доходит до валидации
+	if rr = send("POST", H{"Authorization": "Bearer " + writeKey.Key}, "/adv", &dto.CreateAdvRequest{}); rr.Code != http.StatusBadRequest {
+		t.Errorf("write key: wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr = send("GET", H{"Authorization": "Bearer " + readKey.Key + "x"}, "/user/adv", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	//управлять ключами можно только с cookie
+	if rr = send("GET", H{"Authorization": "Bearer " + readKey.Key}, "/user/api-keys", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("list with api key: wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	rr = send("GET", H{"Cookie": cookie}, "/user/api-keys", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if strings.Contains(rr.Body.String(), readKey.Key) {
+		t.Error("list must not contain key secret")
+	}
+	list := &dto.GetApiKeyListResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.List) != 2 || list.List[0].Id != writeKey.Id || list.List[1].Id != readKey.Id {
+		t.Fatalf("wrong api keys list: %s", rr.Body.String())
+	}
+	if !slices.Equal(list.List[0].Scopes, []string{"adv-write", "photos"}) {
+		t.Errorf("wrong scopes: %v", list.List[0].Scopes)
+	}
+	if list.List[1].LastUsed == nil || list.List[1].LastUsedIp != "10.0.5.1" {
+		t.Errorf("last used was not tracked: %+v", list.List[1])
+	}
+
+	if rr = send("DELETE", H{"Cookie": cookie}, fmt.Sprintf("/user/api-keys/%d", readKey.Id), nil); rr.Code != http.StatusOK {
+		t.Fatalf("revoke: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+	if rr = send("GET", H{"Authorization": "Bearer " + readKey.Key}, "/user/adv", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr = send("DELETE", H{"Cookie": cookie}, fmt.Sprintf("/user/api-keys/%d", readKey.Id), nil); rr.Code != http.StatusNotFound {
+		t.Errorf("revoked key delete: wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	send("DELETE", H{"Cookie": cookie}, fmt.Sprintf("/user/api-keys/%d", writeKey.Id), nil)
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestBruteForceProtection(t *testing.T) {
+	send := func(method string, addr string, cookie string, url string, body any) *httptest.ResponseRecorder {
+		req, err := NewRequest(method, H{"Cookie": cookie}, url, nil, nil, body)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.RemoteAddr = addr
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	metrics := func() *dto.Metrics {
+		rr := send("GET", "", "", "/metrics", nil)
+		m := &dto.Metrics{}
+		if err := json.Unmarshal(rr.Body.Bytes(), m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	const bruteEmail = "brute@example.com"
+	const addr = "10.0.6.1:1234"
+	if rr := send("POST", addr, "", "/registration", &dto.RegisterRequest{Email: bruteEmail, Name: "Brute", Password: password}); rr.Code != http.StatusOK {
+		t.Fatalf("registration: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+
+	//блокировка аккаунта: после неудач даже верный пароль не принимается
+	for range config.GetLoginAccountMaxAttempts() {
+		if rr := send("POST", addr, "", "/login", &dto.LoginRequest{Email: bruteEmail, Password: newPassword}); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password: wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	}
+	rr := send("POST", addr, "", "/login", &dto.LoginRequest{Email: " BRUTE@example.com", Password: password})
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked account: wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After header is missing")
+	}
+	if m := metrics(); m.LockedAccountsCount != 1 || m.LockedIpsCount != 0 {
+		t.Errorf("wrong lockout metrics: accounts %d ips %d", m.LockedAccountsCount, m.LockedIpsCount)
+	}
+	//с того же IP другой аккаунт доступен
+	if rr = send("POST", addr, "", "/login", &dto.LoginRequest{Email: userEmail, Password: newPassword}); rr.Code != http.StatusOK {
+		t.Errorf("other account: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr = send("DELETE", addr, cookie, "/admin/lockouts/"+bruteEmail, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("clear by non admin: wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if !ratelimit.ClearLoginLockout(bruteEmail) {
+		t.Error("account lockout was not cleared")
+	}
+	rr = send("POST", addr, "", "/login", &dto.LoginRequest{Email: bruteEmail, Password: password})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("login after clear: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	bruteCookie := rr.Header().Get("Set-Cookie")
+
+	//смена пароля с неверным старым паролем считается так же
+	for range config.GetLoginAccountMaxAttempts() {
+		rr = send("PUT", addr, bruteCookie, "/password", &dto.UpdatePasswordRequest{OldPassword: newPassword, NewPassword: newPassword})
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong old password: wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	}
+	if rr = send("PUT", addr, bruteCookie, "/password", &dto.UpdatePasswordRequest{OldPassword: password, NewPassword: newPassword}); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("locked password change: wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	ratelimit.ClearLoginLockout(bruteEmail)
+
+	//блокировка IP: перебор разных аккаунтов
+	const bruteAddr = "10.0.6.2:1234"
+	for i := range config.GetLoginIpMaxAttempts() {
+		if rr = send("POST", bruteAddr, "", "/login", &dto.LoginRequest{Email: fmt.Sprintf("guess%d@example.com", i), Password: password}); rr.Code != http.StatusNotFound {
+			t.Fatalf("unknown email: wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	}
+	if rr = send("POST", bruteAddr, "", "/login", &dto.LoginRequest{Email: bruteEmail, Password: password}); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("locked ip: wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	//перебор паролей не блокирует регистрацию с того же IP
+	if rr = send("POST", bruteAddr, "", "/registration", &dto.RegisterRequest{Email: "new-brute@example.com", Name: "Brute", Password: password}); rr.Code != http.StatusOK {
+		t.Errorf("registration from locked ip: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if m := metrics(); m.LockedIpsCount != 1 {
+		t.Errorf("wrong locked ips metric: got %d want 1", m.LockedIpsCount)
+	}
+	if !ratelimit.ClearLoginLockout("10.0.6.2") {
+		t.Error("ip lockout was not cleared")
+	}
+	if rr = send("POST", bruteAddr, "", "/login", &dto.LoginRequest{Email: bruteEmail, Password: password}); rr.Code != http.StatusOK {
+		t.Errorf("login after ip clear: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	//отказы регистрации считаются отдельно и не блокируют вход с того же IP
+	const registrationAddr = "10.0.6.3:1234"
+	for range config.GetLoginIpMaxAttempts() {
+		if rr = send("POST", registrationAddr, "", "/registration", &dto.RegisterRequest{Email: bruteEmail, Name: "Brute", Password: password}); rr.Code != http.StatusConflict {
+			t.Fatalf("existing email: wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+		}
+	}
+	if rr = send("POST", registrationAddr, "", "/registration", &dto.RegisterRequest{Email: "other-brute@example.com", Name: "Brute", Password: password}); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("locked registration: wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr = send("POST", registrationAddr, "", "/login", &dto.LoginRequest{Email: bruteEmail, Password: password}); rr.Code != http.StatusOK {
+		t.Errorf("login after registration failures: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if m := metrics(); m.LockedRegistrationIpsCount != 1 || m.LockedIpsCount != 0 {
+		t.Errorf("wrong registration lockout metrics: registration ips %d ips %d", m.LockedRegistrationIpsCount, m.LockedIpsCount)
+	}
+	if !ratelimit.ClearLoginLockout("10.0.6.3") {
+		t.Error("registration lockout was not cleared")
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestSavedSearches(t *testing.T) {
+	sink := &notify.MemorySink{}
+	notify.SetSink(sink)
+	send := func(method string, cookie string, url string, body any) *httptest.ResponseRecorder {
+		req, err := NewRequest(method, H{"Cookie": cookie}, url, nil, nil, body)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	createAdv := func(cookie string, title string, price int64) int64 {
+		rr := send("POST", cookie, "/adv", &dto.CreateAdvRequest{
+			OriginLang: 1, TranslatedBy: 1, TranslatedTo: "ru", Title: title, Price: price, Currency: "rub",
+			Country: "Russia", City: "Тверь", Address: "ул. Лесная", Latitude: 56, Longitude: 36,
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("create adv: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var response dto.CreateAdvResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return response.AdvId
+	}
+
+	const searcherEmail = "searcher@example.com"
+	if rr := send("POST", "", "/registration", &dto.RegisterRequest{Email: searcherEmail, Name: "Searcher", Password: password}); rr.Code != http.StatusOK {
+		t.Fatalf("registration: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+	verifyEmail(t, searcherEmail)
+	rr := send("POST", "", "/login", &dto.LoginRequest{Email: searcherEmail, Password: password})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("login: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	searcherCookie := rr.Header().Get("Set-Cookie")
+
+	rr = send("POST", searcherCookie, "/user/searches", &dto.SavedSearchRequest{
+		Name:   "Дачи",
+		Filter: &dto.GetAdvListRequest{Currency: "rub", Query: "дача", Sort: "distance"},
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("sort without radius: wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	rr = send("POST", searcherCookie, "/user/searches", &dto.SavedSearchRequest{
+		Name:   "Дачи",
+		Filter: &dto.GetAdvListRequest{Currency: "rub", Query: "дача", MaxPrice: 1_000_000, Page: 3},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create search: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var created dto.CreateSavedSearchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	searchUrl := fmt.Sprintf("/user/searches/%d", created.SearchId)
+
+	rr = send("GET", searcherCookie, "/user/searches", nil)
+	var list dto.GetSavedSearchListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(list.List) != 1 || list.List[0].Name != "Дачи" || list.List[0].Filter.Query != "дача" || list.List[0].Filter.Page != 0 {
+		t.Errorf("wrong saved searches: %+v", list.List)
+	}
+
+	matchedId := createAdv(cookie, "Дача у озера", 500_000)
+	expensiveId := createAdv(cookie, "Дача с баней", 5_000_000)
+	otherId := createAdv(cookie, "Квартира", 500_000)
+	ownId := createAdv(searcherCookie, "Продам дачу", 500_000)
+	time.Sleep(timeSleepMs * time.Millisecond)
+	notifications := sink.Take()
+	if len(notifications) != 1 {
+		t.Fatalf("wrong notifications count: got %v want 1", len(notifications))
+	}
+	if n := notifications[0]; n.AdvId != matchedId || n.SearchId != created.SearchId || n.UserEmail != searcherEmail {
+		t.Errorf("wrong notification: %+v", n)
+	}
+	//при изменении уведомляем, только если объявление начало подходить под поиск
+	updateAdv := func(id int64, title string, price int64) {
+		rr := send("PUT", cookie, fmt.Sprintf("/adv/%d", id), &dto.UpdateAdvRequest{
+			OriginLang: 1, TranslatedBy: 1, TranslatedTo: "ru", Title: title, Price: price, Currency: "rub",
+			Country: "Russia", City: "Тверь", Address: "ул. Лесная", Latitude: 56, Longitude: 36,
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("update adv: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	}
+	updateAdv(expensiveId, "Дача с баней", 900_000)
+	updateAdv(matchedId, "Дача у озера", 600_000)
+	time.Sleep(timeSleepMs * time.Millisecond)
+	notifications = sink.Take()
+	if len(notifications) != 1 || notifications[0].AdvId != expensiveId {
+		t.Errorf("wrong notifications after update: %+v", notifications)
+	}
+
+	if rr = send("DELETE", cookie, searchUrl, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("delete foreign search: wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	rr = send("PUT", searcherCookie, searchUrl, &dto.SavedSearchRequest{
+		Name:   "Квартиры",
+		Filter: &dto.GetAdvListRequest{Currency: "rub", Query: "квартира"},
+	})
+	if rr.Code != http.StatusOK {
+		t.Errorf("update search: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr = send("DELETE", searcherCookie, searchUrl, nil); rr.Code != http.StatusOK {
+		t.Errorf("delete search: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	rr = send("GET", searcherCookie, "/user/searches", nil)
+	list = dto.GetSavedSearchListResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(list.List) != 0 {
+		t.Errorf("search not deleted: %+v", list.List)
+	}
+
+	for _, id := range []int64{matchedId, expensiveId, otherId} {
+		send("DELETE", cookie, fmt.Sprintf("/adv/%d", id), nil)
+	}
+	send("DELETE", searcherCookie, fmt.Sprintf("/adv/%d", ownId), nil)
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestDisplayCurrency(t *testing.T) {
+	dollarPrice := cache.FindAdvById(advId).DollarPrice
+
+	req, err := NewRequest("GET", nil, fmt.Sprintf("/adv/%d", advId), nil, H{"displayCurrency": "usd"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var item dto.GetAdvResponseItem
+	if err = json.NewDecoder(rr.Body).Decode(&item); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if item.DisplayPrice != dollarPrice || item.DisplayCurrency != "USD" || item.DisplayPriceFormatted != currency.Format("USD", dollarPrice) {
+		t.Errorf("wrong display price: %v %v %v", item.DisplayPrice, item.DisplayCurrency, item.DisplayPriceFormatted)
+	}
+
+	req, err = NewRequest("GET", H{"Cookie": "display_currency=RUB"}, "/adv", nil, H{"currency": "rub", "page": "1"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var response dto.GetAdvListResponse
+	if err = json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(response.List) != 1 || response.List[0].DisplayPrice != 22220 || response.List[0].DisplayPriceFormatted != "22,220 RUB" {
+		t.Errorf("wrong display price in list: %+v", response.List)
+	}
+
+	req, err = NewRequest("GET", nil, fmt.Sprintf("/adv/%d", advId), nil, H{"displayCurrency": "xyz"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestReloadCurrencyRates(t *testing.T) {
+	dir := t.TempDir()
+	oldDollarPrice := cache.FindAdvById(advId).DollarPrice
+
+	badFile := dir + "/bad.json"
+	if err := os.WriteFile(badFile, []byte(`[{"Currency": "RUB", "DollarRate": 0.02}, {"Curr`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := currency.LoadRates(badFile); err == nil {
+		t.Fatal("malformed file was loaded")
+	}
+	unknownFile := dir + "/unknown.json"
+	if err := os.WriteFile(unknownFile, []byte(`[{"Currency": "RUB", "DollarRate": 0.02}, {"Currency": "XXX", "DollarRate": 1}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := currency.LoadRates(unknownFile); err == nil {
+		t.Fatal("file with unknown currency was loaded")
+	}
+	if currency.CalcDollarPrice("rub", 22220) != oldDollarPrice {
+		t.Fatal("rates changed after failed reload")
+	}
+
+	goodFile := dir + "/good.json"
+	if err := os.WriteFile(goodFile, []byte(`[{"Currency": "RUB", "DollarRate": 0.02}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := currency.LoadRates(goodFile); err != nil {
+		t.Fatal(err)
+	}
+	if dollarPrice := cache.FindAdvById(advId).DollarPrice; dollarPrice != 444 {
+		t.Errorf("DollarPrice was not recalculated: got %v want %v", dollarPrice, 444)
+	}
+	if currency.GetLastReloadTime() == nil {
+		t.Error("last reload time is not set")
+	}
+
+	if err := currency.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if dollarPrice := cache.FindAdvById(advId).DollarPrice; dollarPrice != oldDollarPrice {
+		t.Errorf("DollarPrice was not restored: got %v want %v", dollarPrice, oldDollarPrice)
+	}
+
+	//одновременные перезагрузки: цены должны соответствовать курсам, загруженным последними
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				_ = currency.LoadRates(goodFile)
+			} else {
+				_ = currency.Reload()
+			}
+		}()
+	}
+	wg.Wait()
+	if dollarPrice, want := cache.FindAdvById(advId).DollarPrice, currency.CalcDollarPrice("rub", 22220); dollarPrice != want {
+		t.Errorf("DollarPrice after concurrent reloads: got %v want %v", dollarPrice, want)
+	}
+	if err := currency.Reload(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCurrencyProviders(t *testing.T) {
+	ecbXml := `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2024-07-05">
+			<Cube currency="USD" rate="1.0800"/>
+			<Cube currency="JPY" rate="174.00"/>
+			<Cube currency="GBP" rate="0.8460"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(ecbXml))
+	}))
+	defer server.Close()
+
+	list, date, err := (&currency.EcbProvider{Source: server.URL}).Rates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if date.Format(time.DateOnly) != "2024-07-05" {
+		t.Errorf("wrong ecb date: %v", date)
+	}
+	expected := map[string]float64{"EUR": 1.08, "JPY": 1.08 / 174, "GBP": 1.08 / 0.846}
+	if len(list) != len(expected) {
+		t.Fatalf("wrong ecb rates count: %v", list)
+	}
+	for _, rate := range list {
+		if diff := rate.DollarRate - expected[rate.Currency]; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("wrong ecb rate for %s: got %v want %v", rate.Currency, rate.DollarRate, expected[rate.Currency])
+		}
+	}
+	if err = currency.Update(&currency.EcbProvider{Source: server.URL}); err != nil {
+		t.Fatal(err)
+	}
+	history, err := db.GetCurrencyRates(date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 4 { //EUR, GBP, JPY и USD
+		t.Errorf("wrong history count: got %v want %v", len(history), 4)
+	}
+
+	csvFile := t.TempDir() + "/rates.csv"
+	if err = os.WriteFile(csvFile, []byte("currency,dollarRate\nRUB,0.011\nEUR, 1.08\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	list, _, err = (&currency.CsvProvider{Source: csvFile}).Rates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Currency != "RUB" || list[1].DollarRate != 1.08 {
+		t.Errorf("wrong csv rates: %v", list)
+	}
+
+	if err = currency.Update(&currency.CsvProvider{Source: server.URL}); err == nil {
+		t.Error("xml was parsed as csv")
+	}
+	if err = currency.Reload(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateId(t *testing.T) {
+	req, err := NewRequest("GET", H{"Cookie": cookie}, "/generate/id", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response dto.GenerateIdResponse
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !validator.IsValidUnixNanoId(response.Id) {
+		t.Fatalf("is not valid id: %v", response.Id)
+	}
+
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestAddAdvPhoto(t *testing.T) {
+	req, err := NewRequest("POST", H{"Cookie": cookie}, fmt.Sprintf("/adv/%d/photos", advId), nil, nil, &dto.AddPhotoRequest{Filename: fmt.Sprintf("%d.png", photoId)})
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	expected := resultOKStr // Replace with actual expected response
+	if rr.Body.String() != expected {
+		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestDeleteAdvPhoto(t *testing.T) {
+	req, err := NewRequest("DELETE", H{"Cookie": cookie}, fmt.Sprintf("/adv/%d/photos/%d", advId, photoId), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	expected := resultOKStr // Replace with actual expected response
+	if rr.Body.String() != expected {
+		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestDeleteAdv(t *testing.T) {
+	req, err := NewRequest("DELETE", H{"Cookie": cookie}, fmt.Sprintf("/adv/%d", advId), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	expected := resultOKStr // Replace with actual expected response
+	if rr.Body.String() != expected {
+		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestLogoutMe(t *testing.T) {
+	req, err := NewRequest("GET", H{"Cookie": cookie}, "/logout/me", nil, nil, nil) //todo
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	expected := resultOKStr // Replace with actual expected response
+	if rr.Body.String() != expected {
+		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+
+	//сессия завершена на сервере, старая cookie больше не действует
+	req, _ = NewRequest("GET", H{"Cookie": cookie}, "/user/searches", nil, nil, nil)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("cookie after logout: wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	req, _ = NewRequest("POST", nil, "/login", nil, nil, &dto.LoginRequest{Email: userEmail, Password: newPassword})
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("login after logout: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	cookie = rr.Header().Get("Set-Cookie")
+}
+
+func TestLogoutAll(t *testing.T) {
+	_, apiKey, err := cache.CreateApiKey(0, cache.FindUserCacheByLogin(userEmail).CurrentUser.Id, "logout", models.ApiScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewRequest("GET", H{"Cookie": cookie}, "/logout/all", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	expected := resultOKStr // Replace with actual expected response
+	if rr.Body.String() != expected {
+		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+	if status := apiKeyStatus(apiKey); status != http.StatusUnauthorized {
+		t.Errorf("api key after logout all: wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	expected := dto.Metrics{
+		InstanceStartTime:        application.GetInstanceStartTime().Format("2006/01/02 15:04:05"),
+		UnSavedChangesQueueCount: cache.GetToSaveCount(),
+		RecoveredPanicsCount:     application.GetRecoveredPanicsCount(),
+	}
+
+	var response dto.Metrics
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if response.InstanceStartTime != expected.InstanceStartTime {
+		t.Errorf("instanceStartTime mismatch: got %v want %v", response.InstanceStartTime, expected.InstanceStartTime)
+	}
+
+	if response.UnSavedChangesQueueCount != expected.UnSavedChangesQueueCount {
+		t.Errorf("unSavedChangesQueueCount mismatch: got %v want %v", response.UnSavedChangesQueueCount, expected.UnSavedChangesQueueCount)
+	}
+
+	if response.RecoveredPanicsCount != expected.RecoveredPanicsCount {
+		t.Errorf("recoveredPanicsCount mismatch: got %v want %v", response.RecoveredPanicsCount, expected.RecoveredPanicsCount)
+	}
+
+	time.Sleep(timeSleepMs * time.Millisecond)
+}
+
+// apiKeyStatus код ответа на запрос списка объявлений пользователя с API ключом
+func apiKeyStatus(key string) int {
+	req, _ := NewRequest("GET", H{"Authorization": "Bearer " + key}, "/user/adv", nil, H{"page": "1"}, nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+// takeVerifyToken ждет письмо для email и достает токен из последнего, остальные письма выбрасываются.
+// Письма отправляются в фоне, поэтому перед config.Initialize() их нужно дождаться
+func takeVerifyToken(t *testing.T, email string) string {
+	outbox, ok := mailer.GetMailer().(*mailer.MemoryMailer)
+	if !ok {
+		t.Fatal("mailer is not in memory")
+	}
+	token := ""
+	for range 20 {
+		for _, message := range outbox.Take() {
+			if message.To != email {
+				continue
+			}
+			_, link, found := strings.Cut(message.Body, "token=")
+			if !found {
+				t.Fatalf("no link in message: %s", message.Body)
+			}
+			token, _, _ = strings.Cut(link, "\n")
+		}
+		if token != "" {
+			return token
+		}
+		time.Sleep(timeSleepMs * time.Millisecond / 5)
+	}
+	t.Fatalf("no verification message for %s", email)
+	return ""
+}
+
+func verifyEmail(t *testing.T, email string) {
+	req, err := NewRequest("GET", nil, "/verify-email", nil, H{"token": takeVerifyToken(t, email)}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("verify email: wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
 	time.Sleep(timeSleepMs * time.Millisecond)
 }
 
@@ -15,12 +15,60 @@ type User struct {
 	Description   string
 	PasswordHash  []byte   `json:"-"`
 	SessionSecret [24]byte `json:"-"` //нужно перегенерить для выхода из всех устройств
+	EmailVerified bool
+	VerifyToken   string    `json:"-"` //пустой, если email подтвержден
+	VerifyExpires time.Time `json:"-"`
+	ResetHash     []byte    `json:"-"` //sha256 от токена сброса пароля, сам токен не хранится
+	ResetExpires  time.Time `json:"-"`
+}
+
+// Session вход пользователя с одного устройства, токен авторизации ссылается на нее по Id
+type Session struct {
+	Id        int64
+	UserId    int64
+	Created   time.Time
+	LastSeen  time.Time
+	Device    string //название, которое клиент передал при входе
+	Ip        string
+	UserAgent string
+}
+
+// области действия API ключа, хранятся битовой маской
+const (
+	ApiScopeRead     uint8 = 1 << iota //чтение своих объявлений
+	ApiScopeAdvWrite                   //создание, изменение и удаление объявлений
+	ApiScopePhotos                     //загрузка и удаление фотографий
+)
+
+// ApiScopes названия областей действия в API
+var ApiScopes = map[string]uint8{
+	"read":      ApiScopeRead,
+	"adv-write": ApiScopeAdvWrite,
+	"photos":    ApiScopePhotos,
+}
+
+// ApiKey ключ для интеграций, передается в заголовке Authorization: Bearer <Id>.<секрет>
+type ApiKey struct {
+	Id         int64
+	UserId     int64
+	Name       string
+	Hash       []byte `json:"-"` //sha256 от секрета, сам ключ показывается только при создании
+	Scopes     uint8
+	Created    time.Time
+	LastUsed   time.Time //нулевое время - ключ еще не использовался
+	LastUsedIp string
 }
 
 type Invite struct {
-	Used    bool
-	Id      string
-	Company string
+	Used      bool //израсходовано или отозвано, не хранится в БД
+	Revoked   bool
+	Id        string
+	Company   string
+	CreatedBy int64
+	MaxUses   int64 //0 - без ограничений
+	Uses      int64
+	Created   time.Time
+	Expires   time.Time //нулевое время - бессрочное
 }
 
 type Photo struct {
@@ -58,9 +106,49 @@ type Adv struct {
 	UserComment  string
 	AdminComment string
 	User         *User
+	//характеристики объекта, 0 - не указано
+	DealType     int8
+	RentPeriod   int8 //только для аренды
+	PropertyType int8
+	Rooms        int8
+	Floor        int16 //подвальные этажи отрицательные
+	Floors       int16
+	YearBuilt    int16
+	TotalArea    float64 //м²
+	LivingArea   float64 //м²
+	DuplicateOf  int64   //id оригинала, если объявление признано повтором
 }
 
+// тип сделки Adv.DealType
+const (
+	DealTypeSale int8 = 1
+	DealTypeRent int8 = 2
+)
+
+// срок аренды Adv.RentPeriod
+const (
+	RentPeriodDaily   int8 = 1
+	RentPeriodMonthly int8 = 2
+)
+
+// тип недвижимости Adv.PropertyType
+const (
+	PropertyTypeFlat       int8 = 1
+	PropertyTypeRoom       int8 = 2
+	PropertyTypeHouse      int8 = 3
+	PropertyTypeLand       int8 = 4
+	PropertyTypeCommercial int8 = 5
+)
+
 type CurrencyRate struct {
 	Currency   string
 	DollarRate float64
 }
+
+type SavedSearch struct {
+	Id      int64
+	UserId  int64
+	Updated time.Time
+	Name    string
+	Filter  string //dto.GetAdvListRequest в JSON
+}
@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"realty/config"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Notification уведомление пользователя о новом объявлении, подходящем под его сохраненный поиск
+type Notification struct {
+	UserId     int64     `json:"userId"`
+	UserEmail  string    `json:"userEmail"`
+	SearchId   int64     `json:"searchId"`
+	SearchName string    `json:"searchName"`
+	AdvId      int64     `json:"advId"`
+	AdvTitle   string    `json:"advTitle"`
+	Created    time.Time `json:"created"`
+}
+
+// Sink получатель уведомлений, например outbox, из которого их забирает рассыльщик
+type Sink interface {
+	Send(notification *Notification) error
+}
+
+// FileSink дописывает уведомления в файл по одному JSON на строку
+type FileSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// MemorySink хранит уведомления в памяти без ограничения, только для тестов
+type MemorySink struct {
+	mu            sync.Mutex
+	notifications []*Notification
+}
+
+var sink atomic.Pointer[Sink]
+
+func Initialize() {
+	s, err := NewSink(config.GetNotifySink(), config.GetNotifyOutboxFilepath())
+	if err != nil {
+		panic(err)
+	}
+	SetSink(s)
+}
+
+func NewSink(kind string, path string) (Sink, error) {
+	switch kind {
+	case "memory":
+		return &MemorySink{}, nil
+	case "file":
+		return &FileSink{Path: path}, nil
+	default:
+		return nil, fmt.Errorf("notify: unknown sink %q", kind)
+	}
+}
+
+func SetSink(s Sink) {
+	sink.Store(&s)
+}
+
+func GetSink() Sink {
+	if s := sink.Load(); s != nil {
+		return *s
+	}
+	return nil
+}
+
+func Send(notification *Notification) error {
+	s := GetSink()
+	if s == nil {
+		return errors.New("notify: sink is not initialized")
+	}
+	if err := s.Send(notification); err != nil {
+		return err
+	}
+	slog.Debug("notify", "userId", notification.UserId, "searchId", notification.SearchId, "advId", notification.AdvId)
+	return nil
+}
+
+func (s *FileSink) Send(notification *Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return errors.Join(err, errors.New("notify.FileSink.Send()"))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Join(err, errors.New("notify.FileSink.Send()"))
+	}
+	defer file.Close()
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		return errors.Join(err, errors.New("notify.FileSink.Send()"))
+	}
+	return nil
+}
+
+func (s *MemorySink) Send(notification *Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifications = append(s.notifications, notification)
+	return nil
+}
+
+// Take возвращает накопленные уведомления и очищает outbox
+func (s *MemorySink) Take() []*Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := s.notifications
+	s.notifications = nil
+	return result
+}
@@ -50,6 +50,11 @@ func ParseUrlValuesTo(query url.Values, req any) error {
 		if err != nil {
 			return err
 		}
+	case *dto.GetAdvClustersRequest:
+		err := ParseQueryToGetAdvClustersRequest(query, req.(*dto.GetAdvClustersRequest))
+		if err != nil {
+			return err
+		}
 	case *dto.GetUserAdvListRequest:
 		err := ParseQueryToGetUserAdvListRequest(query, req.(*dto.GetUserAdvListRequest))
 		if err != nil {
@@ -149,8 +154,57 @@ func ParseQueryToGetAdvListRequest(query url.Values, req *dto.GetAdvListRequest)
 		req.MaxLatitude = maxLatitude
 	}
 
+	value = query.Get("lat")
+	if value != "" {
+		latitude, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("lat: %w", err)
+		}
+		req.Latitude = latitude
+	}
+
+	value = query.Get("lon")
+	if value != "" {
+		longitude, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("lon: %w", err)
+		}
+		req.Longitude = longitude
+	}
+
+	value = query.Get("radiusKm")
+	if value != "" {
+		radiusKm, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("radiusKm: %w", err)
+		}
+		req.RadiusKm = radiusKm
+	}
+
 	req.CountryCode = query.Get("countryCode")
 	req.Location = query.Get("location")
+	req.Query = query.Get("q")
+	req.DisplayCurrency = query.Get("displayCurrency")
+	req.Sort = query.Get("sort")
+	req.Cursor = query.Get("cursor")
+
+	value = query.Get("facets")
+	if value != "" {
+		facets, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("facets: %w", err)
+		}
+		req.Facets = facets
+	}
+
+	value = query.Get("area")
+	if value != "" {
+		area := &dto.GeoJSONGeometry{}
+		if err := json.Unmarshal([]byte(value), area); err != nil {
+			return fmt.Errorf("area: %w", err)
+		}
+		req.Area = area
+	}
 
 	value = query.Get("page")
 	if value != "" {
@@ -161,6 +215,15 @@ func ParseQueryToGetAdvListRequest(query url.Values, req *dto.GetAdvListRequest)
 		req.Page = page
 	}
 
+	value = query.Get("limit")
+	if value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("limit: %w", err)
+		}
+		req.Limit = limit
+	}
+
 	value = query.Get("firstNew")
 	if value != "" {
 		firstNew, err := strconv.ParseBool(value)
@@ -170,6 +233,98 @@ func ParseQueryToGetAdvListRequest(query url.Values, req *dto.GetAdvListRequest)
 		req.FirstNew = firstNew
 	}
 
+	return parseAdvAttributesFilter(query, req)
+}
+
+// parseAdvAttributesFilter разбирает фильтры по характеристикам объекта
+func parseAdvAttributesFilter(query url.Values, req *dto.GetAdvListRequest) error {
+	int8Params := []struct {
+		name string
+		dst  *int8
+	}{
+		{"dealType", &req.DealType},
+		{"rentPeriod", &req.RentPeriod},
+		{"propertyType", &req.PropertyType},
+		{"minRooms", &req.MinRooms},
+		{"maxRooms", &req.MaxRooms},
+	}
+	for _, param := range int8Params {
+		if value := query.Get(param.name); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 8)
+			if err != nil {
+				return fmt.Errorf("%s: %w", param.name, err)
+			}
+			*param.dst = int8(parsed)
+		}
+	}
+	int16Params := []struct {
+		name string
+		dst  *int16
+	}{
+		{"minFloor", &req.MinFloor},
+		{"maxFloor", &req.MaxFloor},
+		{"minFloors", &req.MinFloors},
+		{"maxFloors", &req.MaxFloors},
+		{"minYearBuilt", &req.MinYearBuilt},
+		{"maxYearBuilt", &req.MaxYearBuilt},
+	}
+	for _, param := range int16Params {
+		if value := query.Get(param.name); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 16)
+			if err != nil {
+				return fmt.Errorf("%s: %w", param.name, err)
+			}
+			*param.dst = int16(parsed)
+		}
+	}
+	floatParams := []struct {
+		name string
+		dst  *float64
+	}{
+		{"minTotalArea", &req.MinTotalArea},
+		{"maxTotalArea", &req.MaxTotalArea},
+		{"minLivingArea", &req.MinLivingArea},
+		{"maxLivingArea", &req.MaxLivingArea},
+	}
+	for _, param := range floatParams {
+		if value := query.Get(param.name); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", param.name, err)
+			}
+			*param.dst = parsed
+		}
+	}
+	return nil
+}
+
+func ParseQueryToGetAdvClustersRequest(query url.Values, req *dto.GetAdvClustersRequest) error {
+	if err := ParseQueryToGetAdvListRequest(query, &req.GetAdvListRequest); err != nil {
+		return err
+	}
+
+	value := query.Get("zoom")
+	if value != "" {
+		zoom, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("zoom: %w", err)
+		}
+		req.Zoom = zoom
+	}
+
+	value = query.Get("bbox")
+	if value != "" {
+		parts := strings.Split(value, ",")
+		req.Bbox = make([]float64, 0, len(parts))
+		for _, part := range parts {
+			coordinate, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return fmt.Errorf("bbox: %w", err)
+			}
+			req.Bbox = append(req.Bbox, coordinate)
+		}
+	}
+
 	return nil
 }
 
@@ -183,6 +338,15 @@ func ParseQueryToGetUserAdvListRequest(query url.Values, req *dto.GetUserAdvList
 		req.Page = page
 	}
 
+	value = query.Get("limit")
+	if value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("limit: %w", err)
+		}
+		req.Limit = limit
+	}
+
 	value = query.Get("firstNew")
 	if value != "" {
 		firstNew, err := strconv.ParseBool(value)
@@ -192,5 +356,8 @@ func ParseQueryToGetUserAdvListRequest(query url.Values, req *dto.GetUserAdvList
 		req.FirstNew = firstNew
 	}
 
+	req.Sort = query.Get("sort")
+	req.Cursor = query.Get("cursor")
+
 	return nil
 }
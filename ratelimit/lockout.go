@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Lockout считает неудачные попытки по ключу. После maxAttempts неудач подряд ключ блокируется на baseDelay,
+// каждая следующая неудача удваивает блокировку, но не больше maxDelay. Успешная попытка сбрасывает счетчик
+type Lockout struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	mu          sync.Mutex
+	failures    map[string]*failures
+	lastCleanup time.Time
+}
+
+type failures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// lockoutBaseDelay первая блокировка после исчерпания бесплатных попыток
+const lockoutBaseDelay = 30 * time.Second
+
+// счетчики неудачных входов и смен пароля, отдельно неудачных регистраций, создаются в Initialize.
+// Отказы регистрации (email занят, нет приглашения) не говорят о переборе паролей, поэтому не блокируют вход
+var (
+	LoginFailuresByAccount   *Lockout
+	LoginFailuresByIp        *Lockout
+	RegistrationFailuresByIp *Lockout
+)
+
+func NewLockout(maxAttempts int, baseDelay time.Duration, maxDelay time.Duration) *Lockout {
+	return &Lockout{
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		maxDelay:    maxDelay,
+		failures:    make(map[string]*failures),
+		lastCleanup: time.Now(),
+	}
+}
+
+// RetryAfter сколько осталось до конца блокировки, 0 - ключ не заблокирован
+func (l *Lockout) RetryAfter(key string) time.Duration {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f := l.failures[key]
+	if f == nil || !now.Before(f.lockedUntil) {
+		return 0
+	}
+	return f.lockedUntil.Sub(now)
+}
+
+// Fail учитывает неудачную попытку, возвращает длительность блокировки или 0
+func (l *Lockout) Fail(key string) time.Duration {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cleanup(now)
+	f := l.failures[key]
+	//неудачи давнее maxDelay забываем, чтобы редкие ошибки не копились
+	if f == nil || now.Sub(f.last) >= l.maxDelay {
+		f = &failures{}
+		l.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count < l.maxAttempts {
+		return 0
+	}
+	delay := l.maxDelay
+	if shift := f.count - l.maxAttempts; shift < 32 {
+		delay = min(l.baseDelay<<shift, l.maxDelay)
+	}
+	f.lockedUntil = now.Add(delay)
+	return delay
+}
+
+func (l *Lockout) Success(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+// Clear снимает блокировку, false - ключ не был заблокирован
+func (l *Lockout) Clear(key string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f := l.failures[key]
+	delete(l.failures, key)
+	return f != nil && now.Before(f.lockedUntil)
+}
+
+// LockedCount число заблокированных сейчас ключей
+func (l *Lockout) LockedCount() int {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := 0
+	for _, f := range l.failures {
+		if now.Before(f.lockedUntil) {
+			count++
+		}
+	}
+	return count
+}
+
+// cleanup раз в maxDelay выбрасывает забытые счетчики, чтобы map не рос бесконечно
+func (l *Lockout) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < l.maxDelay {
+		return
+	}
+	for k, f := range l.failures {
+		if now.Sub(f.last) >= l.maxDelay && !now.Before(f.lockedUntil) {
+			delete(l.failures, k)
+		}
+	}
+	l.lastCleanup = now
+}
+
+// ClearLoginLockout снимает блокировку входа и регистрации, key - email или IP
+func ClearLoginLockout(key string) bool {
+	clearedAccount := LoginFailuresByAccount.Clear(key)
+	clearedIp := LoginFailuresByIp.Clear(key)
+	clearedRegistration := RegistrationFailuresByIp.Clear(key)
+	return clearedAccount || clearedIp || clearedRegistration
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"realty/config"
+	"sync"
+	"time"
+)
+
+// Limiter пропускает не больше limit событий на ключ за окно window, окно начинается с первого события
+type Limiter struct {
+	limit       int
+	window      time.Duration
+	mu          sync.Mutex
+	hits        map[string]*counter
+	lastCleanup time.Time
+}
+
+type counter struct {
+	start time.Time
+	count int
+}
+
+// лимиты восстановления пароля, создаются в Initialize
+var (
+	PasswordResetByEmail *Limiter
+	PasswordResetByIp    *Limiter
+)
+
+func Initialize() {
+	PasswordResetByEmail = NewLimiter(config.GetPasswordResetEmailLimit(), time.Hour)
+	PasswordResetByIp = NewLimiter(config.GetPasswordResetIpLimit(), time.Hour)
+	LoginFailuresByAccount = NewLockout(config.GetLoginAccountMaxAttempts(), lockoutBaseDelay, config.GetLoginMaxLockout())
+	LoginFailuresByIp = NewLockout(config.GetLoginIpMaxAttempts(), lockoutBaseDelay, config.GetLoginMaxLockout())
+	RegistrationFailuresByIp = NewLockout(config.GetLoginIpMaxAttempts(), lockoutBaseDelay, config.GetLoginMaxLockout())
+}
+
+func NewLimiter(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:       limit,
+		window:      window,
+		hits:        make(map[string]*counter),
+		lastCleanup: time.Now(),
+	}
+}
+
+func (l *Limiter) Allow(key string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	//раз в окно выбрасываем истекшие счетчики, чтобы map не рос бесконечно
+	if now.Sub(l.lastCleanup) >= l.window {
+		for k, c := range l.hits {
+			if now.Sub(c.start) >= l.window {
+				delete(l.hits, k)
+			}
+		}
+		l.lastCleanup = now
+	}
+	c := l.hits[key]
+	if c == nil || now.Sub(c.start) >= l.window {
+		l.hits[key] = &counter{start: now, count: 1}
+		return true
+	}
+	if c.count >= l.limit {
+		return false
+	}
+	c.count++
+	return true
+}
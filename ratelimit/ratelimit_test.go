@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	limiter := NewLimiter(2, 50*time.Millisecond)
+	for i, want := range []bool{true, true, false, false} {
+		if got := limiter.Allow("a"); got != want {
+			t.Errorf("call %d: got %v want %v", i, got, want)
+		}
+	}
+	if !limiter.Allow("b") {
+		t.Error("keys must be limited separately")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !limiter.Allow("a") {
+		t.Error("limit must be reset after window")
+	}
+}
+
+func TestLimiterCleanup(t *testing.T) {
+	limiter := NewLimiter(1, 20*time.Millisecond)
+	limiter.Allow("a")
+	limiter.Allow("b")
+	time.Sleep(30 * time.Millisecond)
+	limiter.Allow("c")
+	if len(limiter.hits) != 1 {
+		t.Errorf("expired counters were not removed: %d left", len(limiter.hits))
+	}
+}
+
+func TestLockoutBackoff(t *testing.T) {
+	lockout := NewLockout(2, time.Second, 3*time.Second)
+	for i, want := range []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if got := lockout.Fail("a"); got != want {
+			t.Errorf("failure %d: got %v want %v", i, got, want)
+		}
+	}
+	if lockout.RetryAfter("a") <= 0 {
+		t.Error("key must be locked")
+	}
+	if lockout.RetryAfter("b") != 0 {
+		t.Error("keys must be counted separately")
+	}
+	if got := lockout.LockedCount(); got != 1 {
+		t.Errorf("locked count: got %d want 1", got)
+	}
+	if !lockout.Clear("a") || lockout.RetryAfter("a") != 0 {
+		t.Error("lockout must be cleared")
+	}
+	if lockout.Clear("a") {
+		t.Error("second clear must report missing lockout")
+	}
+}
+
+func TestLockoutSuccessResets(t *testing.T) {
+	lockout := NewLockout(2, time.Second, time.Minute)
+	lockout.Fail("a")
+	lockout.Success("a")
+	if got := lockout.Fail("a"); got != 0 {
+		t.Errorf("failures must be reset after success, got lock %v", got)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	mw "realty/api/middleware"
 	"realty/chain"
 	"realty/config"
+	"realty/models"
+	"realty/ratelimit"
 )
 
 var serveMux *http.ServeMux
@@ -19,29 +21,54 @@ func Initialize() *http.ServeMux {
 
 	mux.Handle("/metrics", chain.Handler(handlers.GetMetrics))
 
+	mux.Handle("POST /currency/reload", chain.Handler(mw.Auth, mw.CheckIsAdmin, handlers.ReloadCurrencyRates))
+	mux.Handle("GET /admin/duplicates", chain.Handler(mw.Auth, mw.CheckIsAdmin, mw.CheckConnectionAndTimeout, handlers.GetDuplicates))
+	mux.Handle("GET /admin/invites", chain.Handler(mw.Auth, mw.CheckIsAdmin, mw.CheckConnectionAndTimeout, handlers.GetInviteList))
+	mux.Handle("POST /admin/invites", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(500), mw.Auth, mw.CheckIsAdmin, mw.CheckConnectionAndTimeout, handlers.CreateInvite))
+	mux.Handle("DELETE /admin/invites/{inviteId}", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(300), mw.Auth, mw.CheckIsAdmin, mw.CheckConnectionAndTimeout, handlers.RevokeInvite))
+
+	mux.Handle("DELETE /admin/lockouts/{key}", chain.Handler(mw.Auth, mw.CheckIsAdmin, handlers.ClearLoginLockout))
+
 	mux.Handle("GET /generate/id", chain.Handler(mw.Auth, handlers.GenerateId))
 
-	mux.Handle("POST /login", chain.Handler(mw.Login, mw.SetAuthCookie, handlers.JsonOK).OnPanic(handlers.TextError))
-	mux.Handle("GET /logout/me", chain.Handler(handlers.LogoutMe))
+	mux.Handle("POST /login", chain.Handler(mw.BruteForceGuard(ratelimit.LoginFailuresByIp, mw.LoginAccount, mw.Login, http.StatusUnauthorized, http.StatusNotFound), mw.SetAuthCookie, handlers.JsonOK).OnPanic(handlers.TextError))
+	mux.Handle("GET /logout/me", chain.Handler(mw.OptionalAuth, handlers.LogoutMe))
 	mux.Handle("GET /logout/all", chain.Handler(mw.CheckGracefullyStop, mw.Auth, mw.StopIfUnsavedMoreThan(900), handlers.LogoutAll))
-	mux.Handle("POST /registration", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(900), handlers.Registration))
-	mux.Handle("PUT /password", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(900), mw.Auth, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.UpdatePassword))
+	mux.Handle("POST /registration", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(900), mw.BruteForceGuard(ratelimit.RegistrationFailuresByIp, nil, handlers.Registration, http.StatusForbidden, http.StatusConflict)))
+	mux.Handle("GET /verify-email", chain.Handler(mw.StopIfUnsavedMoreThan(900), handlers.VerifyEmail))
+	mux.Handle("POST /verify-email/resend", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(900), mw.Auth, mw.SetAuthCookie, handlers.ResendVerificationEmail))
+	mux.Handle("POST /password/forgot", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(900), mw.RateLimitByIp(ratelimit.PasswordResetByIp), handlers.ForgotPassword))
+	mux.Handle("POST /password/reset", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(900), mw.RateLimitByIp(ratelimit.PasswordResetByIp), handlers.ResetPassword))
+	mux.Handle("PUT /password", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(900), mw.Auth, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, mw.BruteForceGuard(ratelimit.LoginFailuresByIp, mw.CurrentUserAccount, handlers.UpdatePassword, http.StatusUnauthorized)))
 
 	mux.Handle("PUT /user", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(700), mw.Auth, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.UpdateUser).OnPanic(handlers.JsonError))
 
 	mux.Handle("GET /adv/{advId}", chain.Handler(mw.FindAdv, handlers.GetAdv))
+	mux.Handle("GET /adv/{advId}/similar", chain.Handler(mw.FindAdv, handlers.GetSimilarAdvs))
 	mux.Handle("GET /adv", chain.Handler(handlers.GetAdvList))
+	mux.Handle("POST /adv/search", chain.Handler(handlers.GetAdvList))
+	mux.Handle("GET /adv/clusters", chain.Handler(handlers.GetAdvClusters))
+
+	mux.Handle("GET /user/adv/{advId}", chain.Handler(mw.AuthScope(models.ApiScopeRead), mw.FindAdv, mw.CheckAdvOwner, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.GetUsersAdv))
+	mux.Handle("GET /user/adv", chain.Handler(mw.AuthScope(models.ApiScopeRead), mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.GetUsersAdvList))
+	mux.Handle("POST /user/adv", chain.Handler(mw.AuthScope(models.ApiScopeRead), mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.GetUsersAdvList))
 
-	mux.Handle("GET /user/adv/{advId}", chain.Handler(mw.Auth, mw.FindAdv, mw.CheckAdvOwner, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.GetUsersAdv))
-	mux.Handle("GET /user/adv", chain.Handler(mw.Auth, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.GetUsersAdvList))
-	mux.Handle("POST /user/adv", chain.Handler(mw.Auth, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.GetUsersAdvList))
+	mux.Handle("POST /adv", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(500), mw.AuthScope(models.ApiScopeAdvWrite), mw.CheckEmailVerified, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.CreateAdv))
+	mux.Handle("PUT /adv/{advId}", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(300), mw.AuthScope(models.ApiScopeAdvWrite), mw.FindAdv, mw.CheckAdvOwner, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.UpdateAdv))
+	mux.Handle("DELETE /adv/{advId}", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(300), mw.AuthScope(models.ApiScopeAdvWrite), mw.FindAdv, mw.CheckAdvOwner, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.DeleteAdv))
 
-	mux.Handle("POST /adv", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(500), mw.Auth, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.CreateAdv))
-	mux.Handle("PUT /adv/{advId}", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(300), mw.Auth, mw.FindAdv, mw.CheckAdvOwner, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.UpdateAdv))
-	mux.Handle("DELETE /adv/{advId}", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(300), mw.Auth, mw.FindAdv, mw.CheckAdvOwner, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.DeleteAdv))
+	mux.Handle("GET /user/sessions", chain.Handler(mw.Auth, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.GetSessionList))
+	mux.Handle("DELETE /user/sessions/{sessionId}", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(300), mw.Auth, mw.FindSession, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.DeleteSession))
+	mux.Handle("GET /user/api-keys", chain.Handler(mw.Auth, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.GetApiKeyList))
+	mux.Handle("POST /user/api-keys", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(500), mw.Auth, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.CreateApiKey))
+	mux.Handle("DELETE /user/api-keys/{keyId}", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(300), mw.Auth, mw.FindApiKey, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.DeleteApiKey))
+	mux.Handle("GET /user/searches", chain.Handler(mw.Auth, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.GetSavedSearchList))
+	mux.Handle("POST /user/searches", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(500), mw.Auth, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.CreateSavedSearch))
+	mux.Handle("PUT /user/searches/{searchId}", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(300), mw.Auth, mw.FindSavedSearch, mw.CheckSavedSearchOwner, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.UpdateSavedSearch))
+	mux.Handle("DELETE /user/searches/{searchId}", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(300), mw.Auth, mw.FindSavedSearch, mw.CheckSavedSearchOwner, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.DeleteSavedSearch))
 
-	mux.Handle("POST /adv/{advId}/photos", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(200), mw.Auth, mw.FindAdv, mw.CheckAdvOwner, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.AddAdvPhoto))
-	mux.Handle("DELETE /adv/{advId}/photos/{photoId}", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(200), mw.Auth, mw.FindAdv, mw.CheckAdvOwner, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.DeleteAdvPhoto))
+	mux.Handle("POST /adv/{advId}/photos", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(200), mw.AuthScope(models.ApiScopePhotos), mw.FindAdv, mw.CheckAdvOwner, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.AddAdvPhoto))
+	mux.Handle("DELETE /adv/{advId}/photos/{photoId}", chain.Handler(mw.CheckGracefullyStop, mw.StopIfUnsavedMoreThan(200), mw.AuthScope(models.ApiScopePhotos), mw.FindAdv, mw.CheckAdvOwner, mw.CheckConnectionAndTimeout, mw.SetAuthCookie, handlers.DeleteAdvPhoto))
 
 	serveMux = mux
 	return mux
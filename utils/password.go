@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"realty/config"
+)
+
+// формат хеша пароля: версия, число итераций, соль, ключ PBKDF2-HMAC-SHA256
+const (
+	passwordHashVersion = 1
+	passwordSaltLength  = 16
+	passwordKeyLength   = 32
+	passwordHashLength  = 1 + 4 + passwordSaltLength + passwordKeyLength
+)
+
+// legacyPasswordPepper использовался в старых хешах SHA-1 без соли, нужен только для их проверки
+const legacyPasswordPepper = "bla bla secret 36464663464"
+
+// GeneratePasswordHash хеш пароля с новой солью и текущим числом итераций из конфига
+func GeneratePasswordHash(password string) []byte {
+	hash := make([]byte, passwordHashLength)
+	hash[0] = passwordHashVersion
+	binary.BigEndian.PutUint32(hash[1:5], uint32(config.GetPasswordHashIterations()))
+	salt := hash[5 : 5+passwordSaltLength]
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	copy(hash[5+passwordSaltLength:], derivePasswordKey(password, salt, config.GetPasswordHashIterations()))
+	return hash
+}
+
+// CheckPassword сравнивает пароль с хешем за постоянное время.
+// needsRehash - хеш старого формата или посчитан с меньшим числом итераций, чем сейчас в конфиге
+func CheckPassword(password string, hash []byte) (ok bool, needsRehash bool) {
+	if len(hash) == sha1.Size {
+		legacy := sha1.New()
+		legacy.Write(UnsafeStringToBytes(password))
+		legacy.Write(UnsafeStringToBytes(legacyPasswordPepper))
+		return subtle.ConstantTimeCompare(legacy.Sum(nil), hash) == 1, true
+	}
+	if len(hash) != passwordHashLength || hash[0] != passwordHashVersion {
+		return false, false
+	}
+	iterations := int(binary.BigEndian.Uint32(hash[1:5]))
+	salt := hash[5 : 5+passwordSaltLength]
+	key := derivePasswordKey(password, salt, iterations)
+	ok = subtle.ConstantTimeCompare(key, hash[5+passwordSaltLength:]) == 1
+	return ok, iterations < config.GetPasswordHashIterations()
+}
+
+// derivePasswordKey PBKDF2-HMAC-SHA256 от пароля, подписанного перцем из конфига
+func derivePasswordKey(password string, salt []byte, iterations int) []byte {
+	peppered := hmac.New(sha256.New, UnsafeStringToBytes(config.GetPasswordPepper()))
+	peppered.Write(UnsafeStringToBytes(password))
+	key, err := pbkdf2.Key(sha256.New, string(peppered.Sum(nil)), salt, iterations, passwordKeyLength)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
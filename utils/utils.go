@@ -1,9 +1,11 @@
 package utils
 
 import (
-	"crypto/sha1"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base32"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 	"unsafe"
@@ -23,6 +25,11 @@ func GenerateId() int64 {
 	return newId
 }
 
+// NormalizeEmail email в том виде, в котором он хранится и ищется: без пробелов по краям и в нижнем регистре
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 // UnsafeStringToBytes converts string to byte slice without a memory allocation.
 // For more details, see https://github.com/golang/go/issues/53003#issuecomment-1140276077.
 func UnsafeStringToBytes(s string) []byte {
@@ -67,11 +74,18 @@ func NormalizeSlice(s []any) {
 	}
 }
 
-func GeneratePasswordHash(password string) []byte {
-	hash := sha1.New()
-	hash.Write(UnsafeStringToBytes(password))
-	hash.Write(UnsafeStringToBytes("bla bla secret 36464663464"))
-	return hash.Sum(nil)
+// GenerateInviteId случайный код приглашения из 16 символов base32
+func GenerateInviteId() string {
+	return GenerateRandomToken(10)
+}
+
+// GenerateRandomToken size случайных байт в base32, длина строки size*8/5 с округлением вверх
+func GenerateRandomToken(size int) string {
+	randomBytes := make([]byte, size)
+	if _, err := rand.Read(randomBytes); err != nil {
+		panic(err)
+	}
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
 }
 
 func GenerateSessionsSecret(randomBytes []byte) [24]byte {
@@ -3,7 +3,10 @@ package validator
 import (
 	"errors"
 	"fmt"
+	"realty/currency"
 	"realty/dto"
+	"realty/fulltext"
+	"realty/models"
 	"regexp"
 	"time"
 )
@@ -15,6 +18,28 @@ func IsValidUnixNanoId(id int64) bool {
 var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
 var photoFilenameRegex = regexp.MustCompile(`^\d{19}\.(png|jpg|gif)$`)
 
+// ограничения характеристик объекта, maxYearBuiltAhead - для строящихся домов
+const (
+	maxRooms          = 100
+	minFloor          = -10
+	maxFloors         = 200
+	maxArea           = 1_000_000
+	minYearBuilt      = 1000
+	maxYearBuiltAhead = 10
+)
+
+// ограничения приглашений, maxInviteTTL - самый дальний срок действия
+const (
+	maxInviteUses = 10_000
+	maxInviteTTL  = time.Hour * 24 * 365
+)
+
+// maxDeviceLength название устройства сессии, задается пользователем при входе
+const maxDeviceLength = 100
+
+// maxRadiusKm половина длины экватора, больший радиус покрывает всю Землю
+const maxRadiusKm = 20038
+
 func ValidateLoginRequest(req *dto.LoginRequest) error {
 	if err := validateEmail(req.Email); err != nil {
 		return err
@@ -22,6 +47,9 @@ func ValidateLoginRequest(req *dto.LoginRequest) error {
 	if err := validatePassword(req.Password); err != nil {
 		return err
 	}
+	if len(req.Device) > maxDeviceLength {
+		return errors.New("invalid device")
+	}
 	return nil
 }
 
@@ -84,6 +112,10 @@ func ValidateCreateAdvRequest(req *dto.CreateAdvRequest) error {
 	if err := validateUserComment(req.UserComment); err != nil {
 		return err
 	}
+	if err := validateAdvAttributes(req.DealType, req.RentPeriod, req.PropertyType, req.Rooms,
+		req.Floor, req.Floors, req.YearBuilt, req.TotalArea, req.LivingArea); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -130,6 +162,10 @@ func ValidateUpdateAdvRequest(req *dto.UpdateAdvRequest) error {
 	if err := validateUserComment(req.UserComment); err != nil {
 		return err
 	}
+	if err := validateAdvAttributes(req.DealType, req.RentPeriod, req.PropertyType, req.Rooms,
+		req.Floor, req.Floors, req.YearBuilt, req.TotalArea, req.LivingArea); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -160,6 +196,20 @@ func ValidateUpdatePasswordRequest(req *dto.UpdatePasswordRequest) error {
 	return nil
 }
 
+func ValidateForgotPasswordRequest(req *dto.ForgotPasswordRequest) error {
+	return validateEmail(req.Email)
+}
+
+func ValidateResetPasswordRequest(req *dto.ResetPasswordRequest) error {
+	if len(req.Token) == 0 || len(req.Token) > 64 {
+		return errors.New("invalid token")
+	}
+	if err := validatePassword(req.NewPassword); err != nil {
+		return err
+	}
+	return nil
+}
+
 func ValidateGetAdvListRequest(req *dto.GetAdvListRequest) error {
 	if err := validateCurrency(req.Currency); err != nil {
 		return fmt.Errorf("currency: %w", err)
@@ -182,22 +232,136 @@ func ValidateGetAdvListRequest(req *dto.GetAdvListRequest) error {
 	if err := validateMaxLatitude(req.MaxLatitude); err != nil {
 		return fmt.Errorf("maxLatitude: %w", err)
 	}
+	if err := validateLatitude(req.Latitude); err != nil {
+		return fmt.Errorf("lat: %w", err)
+	}
+	if err := validateLongitude(req.Longitude); err != nil {
+		return fmt.Errorf("lon: %w", err)
+	}
+	if err := validateRadiusKm(req.RadiusKm); err != nil {
+		return fmt.Errorf("radiusKm: %w", err)
+	}
+	if err := validateSort(req.Sort, req.RadiusKm, req.Query); err != nil {
+		return fmt.Errorf("sort: %w", err)
+	}
 	if err := validateCountryCode(req.CountryCode); err != nil {
 		return fmt.Errorf("countryCode: %w", err)
 	}
 	if err := validateLocation(req.Location); err != nil {
 		return fmt.Errorf("location: %w", err)
 	}
-	if err := validatePage(req.Page); err != nil {
-		return fmt.Errorf("page: %w", err)
+	if err := validateQuery(req.Query); err != nil {
+		return fmt.Errorf("q: %w", err)
+	}
+	if err := validateAdvAttributesFilter(req); err != nil {
+		return err
+	}
+	if err := validatePageOrCursor(req.Page, req.Cursor); err != nil {
+		return err
+	}
+	if err := validateLimit(req.Limit); err != nil {
+		return fmt.Errorf("limit: %w", err)
+	}
+	if err := ValidateDisplayCurrency(req.DisplayCurrency); err != nil {
+		return fmt.Errorf("displayCurrency: %w", err)
 	}
 
 	return nil
 }
 
+// ValidateDisplayCurrency пустое значение означает, что пересчет цены не нужен
+func ValidateDisplayCurrency(displayCurrency string) error {
+	if displayCurrency == "" {
+		return nil
+	}
+	if err := validateCurrency(displayCurrency); err != nil {
+		return err
+	}
+	if !currency.HasRate(displayCurrency) {
+		return fmt.Errorf("no rate for currency")
+	}
+	return nil
+}
+
 func ValidateGetUserAdvListRequest(req *dto.GetUserAdvListRequest) error {
-	if err := validatePage(req.Page); err != nil {
-		return fmt.Errorf("page: %w", err)
+	if err := validatePageOrCursor(req.Page, req.Cursor); err != nil {
+		return err
+	}
+	if err := validateLimit(req.Limit); err != nil {
+		return fmt.Errorf("limit: %w", err)
+	}
+	if err := validateSort(req.Sort, 0, ""); err != nil {
+		return fmt.Errorf("sort: %w", err)
+	}
+
+	return nil
+}
+
+// ValidateGetAdvClustersRequest page и cursor для кластеров не нужны, поэтому не проверяются
+func ValidateGetAdvClustersRequest(req *dto.GetAdvClustersRequest, maxZoom int) error {
+	if req.Zoom < 0 || req.Zoom > maxZoom {
+		return fmt.Errorf("zoom: zoom must be within [0, %d]", maxZoom)
+	}
+	if err := validateBbox(req.Bbox); err != nil {
+		return fmt.Errorf("bbox: %w", err)
+	}
+	filter := req.GetAdvListRequest
+	filter.Page = 1
+	filter.Cursor = ""
+	return ValidateGetAdvListRequest(&filter)
+}
+
+func ValidateVerifyToken(token string) error {
+	if len(token) == 0 || len(token) > 64 {
+		return errors.New("invalid token")
+	}
+	return nil
+}
+
+func ValidateCreateInviteRequest(req *dto.CreateInviteRequest) error {
+	if len(req.Company) > 100 {
+		return errors.New("invalid company")
+	}
+	if req.MaxUses < 0 || req.MaxUses > maxInviteUses {
+		return errors.New("invalid maxUses")
+	}
+	if !req.Expires.IsZero() {
+		now := time.Now()
+		if !req.Expires.After(now) || req.Expires.After(now.Add(maxInviteTTL)) {
+			return errors.New("invalid expires")
+		}
+	}
+	return nil
+}
+
+func ValidateCreateApiKeyRequest(req *dto.CreateApiKeyRequest) error {
+	if len(req.Name) == 0 || len(req.Name) > 100 {
+		return errors.New("invalid name")
+	}
+	if len(req.Scopes) == 0 {
+		return errors.New("scopes is required")
+	}
+	for _, scope := range req.Scopes {
+		if _, ok := models.ApiScopes[scope]; !ok {
+			return fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// ValidateSavedSearchRequest page и cursor в сохраненном фильтре не нужны, поэтому не проверяются
+func ValidateSavedSearchRequest(req *dto.SavedSearchRequest) error {
+	if err := validateSavedSearchName(req.Name); err != nil {
+		return fmt.Errorf("name: %w", err)
+	}
+	if req.Filter == nil {
+		return errors.New("filter: filter is required")
+	}
+	filter := *req.Filter
+	filter.Page = 1
+	filter.Cursor = ""
+	if err := ValidateGetAdvListRequest(&filter); err != nil {
+		return fmt.Errorf("filter.%w", err)
 	}
 
 	return nil
@@ -224,6 +388,13 @@ func validateName(name string) error {
 	return nil
 }
 
+func validateSavedSearchName(name string) error {
+	if len(name) == 0 || len(name) > 100 {
+		return errors.New("invalid name")
+	}
+	return nil
+}
+
 func validateInviteId(inviteId string) error {
 	if len(inviteId) != 0 && len(inviteId) > 20 {
 		return errors.New("invalid inviteId")
@@ -322,10 +493,139 @@ func validateUserComment(userComment string) error {
 	return nil
 }
 
-func validateCurrency(currency string) error {
-	if len(currency) != 3 {
+// validateAdvAttributes характеристики объекта необязательны, 0 - не указано
+func validateAdvAttributes(dealType, rentPeriod, propertyType, rooms int8, floor, floors, yearBuilt int16, totalArea, livingArea float64) error {
+	if err := validateDealType(dealType); err != nil {
+		return err
+	}
+	if err := validateRentPeriod(rentPeriod); err != nil {
+		return err
+	}
+	if rentPeriod != 0 && dealType != models.DealTypeRent {
+		return errors.New("rentPeriod is allowed only for rent")
+	}
+	if err := validatePropertyType(propertyType); err != nil {
+		return err
+	}
+	if rooms < 0 || rooms > maxRooms {
+		return errors.New("invalid rooms")
+	}
+	if floor < minFloor || floor > maxFloors {
+		return errors.New("invalid floor")
+	}
+	if floors < 0 || floors > maxFloors {
+		return errors.New("invalid floors")
+	}
+	if floor != 0 && floors != 0 && floor > floors {
+		return errors.New("floor must be less than or equal to floors")
+	}
+	if err := validateYearBuilt(yearBuilt); err != nil {
+		return err
+	}
+	if totalArea < 0 || totalArea > maxArea {
+		return errors.New("invalid totalArea")
+	}
+	if livingArea < 0 || livingArea > maxArea {
+		return errors.New("invalid livingArea")
+	}
+	if livingArea != 0 && totalArea != 0 && livingArea > totalArea {
+		return errors.New("livingArea must be less than or equal to totalArea")
+	}
+	return nil
+}
+
+// validateAdvAttributesFilter для диапазонов 0 означает отсутствие ограничения
+func validateAdvAttributesFilter(req *dto.GetAdvListRequest) error {
+	if err := validateDealType(req.DealType); err != nil {
+		return fmt.Errorf("dealType: %w", err)
+	}
+	if err := validateRentPeriod(req.RentPeriod); err != nil {
+		return fmt.Errorf("rentPeriod: %w", err)
+	}
+	if err := validatePropertyType(req.PropertyType); err != nil {
+		return fmt.Errorf("propertyType: %w", err)
+	}
+	if err := validateRange(req.MinRooms, req.MaxRooms, 0, maxRooms); err != nil {
+		return fmt.Errorf("rooms: %w", err)
+	}
+	if err := validateRange(req.MinFloor, req.MaxFloor, minFloor, maxFloors); err != nil {
+		return fmt.Errorf("floor: %w", err)
+	}
+	if err := validateRange(req.MinFloors, req.MaxFloors, 0, maxFloors); err != nil {
+		return fmt.Errorf("floors: %w", err)
+	}
+	if err := validateRange(req.MinYearBuilt, req.MaxYearBuilt, 0, int16(time.Now().Year()+maxYearBuiltAhead)); err != nil {
+		return fmt.Errorf("yearBuilt: %w", err)
+	}
+	if err := validateRange(req.MinTotalArea, req.MaxTotalArea, 0, maxArea); err != nil {
+		return fmt.Errorf("totalArea: %w", err)
+	}
+	if err := validateRange(req.MinLivingArea, req.MaxLivingArea, 0, maxArea); err != nil {
+		return fmt.Errorf("livingArea: %w", err)
+	}
+	return nil
+}
+
+// validateBbox minLongitude > maxLongitude допустимо для области, пересекающей 180-й меридиан
+func validateBbox(bbox []float64) error {
+	if len(bbox) != 4 {
+		return errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+	minLongitude, minLatitude, maxLongitude, maxLatitude := bbox[0], bbox[1], bbox[2], bbox[3]
+	if minLongitude < -180 || minLongitude > 180 || maxLongitude < -180 || maxLongitude > 180 {
+		return errors.New("invalid longitude")
+	}
+	if minLatitude < -90 || maxLatitude > 90 || minLatitude >= maxLatitude {
+		return errors.New("invalid latitude")
+	}
+	return nil
+}
+
+func validateRange[T int8 | int16 | float64](minValue, maxValue, lowest, highest T) error {
+	if minValue < lowest || minValue > highest || maxValue < lowest || maxValue > highest {
+		return fmt.Errorf("range must be within [%v, %v]", lowest, highest)
+	}
+	if minValue != 0 && maxValue != 0 && minValue > maxValue {
+		return errors.New("min must be less than or equal to max")
+	}
+	return nil
+}
+
+func validateDealType(dealType int8) error {
+	if dealType != 0 && dealType != models.DealTypeSale && dealType != models.DealTypeRent {
+		return errors.New("invalid dealType")
+	}
+	return nil
+}
+
+func validateRentPeriod(rentPeriod int8) error {
+	if rentPeriod != 0 && rentPeriod != models.RentPeriodDaily && rentPeriod != models.RentPeriodMonthly {
+		return errors.New("invalid rentPeriod")
+	}
+	return nil
+}
+
+func validatePropertyType(propertyType int8) error {
+	if propertyType < 0 || propertyType > models.PropertyTypeCommercial {
+		return errors.New("invalid propertyType")
+	}
+	return nil
+}
+
+func validateYearBuilt(yearBuilt int16) error {
+	if yearBuilt != 0 && (yearBuilt < minYearBuilt || int(yearBuilt) > time.Now().Year()+maxYearBuiltAhead) {
+		return errors.New("invalid yearBuilt")
+	}
+	return nil
+}
+
+func validateCurrency(code string) error {
+	if len(code) != 3 {
 		return fmt.Errorf("currency must be 3 characters long")
 	}
+	if !currency.IsValidCurrency(code) {
+		return fmt.Errorf("unknown currency")
+	}
 	return nil
 }
 
@@ -383,6 +683,36 @@ func validateMaxLatitude(maxLatitude float64) error {
 	return nil
 }
 
+func validateRadiusKm(radiusKm float64) error {
+	if radiusKm < 0 {
+		return fmt.Errorf("radiusKm must be greater than or equal to 0")
+	}
+	if radiusKm > maxRadiusKm {
+		return fmt.Errorf("radiusKm must be less than or equal to %d", maxRadiusKm)
+	}
+	return nil
+}
+
+// validateSort sort по distance возможен только при поиске по радиусу, по relevance - при текстовом запросе
+func validateSort(sort string, radiusKm float64, query string) error {
+	switch sort {
+	case "", "price", "-price", "updated", "-updated", "watches", "-watches":
+		return nil
+	case "distance", "-distance":
+		if radiusKm <= 0 {
+			return fmt.Errorf("sort by distance requires radiusKm")
+		}
+		return nil
+	case "relevance":
+		if query == "" {
+			return fmt.Errorf("sort by relevance requires q")
+		}
+		return nil
+	default:
+		return fmt.Errorf("sort must be one of price, updated, watches, distance with optional - prefix for descending order, or relevance")
+	}
+}
+
 func validateCountryCode(countryCode string) error {
 	if countryCode == "" {
 		return nil
@@ -403,6 +733,19 @@ func validateLocation(location string) error {
 	return nil
 }
 
+func validateQuery(query string) error {
+	if query == "" {
+		return nil
+	}
+	if len(query) > 255 {
+		return fmt.Errorf("q must be at most 255 characters long")
+	}
+	if len(fulltext.Tokenize(query)) == 0 {
+		return fmt.Errorf("q must contain at least one word")
+	}
+	return nil
+}
+
 func validatePage(page int) error {
 	if page < 1 {
 		return fmt.Errorf("page must be greater than or equal to 1")
@@ -410,6 +753,28 @@ func validatePage(page int) error {
 	return nil
 }
 
+// validatePageOrCursor при переданном cursor page не используется
+func validatePageOrCursor(page int, cursor string) error {
+	if cursor == "" {
+		if err := validatePage(page); err != nil {
+			return fmt.Errorf("page: %w", err)
+		}
+		return nil
+	}
+	if len(cursor) > 512 {
+		return fmt.Errorf("cursor: cursor is too long")
+	}
+	return nil
+}
+
+// validateLimit 0 означает размер страницы по умолчанию, слишком большой limit уменьшается до максимума
+func validateLimit(limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("limit must be greater than or equal to 0")
+	}
+	return nil
+}
+
 func validatePhotoFilename(filename string) error {
 	if !photoFilenameRegex.MatchString(filename) {
 		return errors.New("invalid photo filename")